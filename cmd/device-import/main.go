@@ -0,0 +1,237 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/pvbuilder"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+// options of the generated manifests
+type options struct {
+	Name            string
+	Node            string
+	PartUUID        string
+	DiskID          string
+	Capacity        string
+	DevName         string
+	Allocation      string
+	FsType          string
+	VolumeMode      string
+	AccessMode      string
+	ReclaimPolicy   string
+	StorageClass    string
+	PVCName         string
+	PVCNamespace    string
+	DeviceNamespace string
+	DriverName      string
+}
+
+/*
+ * device-import prints the DeviceVolume, the PersistentVolume and the
+ * PersistentVolumeClaim adopting an existing partition or disk of a node
+ * as a statically provisioned volume. The node agent renames the imported
+ * partition, or claims the imported disk, without wiping it once the
+ * DeviceVolume is applied.
+ */
+func main() {
+	o := &options{}
+	cmd := &cobra.Command{
+		Use:   "device-import",
+		Short: "generates the manifests importing an existing partition or disk",
+		Long: `prints the DeviceVolume, PersistentVolume and PersistentVolumeClaim
+		    adopting an existing partition or disk as a volume, to be applied
+		    with kubectl apply -f.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(o, os.Stdout)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVar(&o.Name, "name", "", "Name of the volume, the DeviceVolume and the PersistentVolume")
+	cmd.Flags().StringVar(&o.Node, "node", "", "Node holding the partition or the disk")
+	cmd.Flags().StringVar(&o.PartUUID, "partuuid", "", "GPT partition GUID of the imported partition, as in /dev/disk/by-partuuid")
+	cmd.Flags().StringVar(&o.DiskID, "disk-id", "", "/dev/disk/by-id name of the imported partition or disk")
+	cmd.Flags().StringVar(&o.Capacity, "capacity", "", "Capacity of the volume (e.g: `100Gi`), at most the size of the partition or disk")
+	cmd.Flags().StringVar(&o.DevName, "devname", "", "Device name of the volume, the meta partition name of the disk holding the partition. Defaults to the disk id for whole disks.")
+	cmd.Flags().StringVar(&o.Allocation, "allocation", device.AllocationPartition, "Allocation of the volume, partition or wholeDisk")
+	cmd.Flags().StringVar(&o.FsType, "fs-type", "ext4", "Filesystem of the volume, ignored for block volumes")
+	cmd.Flags().StringVar(&o.VolumeMode, "volume-mode", string(corev1.PersistentVolumeFilesystem), "Volume mode, Filesystem or Block")
+	cmd.Flags().StringVar(&o.AccessMode, "access-mode", string(corev1.ReadWriteOnce), "Access mode of the volume")
+	cmd.Flags().StringVar(&o.ReclaimPolicy, "reclaim-policy", string(corev1.PersistentVolumeReclaimRetain), "Reclaim policy of the PersistentVolume, Retain or Delete. Delete wipes the imported data along with the volume.")
+	cmd.Flags().StringVar(&o.StorageClass, "storage-class", "", "StorageClass of the PersistentVolume and of the PersistentVolumeClaim")
+	cmd.Flags().StringVar(&o.PVCName, "pvc-name", "", "Name of the PersistentVolumeClaim bound to the volume. No claim is generated when empty.")
+	cmd.Flags().StringVar(&o.PVCNamespace, "pvc-namespace", "default", "Namespace of the PersistentVolumeClaim")
+	cmd.Flags().StringVar(&o.DeviceNamespace, "device-namespace", "openebs", "Namespace of the DeviceVolumes, where the driver is installed")
+	cmd.Flags().StringVar(&o.DriverName, "driver-name", "device.csi.openebs.io", "Name of the CSI driver")
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func run(o *options, w io.Writer) error {
+	objects, err := generate(o)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the options and sets the defaults depending on others
+func (o *options) validate() error {
+	switch {
+	case o.Name == "":
+		return errors.New("--name is required")
+	case o.Node == "":
+		return errors.New("--node is required")
+	case o.Capacity == "":
+		return errors.New("--capacity is required")
+	case (o.PartUUID == "") == (o.DiskID == ""):
+		return errors.New("exactly one of --partuuid and --disk-id is required")
+	}
+	switch o.Allocation {
+	case device.AllocationPartition:
+		if o.DevName == "" {
+			return errors.New("--devname is required to import a partition")
+		}
+	case device.AllocationWholeDisk:
+		if o.DiskID == "" {
+			return errors.New("--disk-id is required to import a whole disk")
+		}
+		if o.DevName == "" {
+			o.DevName = "^" + regexp.QuoteMeta(o.DiskID) + "$"
+		}
+	default:
+		return fmt.Errorf("invalid allocation %q, the partition and wholeDisk volumes can be imported", o.Allocation)
+	}
+	switch corev1.PersistentVolumeMode(o.VolumeMode) {
+	case corev1.PersistentVolumeFilesystem, corev1.PersistentVolumeBlock:
+	default:
+		return fmt.Errorf("invalid volume mode %q", o.VolumeMode)
+	}
+	switch corev1.PersistentVolumeReclaimPolicy(o.ReclaimPolicy) {
+	case corev1.PersistentVolumeReclaimRetain, corev1.PersistentVolumeReclaimDelete:
+	default:
+		return fmt.Errorf("invalid reclaim policy %q", o.ReclaimPolicy)
+	}
+	return nil
+}
+
+// generate builds the DeviceVolume, the PersistentVolume and, when a claim
+// name is given, the PersistentVolumeClaim importing the partition or disk.
+func generate(o *options) ([]interface{}, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	capacity, err := resource.ParseQuantity(o.Capacity)
+	if err != nil || capacity.Value() <= 0 {
+		return nil, fmt.Errorf("invalid capacity %q", o.Capacity)
+	}
+
+	annotations := map[string]string{}
+	if o.PVCName != "" {
+		annotations[device.PVCNameKey] = o.PVCName
+		annotations[device.PVCNamespaceKey] = o.PVCNamespace
+	}
+
+	// the volume is created Pending and without finalizer, so that the
+	// node agent imports it like it provisions the dynamic volumes
+	vol, err := volbuilder.NewBuilder().
+		WithName(o.Name).
+		WithNamespace(o.DeviceNamespace).
+		WithCapacity(strconv.FormatInt(capacity.Value(), 10)).
+		WithOwnerNode(o.Node).
+		WithDeviceName(o.DevName).
+		WithAllocation(o.Allocation).
+		WithVolumeMode(o.VolumeMode).
+		WithImport(apis.VolumeImport{PartUUID: o.PartUUID, DiskID: o.DiskID}).
+		WithAnnotations(annotations).
+		WithVolumeStatus(device.DeviceStatusPending).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	if o.VolumeMode == string(corev1.PersistentVolumeFilesystem) {
+		vol.Spec.FsType = o.FsType
+	}
+	vol.TypeMeta = metav1.TypeMeta{
+		APIVersion: apis.SchemeGroupVersion.String(),
+		Kind:       "DeviceVolume",
+	}
+
+	volumeMode := corev1.PersistentVolumeMode(o.VolumeMode)
+	accessMode := corev1.PersistentVolumeAccessMode(o.AccessMode)
+	pvBuilder := pvbuilder.NewBuilder(vol, o.DriverName).
+		WithVolumeMode(volumeMode).
+		WithAccessMode(accessMode).
+		WithReclaimPolicy(corev1.PersistentVolumeReclaimPolicy(o.ReclaimPolicy)).
+		WithStorageClass(o.StorageClass)
+	if o.PVCName == "" {
+		pv, err := pvBuilder.Build()
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{vol, pv}, nil
+	}
+
+	pv, err := pvBuilder.WithClaimRef(o.PVCNamespace, o.PVCName).Build()
+	if err != nil {
+		return nil, err
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      o.PVCName,
+			Namespace: o.PVCNamespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{accessMode},
+			VolumeMode:       &volumeMode,
+			VolumeName:       o.Name,
+			StorageClassName: &o.StorageClass,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: pv.Spec.Capacity[corev1.ResourceStorage]},
+			},
+		},
+	}
+	return []interface{}{vol, pv, pvc}, nil
+}
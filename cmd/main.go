@@ -22,13 +22,16 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"time"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
 
 	"github.com/openebs/device-localpv/pkg/config"
 	"github.com/openebs/device-localpv/pkg/device"
 	"github.com/openebs/device-localpv/pkg/driver"
+	"github.com/openebs/device-localpv/pkg/mgmt/orphan"
 	"github.com/openebs/device-localpv/pkg/version"
 )
 
@@ -92,6 +95,58 @@ func main() {
 		&config.IgnoreBlockDevicesRegex, "ignore-block-devices-regex", "", "Ignore the block devices by specifying the matching regular expression",
 	)
 
+	cmd.PersistentFlags().StringVar(
+		&config.FilePoolDir, "file-pool-dir", "/var/openebs/device-pools", "Host directory where the backing files of the file pools are created",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.FilePools, "file-pools", "", "Comma separated list of file backed loop device pools in name=size format (e.g: `dev-pool=10Gi`). Default is empty string, which means file pools are disabled.",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ImageDir, "image-dir", "/var/openebs/device-images", "Host directory holding the disk images which volumes can be populated with from a local path",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.PublishDir, "publish-dir", "/var/lib/kubelet/plugins/device-localpv/publications", "Host directory where the access modes of the published volumes are recorded",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.WholeDiskRegex, "whole-disk-regex", "", "Unpartitioned disks which can be claimed entirely by a volume, specified by the regular expression matching the disk name (e.g: `^sd[c-f]$`). Default is empty string, which means whole disk allocation is disabled.",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.DiscardInterval, "discard-interval", 24*time.Hour, "Interval between the trims of the mounted volumes having the periodic discard. Zero disables the periodic trims.",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.DiscardRateLimit, "discard-rate-limit", "1Gi", "Quantity of bytes trimmed per second by the periodic discard (e.g: `512Mi`)",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ThinPoolSize, "thin-pool-size", "", "Size of the data partition of the new dm-thin pools (e.g: `100Gi`), capped to the free space of the device. Default is empty string, which means the pools are sized for their first volume. The pools grow into the free space following them when needed.",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.CgroupRoot, "cgroup-root", device.DefaultCgroupRoot, "Path where the cgroup v2 hierarchy of the host is mounted, the IO limits of the volumes are set in the cgroups of the pods",
+	)
+
+	cmd.AddCommand(newRecoverCommand(config))
+	cmd.AddCommand(newManageDiskCommand(config))
+	cmd.AddCommand(newUndeleteCommand())
+
+	cmd.PersistentFlags().StringVar(
+		&config.OrphanCleanup, "orphan-cleanup", "", "Cleanup policy of the partitions belonging to no volume. Empty string only reports them, `delete-after=24h` deletes the unused ones orphaned for longer than the duration.",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.EphemeralDevices, "ephemeral-devices", "", "Comma separated list of the devnames the CSI ephemeral inline volumes can be created on (e.g: `scratch-device`). Default is empty string, which means ephemeral inline volumes are disabled.",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.InstanceID, "instance-id", "", "Id of the driver instance recorded in the journals of the disks it owns, the disks owned by other instances are left alone. Default is empty string, which means the driver name qualified with the uid of the kube-system namespace.",
+	)
+
 	err := cmd.Execute()
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s", err.Error())
@@ -113,13 +168,56 @@ func run(config *config.Config) {
 		config.NodeID,
 	)
 
+	setDeviceConfiguration(config)
+
+	err := driver.New(config).Run()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	os.Exit(0)
+}
+
+// setDeviceConfiguration applies the flags configuring how the
+// devices of the node are managed
+func setDeviceConfiguration(config *config.Config) {
 	if len(config.IgnoreBlockDevicesRegex) > 0 {
 		device.DeviceConfiguration.IgnoreBlockDevicesRegex = regexp.MustCompile(config.IgnoreBlockDevicesRegex)
 	}
 
-	err := driver.New(config).Run()
+	if len(config.WholeDiskRegex) > 0 {
+		device.DeviceConfiguration.WholeDiskRegex = regexp.MustCompile(config.WholeDiskRegex)
+	}
+
+	filePools, err := device.ParseFilePools(config.FilePools)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	os.Exit(0)
+	device.DeviceConfiguration.FilePoolDir = config.FilePoolDir
+	device.DeviceConfiguration.FilePools = filePools
+	device.DeviceConfiguration.ImageDir = config.ImageDir
+	device.DeviceConfiguration.PublishDir = config.PublishDir
+
+	discardRateLimit, err := resource.ParseQuantity(config.DiscardRateLimit)
+	if err != nil || discardRateLimit.Value() <= 0 {
+		log.Fatalf("invalid discard rate limit %q", config.DiscardRateLimit)
+	}
+	device.DeviceConfiguration.DiscardInterval = config.DiscardInterval
+	device.DeviceConfiguration.DiscardRateLimit = uint64(discardRateLimit.Value())
+	device.DeviceConfiguration.CgroupRoot = config.CgroupRoot
+
+	if config.ThinPoolSize != "" {
+		thinPoolSize, err := resource.ParseQuantity(config.ThinPoolSize)
+		if err != nil || thinPoolSize.Value() < 0 {
+			log.Fatalf("invalid thin pool size %q", config.ThinPoolSize)
+		}
+		device.DeviceConfiguration.ThinPoolSize = uint64(thinPoolSize.Value())
+	}
+
+	orphanDeleteAfter, err := orphan.ParseCleanupPolicy(config.OrphanCleanup)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	device.DeviceConfiguration.OrphanDeleteAfter = orphanDeleteAfter
+	device.DeviceConfiguration.InstanceID = config.InstanceID
+	device.DeviceConfiguration.DriverName = config.DriverName
 }
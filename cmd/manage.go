@@ -0,0 +1,96 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openebs/device-localpv/pkg/config"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+// manageDiskOptions are the options of the manage-disk command
+type manageDiskOptions struct {
+	Disk     string
+	DevName  string
+	Renumber bool
+	DryRun   bool
+}
+
+// newManageDiskCommand returns the command bringing a disk partitioned by
+// hand under the management of the driver, to be run in the node plugin
+// container of the node of the disk.
+func newManageDiskCommand(config *config.Config) *cobra.Command {
+	o := &manageDiskOptions{}
+	cmd := &cobra.Command{
+		Use:   "manage-disk",
+		Short: "adds the meta partition to a disk partitioned by hand",
+		Long: `adds the meta partition to a disk partitioned by hand, e.g. the
+		    disk of hostPath volumes, without moving its data, so that
+		    its partitions can be imported as volumes.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return manageDisk(config, o)
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&o.Disk, "disk", "", "Kernel name of the disk, e.g. sdb")
+	cmd.Flags().StringVar(&o.DevName, "devname", "", "Device name of the disk, the name of its meta partition")
+	cmd.Flags().BoolVar(&o.Renumber, "renumber", false, "Allow the partition numbered 1 to get a new number, which breaks the references to its device path")
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", false, "Only print the partition table which would be loaded")
+	return cmd
+}
+
+// manageDisk adds the meta partition to the disk and claims it for this
+// driver instance.
+func manageDisk(config *config.Config, o *manageDiskOptions) error {
+	if o.Disk == "" || o.DevName == "" {
+		return errors.New("--disk and --devname are required")
+	}
+	setDeviceConfiguration(config)
+	if err := device.SetupInstanceID(config.DriverName); err != nil {
+		return err
+	}
+
+	table, renumbered, err := device.ManageDisk(o.Disk, o.DevName, o.Renumber, o.DryRun)
+	if err != nil {
+		if len(renumbered) > 0 && !o.Renumber {
+			return fmt.Errorf("could not manage disk %s: %v, pass --renumber to allow it", o.Disk, err)
+		}
+		return fmt.Errorf("could not manage disk %s: %v", o.Disk, err)
+	}
+	switch {
+	case table == "":
+		fmt.Printf("disk %s is already managed with device name %s\n", o.Disk, o.DevName)
+	case o.DryRun:
+		fmt.Printf("partition table of disk %s which would be loaded:\n%s", o.Disk, table)
+		for _, part := range renumbered {
+			fmt.Printf("partition %s would become %s\n", part.From, part.To)
+		}
+		if len(renumbered) > 0 && !o.Renumber {
+			fmt.Println("--renumber is required to load this partition table")
+		}
+	default:
+		fmt.Printf("partition table of disk %s loaded:\n%s", o.Disk, table)
+		for _, part := range renumbered {
+			fmt.Printf("partition %s became %s\n", part.From, part.To)
+		}
+	}
+	return nil
+}
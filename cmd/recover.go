@@ -0,0 +1,160 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	"github.com/spf13/cobra"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openebs/device-localpv/pkg/builder/pvbuilder"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/device-localpv/pkg/config"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+// recoverOptions are the options of the recover command
+type recoverOptions struct {
+	DryRun       bool
+	SkipPVs      bool
+	StorageClass string
+	Adopt        bool
+}
+
+// newRecoverCommand returns the command rebuilding the DeviceVolumes and
+// the PersistentVolumes of the partitions of the node, to be run in the
+// node plugin container of each node.
+func newRecoverCommand(config *config.Config) *cobra.Command {
+	o := &recoverOptions{}
+	cmd := &cobra.Command{
+		Use:   "recover",
+		Short: "rebuilds the volumes of the node from the partitions on the disks",
+		Long: `recreates the missing DeviceVolumes, and their PersistentVolumes,
+		    of the partitions found on the disks of the node, e.g. after
+		    the loss of the cluster state.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return recoverVolumes(config, o)
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", false, "Only print the volumes which would be recreated")
+	cmd.Flags().BoolVar(&o.SkipPVs, "skip-pvs", false, "Recreate the DeviceVolumes only, without their PersistentVolumes")
+	cmd.Flags().StringVar(&o.StorageClass, "storage-class", "", "StorageClass of the recreated PersistentVolumes")
+	cmd.Flags().BoolVar(&o.Adopt, "adopt", false, "Take over the disks owned by another driver instance, e.g. of a former cluster, before recovering their volumes")
+	return cmd
+}
+
+// recoverVolumes creates the DeviceVolumes and the PersistentVolumes of the
+// partitions of the node which don't exist. The PersistentVolumes are
+// retained, and bound to the claims recorded in the journals of the disks.
+func recoverVolumes(config *config.Config, o *recoverOptions) error {
+	if config.NodeID == "" {
+		return errors.New("--nodeid is required")
+	}
+	device.NodeID = config.NodeID
+	setDeviceConfiguration(config)
+	if err := device.SetupInstanceID(config.DriverName); err != nil {
+		return err
+	}
+
+	foreign, err := device.ListForeignDisks()
+	if err != nil {
+		return fmt.Errorf("could not list the disks: %v", err)
+	}
+	for disk, owner := range foreign {
+		switch {
+		case !o.Adopt:
+			fmt.Printf("disk %s is owned by %q, skipped without --adopt\n", disk, owner)
+		case o.DryRun:
+			fmt.Printf("disk %s owned by %q would be adopted\n", disk, owner)
+		default:
+			if err = device.AdoptDisk(disk); err != nil {
+				return fmt.Errorf("could not adopt disk %s: %v", disk, err)
+			}
+			fmt.Printf("disk %s owned by %q adopted\n", disk, owner)
+		}
+	}
+
+	vols, unrecorded, err := device.RecoverVolumes()
+	if err != nil {
+		return fmt.Errorf("could not list the partitions: %v", err)
+	}
+	for _, part := range unrecorded {
+		fmt.Printf("partition %s (%s, %d bytes on %s) has no journal record, it has to be imported\n",
+			part.DevicePath, part.Name, part.Size, part.DevName)
+	}
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return err
+	}
+	volClient := volbuilder.NewKubeclient().WithNamespace(device.DeviceNamespace)
+
+	for _, vol := range vols {
+		_, err = volClient.Get(vol.Name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			fmt.Printf("devicevolume %s exists\n", vol.Name)
+		case !k8serror.IsNotFound(err):
+			return fmt.Errorf("could not get devicevolume %s: %v", vol.Name, err)
+		case o.DryRun:
+			fmt.Printf("devicevolume %s would be created (%s bytes on %s, %s, fsType %q)\n",
+				vol.Name, vol.Spec.Capacity, vol.Spec.DevName, device.GetVolumeMode(vol), vol.Spec.FsType)
+		default:
+			if _, err = volClient.Create(vol); err != nil {
+				return fmt.Errorf("could not create devicevolume %s: %v", vol.Name, err)
+			}
+			fmt.Printf("devicevolume %s created\n", vol.Name)
+		}
+		// the volumes in the recycle bin have no PersistentVolume
+		// until they are undeleted
+		if o.SkipPVs || vol.Status.State == device.DeviceStatusReleased {
+			continue
+		}
+
+		pvBuilder := pvbuilder.NewBuilder(vol, config.DriverName).
+			WithVolumeMode(device.GetVolumeMode(vol)).
+			WithStorageClass(o.StorageClass)
+		if claim := vol.Annotations[device.PVCNameKey]; claim != "" {
+			pvBuilder.WithClaimRef(vol.Annotations[device.PVCNamespaceKey], claim)
+		}
+		pv, err := pvBuilder.Build()
+		if err != nil {
+			return err
+		}
+		pvClient := kubeClient.CoreV1().PersistentVolumes()
+		_, err = pvClient.Get(context.TODO(), pv.Name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			fmt.Printf("persistentvolume %s exists\n", pv.Name)
+		case !k8serror.IsNotFound(err):
+			return fmt.Errorf("could not get persistentvolume %s: %v", pv.Name, err)
+		case o.DryRun:
+			fmt.Printf("persistentvolume %s would be created\n", pv.Name)
+		default:
+			if _, err = pvClient.Create(context.TODO(), pv, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("could not create persistentvolume %s: %v", pv.Name, err)
+			}
+			fmt.Printf("persistentvolume %s created\n", pv.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+// undeleteOptions are the options of the undelete command
+type undeleteOptions struct {
+	Claim   string
+	Timeout time.Duration
+}
+
+// newUndeleteCommand returns the command restoring a volume from the
+// recycle bin. It annotates the DeviceVolume, the node agent of the owner
+// node restores the partition and creates the PersistentVolume.
+func newUndeleteCommand() *cobra.Command {
+	o := &undeleteOptions{}
+	cmd := &cobra.Command{
+		Use:   "undelete VOLUME",
+		Short: "restores a deleted volume from the recycle bin",
+		Long: `restores a Released volume, whose partition is kept in the
+		    recycle bin of its node, and binds it to a new claim.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return undeleteVolume(args[0], o)
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&o.Claim, "claim", "", "Namespace/name of the claim the volume is restored for")
+	cmd.Flags().DurationVar(&o.Timeout, "timeout", time.Minute, "How long to wait for the volume to be restored, zero doesn't wait")
+	return cmd
+}
+
+// undeleteVolume sets the undelete annotation of the Released volume and
+// waits for the volume to be Ready.
+func undeleteVolume(name string, o *undeleteOptions) error {
+	if _, _, err := device.ParseUndeleteClaim(o.Claim); err != nil {
+		return err
+	}
+	vol, err := device.GetDeviceVolume(name)
+	if err != nil {
+		return fmt.Errorf("could not get devicevolume %s: %v", name, err)
+	}
+	if vol.Status.State != device.DeviceStatusReleased || vol.DeletionTimestamp != nil {
+		return fmt.Errorf("devicevolume %s is not in the recycle bin", name)
+	}
+	if vol.Annotations == nil {
+		vol.Annotations = map[string]string{}
+	}
+	vol.Annotations[device.UndeleteKey] = o.Claim
+	if _, err = volbuilder.NewKubeclient().WithNamespace(device.DeviceNamespace).Update(vol); err != nil {
+		return fmt.Errorf("could not update devicevolume %s: %v", name, err)
+	}
+	fmt.Printf("devicevolume %s undeleted for claim %s\n", name, o.Claim)
+	if o.Timeout == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.Timeout)
+	defer cancel()
+	// the annotation is removed once the PersistentVolume is created
+	for {
+		vol, err = device.WaitForDeviceVolumeProcessed(ctx, name)
+		if err != nil {
+			return fmt.Errorf("devicevolume %s is not restored yet: %v", name, err)
+		}
+		if _, ok := vol.Annotations[device.UndeleteKey]; !ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("persistentvolume of devicevolume %s is not created yet: %v", name, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+	fmt.Printf("devicevolume %s restored\n", name)
+	return nil
+}
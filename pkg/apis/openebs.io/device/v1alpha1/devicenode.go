@@ -36,6 +36,41 @@ type DeviceNode struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Devices []Device `json:"devices"`
+
+	// Status reports the drift between the partitions found on the
+	// devices and the DeviceVolumes of the node.
+	Status DeviceNodeStatus `json:"status,omitempty"`
+}
+
+// DeviceNodeStatus specifies the observations of the node agent which
+// don't describe the devices themselves.
+type DeviceNodeStatus struct {
+	// OrphanedPartitions lists the partitions created by the driver
+	// which no DeviceVolume refers to anymore.
+	OrphanedPartitions []OrphanedPartition `json:"orphanedPartitions,omitempty"`
+
+	// MissingPartitions lists the Ready DeviceVolumes of the node
+	// whose partition could not be found on any device.
+	MissingPartitions []string `json:"missingPartitions,omitempty"`
+}
+
+// OrphanedPartition specifies a partition left on a device without
+// any DeviceVolume.
+type OrphanedPartition struct {
+	// Name of the partition.
+	Name string `json:"name"`
+
+	// Disk is the name of the disk holding the partition.
+	Disk string `json:"disk"`
+
+	// PartNum is the number of the partition on the disk.
+	PartNum uint32 `json:"partNum"`
+
+	// Size specifies the size of the partition.
+	Size resource.Quantity `json:"size"`
+
+	// FirstSeen is the time the partition was first found orphaned.
+	FirstSeen metav1.Time `json:"firstSeen"`
 }
 
 // Device specifies attributes of a given device that exists on node.
@@ -56,6 +91,43 @@ type Device struct {
 	// Free specifies the available capacity of the device.
 	// +kubebuilder:validation:Required
 	Free resource.Quantity `json:"free"`
+
+	// Recycled specifies the capacity held by the partitions of the
+	// Released volumes in the recycle bin, which is not free until
+	// they are purged. It is only set when the recycle bin is not empty.
+	Recycled *resource.Quantity `json:"recycled,omitempty"`
+
+	// WholeDisk is set for the unpartitioned disks which can be claimed
+	// entirely by a single volume. The Name of such a device is the
+	// /dev/disk/by-id name of the disk.
+	WholeDisk bool `json:"wholeDisk,omitempty"`
+
+	// ClaimedBy is the name of the volume owning the whole disk.
+	ClaimedBy string `json:"claimedBy,omitempty"`
+
+	// ThinPool specifies the usage of the dm-thin pool created on the
+	// device. It is only set once a thin volume has been provisioned
+	// on the device.
+	ThinPool *ThinPool `json:"thinPool,omitempty"`
+}
+
+// ThinPool specifies the attributes of a dm-thin pool.
+type ThinPool struct {
+	// Size specifies the size of the pool data device.
+	Size resource.Quantity `json:"size"`
+
+	// Used specifies the data space allocated by thin volumes.
+	Used resource.Quantity `json:"used"`
+
+	// MetadataSize specifies the size of the pool metadata device.
+	MetadataSize resource.Quantity `json:"metadataSize"`
+
+	// MetadataUsed specifies the used space of the pool metadata device.
+	MetadataUsed resource.Quantity `json:"metadataUsed"`
+
+	// Provisioned specifies the total size of all the thin volumes
+	// created in the pool.
+	Provisioned resource.Quantity `json:"provisioned"`
 }
 
 // DeviceNodeList is a collection of DeviceNode resources
@@ -69,6 +69,110 @@ type VolumeInfo struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:MinLength=1
 	DevName string `json:"devname"`
+
+	// Allocation specifies how the volume is carved out of the device.
+	// The allocation "partition" (default) creates a fully preallocated partition
+	// for the volume, "thin" creates a thin volume in the dm-thin pool
+	// of the device and "wholeDisk" dedicates an entire unpartitioned disk
+	// to the volume.
+	// +kubebuilder:validation:Enum=partition;thin;wholeDisk
+	Allocation string `json:"allocation,omitempty"`
+
+	// OverProvisioningRatio caps the total size of the thin volumes of the
+	// dm-thin pool holding the volume to the given multiple of the pool
+	// size, e.g. "2.5". It is only set for the volumes with "thin"
+	// allocation, the pool can't be over-provisioned when it is not set.
+	OverProvisioningRatio string `json:"overProvisioningRatio,omitempty"`
+
+	// ImageSource is the node local path or the http(s) URL of a raw or
+	// qcow2 disk image, which is written into the volume before it
+	// becomes Ready.
+	ImageSource string `json:"imageSource,omitempty"`
+
+	// Shared specifies whether the volume can be published to several
+	// pods on the owner node at the same time.
+	// +kubebuilder:validation:Enum=yes;no
+	Shared string `json:"shared,omitempty"`
+
+	// VolumeMode is the mode the volume was provisioned with, "Filesystem"
+	// or "Block". The volumes without mode are filesystem volumes.
+	// +kubebuilder:validation:Enum=Filesystem;Block
+	VolumeMode string `json:"volumeMode,omitempty"`
+
+	// FsType is the filesystem the volume is formatted with when it is
+	// mounted for the first time. It is only set for filesystem volumes.
+	// +kubebuilder:validation:Enum=ext3;ext4;xfs;btrfs;f2fs
+	FsType string `json:"fsType,omitempty"`
+
+	// MkfsOptions are the extra arguments passed to mkfs when the volume
+	// is formatted, e.g. "-E lazy_itable_init=1 -L data" for ext4.
+	MkfsOptions string `json:"mkfsOptions,omitempty"`
+
+	// FsckPolicy specifies when the filesystem of the volume is checked
+	// before it is mounted. The policy "never" mounts the volume without
+	// checking it, "onError" checks the filesystem when the mount fails
+	// and "always" checks it before every mount.
+	// +kubebuilder:validation:Enum=never;onError;always
+	FsckPolicy string `json:"fsckPolicy,omitempty"`
+
+	// FsckFallback specifies what happens when the filesystem check finds
+	// errors which it could not correct. The fallback "fail" (default)
+	// fails the mount and "readOnly" mounts the volume read only.
+	// +kubebuilder:validation:Enum=fail;readOnly
+	FsckFallback string `json:"fsckFallback,omitempty"`
+
+	// Discard specifies how the freed blocks of the filesystem are
+	// discarded. The mode "mount" mounts the volume with the discard
+	// option and "periodic" trims the mounted volume on a schedule.
+	// +kubebuilder:validation:Enum=mount;periodic
+	Discard string `json:"discard,omitempty"`
+
+	// Retention is how long the partition of a deleted volume is kept
+	// in the recycle bin, e.g. "72h", before it is purged. The volume
+	// can be undeleted until then. It is only set for the volumes with
+	// "partition" allocation, the others are purged right away.
+	Retention string `json:"retention,omitempty"`
+
+	// IOLimits throttles the IOs of the pods consuming the volume through
+	// the io.max of their cgroup. It is not set for unthrottled volumes.
+	IOLimits *IOLimits `json:"ioLimits,omitempty"`
+
+	// Import adopts an existing partition or disk, holding data, as the
+	// volume instead of provisioning a blank one. It is only set for the
+	// statically provisioned volumes.
+	Import *VolumeImport `json:"import,omitempty"`
+}
+
+// VolumeImport names the existing partition or disk adopted by a volume,
+// either by PartUUID or by DiskID.
+type VolumeImport struct {
+	// PartUUID is the unique GUID of the GPT partition,
+	// as listed in /dev/disk/by-partuuid.
+	PartUUID string `json:"partUUID,omitempty"`
+
+	// DiskID is the /dev/disk/by-id name of the partition, or of the
+	// whole disk for the volumes with "wholeDisk" allocation.
+	DiskID string `json:"diskID,omitempty"`
+}
+
+// IOLimits specifies the bandwidth and IOPS limits of a volume,
+// a zero limit means unlimited.
+type IOLimits struct {
+	// ReadBps is the maximum number of bytes read per second.
+	// +kubebuilder:validation:Minimum=0
+	ReadBps int64 `json:"readBps,omitempty"`
+
+	// WriteBps is the maximum number of bytes written per second.
+	// +kubebuilder:validation:Minimum=0
+	WriteBps int64 `json:"writeBps,omitempty"`
+
+	// ReadIOPS is the maximum number of reads per second.
+	// +kubebuilder:validation:Minimum=0
+	ReadIOPS int64 `json:"readIOPS,omitempty"`
+
+	// WriteIOPS is the maximum number of writes per second.
+	// +kubebuilder:validation:Minimum=0
+	WriteIOPS int64 `json:"writeIOPS,omitempty"`
 }
 
 // VolStatus string that specifies the current state of the volume provisioning request.
@@ -76,13 +180,70 @@ type VolStatus struct {
 	// State specifies the current state of the volume provisioning request.
 	// The state "Pending" means that the volume creation request has not
 	// processed yet. The state "Ready" means that the volume has been created
-	// and it is ready for the use.
-	// +kubebuilder:validation:Enum=Pending;Ready;Failed
+	// and it is ready for the use. The state "Released" means that the
+	// volume was deleted and its partition is kept in the recycle bin
+	// until PurgeTime.
+	// +kubebuilder:validation:Enum=Pending;Ready;Failed;Released
 	State string `json:"state,omitempty"`
 
 	// Error denotes the error occurred during provisioning a volume.
 	// Error field should only be set when State becomes Failed.
 	Error *VolumeError `json:"error,omitempty"`
+
+	// ThinPool is the name of the dm-thin pool holding the volume.
+	// It is only set for the volumes with "thin" allocation.
+	ThinPool string `json:"thinPool,omitempty"`
+
+	// ThinDeviceID is the id of the thin device inside the dm-thin pool.
+	// It is only set for the volumes with "thin" allocation.
+	ThinDeviceID uint32 `json:"thinDeviceID,omitempty"`
+
+	// ClaimedDisk is the name of the disk owned by the volume.
+	// It is only set for the volumes with "wholeDisk" allocation.
+	ClaimedDisk string `json:"claimedDisk,omitempty"`
+
+	// PartitionName is the name of the GPT partition of the volume.
+	// It is only set for the volumes with "partition" allocation, the
+	// volumes created before it was recorded have a partition named
+	// after the volume.
+	PartitionName string `json:"partitionName,omitempty"`
+
+	// Partition is the location of the partition of the volume, recorded
+	// once the partition is created. It is only set for the volumes with
+	// "partition" allocation.
+	Partition *PartitionLocation `json:"partition,omitempty"`
+
+	// Published is set while the volume is published to a pod on its
+	// owner node, as per the publication records of the node agent.
+	Published bool `json:"published,omitempty"`
+
+	// PurgeTime is the time the partition of a Released volume is purged
+	// from the recycle bin. A Released volume without PurgeTime is kept
+	// until its DeviceVolume is deleted.
+	PurgeTime *metav1.Time `json:"purgeTime,omitempty"`
+
+	// Conditions holds the observations of the node agent on the volume,
+	// e.g. the result of the last filesystem check.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PartitionLocation locates the partition of a volume on the node.
+type PartitionLocation struct {
+	// Disk is the stable name of the disk holding the partition, its
+	// /dev/disk/by-id name if it has one, its kernel name otherwise.
+	Disk string `json:"disk"`
+
+	// PartNum is the number of the partition on the disk.
+	PartNum uint32 `json:"partNum"`
+
+	// Start is the offset in bytes of the first byte of the partition.
+	Start uint64 `json:"start"`
+
+	// End is the offset in bytes of the last byte of the partition.
+	End uint64 `json:"end"`
+
+	// PartUUID is the GPT partition GUID, as in /dev/disk/by-partuuid.
+	PartUUID string `json:"partUUID,omitempty"`
 }
 
 // VolumeError specifies the error occurred during volume provisioning.
@@ -101,4 +262,7 @@ const (
 	// InsufficientCapacity represent device doesn't
 	// have enough capacity to fit the volume request.
 	InsufficientCapacity VolumeErrorCode = "InsufficientCapacity"
+	// ImportFailed represents an import of a partition or
+	// disk which can't be adopted as the volume.
+	ImportFailed VolumeErrorCode = "ImportFailed"
 )
@@ -22,6 +22,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -30,6 +31,16 @@ func (in *Device) DeepCopyInto(out *Device) {
 	*out = *in
 	out.Size = in.Size.DeepCopy()
 	out.Free = in.Free.DeepCopy()
+	if in.Recycled != nil {
+		in, out := &in.Recycled, &out.Recycled
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.ThinPool != nil {
+		in, out := &in.ThinPool, &out.ThinPool
+		*out = new(ThinPool)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -55,6 +66,7 @@ func (in *DeviceNode) DeepCopyInto(out *DeviceNode) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -109,12 +121,40 @@ func (in *DeviceNodeList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceNodeStatus) DeepCopyInto(out *DeviceNodeStatus) {
+	*out = *in
+	if in.OrphanedPartitions != nil {
+		in, out := &in.OrphanedPartitions, &out.OrphanedPartitions
+		*out = make([]OrphanedPartition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MissingPartitions != nil {
+		in, out := &in.MissingPartitions, &out.MissingPartitions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceNodeStatus.
+func (in *DeviceNodeStatus) DeepCopy() *DeviceNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeviceVolume) DeepCopyInto(out *DeviceVolume) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 	return
 }
@@ -170,6 +210,77 @@ func (in *DeviceVolumeList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IOLimits) DeepCopyInto(out *IOLimits) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IOLimits.
+func (in *IOLimits) DeepCopy() *IOLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(IOLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanedPartition) DeepCopyInto(out *OrphanedPartition) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	in.FirstSeen.DeepCopyInto(&out.FirstSeen)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanedPartition.
+func (in *OrphanedPartition) DeepCopy() *OrphanedPartition {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanedPartition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PartitionLocation) DeepCopyInto(out *PartitionLocation) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PartitionLocation.
+func (in *PartitionLocation) DeepCopy() *PartitionLocation {
+	if in == nil {
+		return nil
+	}
+	out := new(PartitionLocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThinPool) DeepCopyInto(out *ThinPool) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	out.Used = in.Used.DeepCopy()
+	out.MetadataSize = in.MetadataSize.DeepCopy()
+	out.MetadataUsed = in.MetadataUsed.DeepCopy()
+	out.Provisioned = in.Provisioned.DeepCopy()
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThinPool.
+func (in *ThinPool) DeepCopy() *ThinPool {
+	if in == nil {
+		return nil
+	}
+	out := new(ThinPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VolStatus) DeepCopyInto(out *VolStatus) {
 	*out = *in
@@ -178,6 +289,22 @@ func (in *VolStatus) DeepCopyInto(out *VolStatus) {
 		*out = new(VolumeError)
 		**out = **in
 	}
+	if in.Partition != nil {
+		in, out := &in.Partition, &out.Partition
+		*out = new(PartitionLocation)
+		**out = **in
+	}
+	if in.PurgeTime != nil {
+		in, out := &in.PurgeTime, &out.PurgeTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -207,9 +334,35 @@ func (in *VolumeError) DeepCopy() *VolumeError {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeImport) DeepCopyInto(out *VolumeImport) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeImport.
+func (in *VolumeImport) DeepCopy() *VolumeImport {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VolumeInfo) DeepCopyInto(out *VolumeInfo) {
 	*out = *in
+	if in.IOLimits != nil {
+		in, out := &in.IOLimits, &out.IOLimits
+		*out = new(IOLimits)
+		**out = **in
+	}
+	if in.Import != nil {
+		in, out := &in.Import, &out.Import
+		*out = new(VolumeImport)
+		**out = **in
+	}
 	return
 }
 
@@ -0,0 +1,136 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package pvbuilder
+
+import (
+	"strconv"
+
+	"github.com/openebs/lib-csi/pkg/common/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+// Builder is the builder object for the PersistentVolume
+// of a statically provisioned DeviceVolume
+type Builder struct {
+	pv   *corev1.PersistentVolume
+	errs []error
+}
+
+// NewBuilder returns a new instance of Builder, building the
+// PersistentVolume of the volume. It is a CSI volume of the driver
+// bound to the owner node of the volume, formatted with the fsType of
+// the volume, ReadWriteOnce and retained by default.
+func NewBuilder(vol *apis.DeviceVolume, driverName string) *Builder {
+	b := &Builder{}
+	capacity, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		b.errs = append(b.errs,
+			errors.Wrapf(err, "failed to build persistent volume: invalid capacity %q", vol.Spec.Capacity))
+	}
+	volumeMode := corev1.PersistentVolumeFilesystem
+	b.pv = &corev1.PersistentVolume{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolume"},
+		ObjectMeta: metav1.ObjectMeta{Name: vol.Name},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: *resource.NewQuantity(capacity, resource.BinarySI),
+			},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			VolumeMode:                    &volumeMode,
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       driverName,
+					VolumeHandle: vol.Name,
+					FSType:       vol.Spec.FsType,
+					VolumeAttributes: map[string]string{
+						device.DeviceNameKey:     vol.Spec.DevName,
+						device.OpenEBSCasTypeKey: device.LocalDeviceCasTypeName,
+					},
+				},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{{
+							Key:      device.DeviceTopologyKey,
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{vol.Spec.OwnerNodeID},
+						}},
+					}},
+				},
+			},
+		},
+	}
+	return b
+}
+
+// WithVolumeMode sets the volume mode, block volumes don't have any fsType
+func (b *Builder) WithVolumeMode(mode corev1.PersistentVolumeMode) *Builder {
+	b.pv.Spec.VolumeMode = &mode
+	if mode == corev1.PersistentVolumeBlock {
+		b.pv.Spec.CSI.FSType = ""
+	}
+	return b
+}
+
+// WithAccessMode sets the access mode of the volume
+func (b *Builder) WithAccessMode(mode corev1.PersistentVolumeAccessMode) *Builder {
+	b.pv.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{mode}
+	return b
+}
+
+// WithReclaimPolicy sets what happens to the volume once released by its claim
+func (b *Builder) WithReclaimPolicy(policy corev1.PersistentVolumeReclaimPolicy) *Builder {
+	b.pv.Spec.PersistentVolumeReclaimPolicy = policy
+	return b
+}
+
+// WithStorageClass sets the storage class of the volume
+func (b *Builder) WithStorageClass(name string) *Builder {
+	b.pv.Spec.StorageClassName = name
+	return b
+}
+
+// WithClaimRef binds the volume to the claim
+func (b *Builder) WithClaimRef(namespace string, name string) *Builder {
+	if name == "" {
+		b.errs = append(b.errs,
+			errors.New("failed to build persistent volume: missing claim name"))
+		return b
+	}
+	b.pv.Spec.ClaimRef = &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Namespace:  namespace,
+		Name:       name,
+	}
+	return b
+}
+
+// Build returns the PersistentVolume API object
+func (b *Builder) Build() (*corev1.PersistentVolume, error) {
+	if len(b.errs) > 0 {
+		return nil, errors.Errorf("%+v", b.errs)
+	}
+	return b.pv, nil
+}
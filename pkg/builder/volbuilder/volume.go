@@ -17,6 +17,8 @@ limitations under the License.
 package volbuilder
 
 import (
+	"strconv"
+
 	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
 	"github.com/openebs/lib-csi/pkg/common/errors"
 )
@@ -159,6 +161,23 @@ func (b *Builder) WithLabels(labels map[string]string) *Builder {
 	return b
 }
 
+// WithAnnotations merges existing annotations if any
+// with the ones that are provided here
+func (b *Builder) WithAnnotations(annotations map[string]string) *Builder {
+	if len(annotations) == 0 {
+		return b
+	}
+
+	if b.volume.Object.Annotations == nil {
+		b.volume.Object.Annotations = map[string]string{}
+	}
+
+	for key, value := range annotations {
+		b.volume.Object.Annotations[key] = value
+	}
+	return b
+}
+
 // WithFinalizer sets Finalizer name creating the volume
 func (b *Builder) WithFinalizer(finalizer []string) *Builder {
 	b.volume.Object.Finalizers = append(b.volume.Object.Finalizers, finalizer...)
@@ -180,6 +199,93 @@ func (b *Builder) WithDeviceName(deviceName string) *Builder {
 	return b
 }
 
+// WithAllocation sets the allocation mode of the volume
+func (b *Builder) WithAllocation(allocation string) *Builder {
+	b.volume.Object.Spec.Allocation = allocation
+	return b
+}
+
+// WithOverProvisioningRatio sets the over provisioning ratio of the
+// dm-thin pool holding the volume, it is not set when zero
+func (b *Builder) WithOverProvisioningRatio(ratio float64) *Builder {
+	if ratio == 0 {
+		return b
+	}
+	b.volume.Object.Spec.OverProvisioningRatio = strconv.FormatFloat(ratio, 'f', -1, 64)
+	return b
+}
+
+// WithImageSource sets the disk image written into the volume
+func (b *Builder) WithImageSource(source string) *Builder {
+	b.volume.Object.Spec.ImageSource = source
+	return b
+}
+
+// WithShared sets whether the volume can be published to several pods
+func (b *Builder) WithShared(shared string) *Builder {
+	b.volume.Object.Spec.Shared = shared
+	return b
+}
+
+// WithFsType sets the filesystem the volume is formatted with
+func (b *Builder) WithFsType(fsType string) *Builder {
+	b.volume.Object.Spec.FsType = fsType
+	return b
+}
+
+// WithVolumeMode sets the mode of the volume, Filesystem or Block
+func (b *Builder) WithVolumeMode(mode string) *Builder {
+	b.volume.Object.Spec.VolumeMode = mode
+	return b
+}
+
+// WithMkfsOptions sets the extra arguments of mkfs
+func (b *Builder) WithMkfsOptions(options string) *Builder {
+	b.volume.Object.Spec.MkfsOptions = options
+	return b
+}
+
+// WithFsckPolicy sets when the filesystem of the volume is checked
+func (b *Builder) WithFsckPolicy(policy string) *Builder {
+	b.volume.Object.Spec.FsckPolicy = policy
+	return b
+}
+
+// WithFsckFallback sets how a volume with filesystem errors is mounted
+func (b *Builder) WithFsckFallback(fallback string) *Builder {
+	b.volume.Object.Spec.FsckFallback = fallback
+	return b
+}
+
+// WithDiscard sets how the freed blocks of the volume are discarded
+func (b *Builder) WithDiscard(discard string) *Builder {
+	b.volume.Object.Spec.Discard = discard
+	return b
+}
+
+// WithRetention sets how long the partition of the deleted volume
+// is kept in the recycle bin
+func (b *Builder) WithRetention(retention string) *Builder {
+	b.volume.Object.Spec.Retention = retention
+	return b
+}
+
+// WithIOLimits sets the IO limits of the volume, the limits are
+// not set when they are all zero
+func (b *Builder) WithIOLimits(limits apis.IOLimits) *Builder {
+	if limits == (apis.IOLimits{}) {
+		return b
+	}
+	b.volume.Object.Spec.IOLimits = &limits
+	return b
+}
+
+// WithImport sets the existing partition or disk adopted by the volume
+func (b *Builder) WithImport(imp apis.VolumeImport) *Builder {
+	b.volume.Object.Spec.Import = &imp
+	return b
+}
+
 // Build returns DeviceVolume API object
 func (b *Builder) Build() (*apis.DeviceVolume, error) {
 	if len(b.errs) > 0 {
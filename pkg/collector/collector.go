@@ -17,6 +17,7 @@
 package collector
 
 import (
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -32,17 +33,61 @@ const refreshInterval = 1 * time.Minute
 type deviceCollector struct {
 	volSizeMetric *prometheus.Desc
 
+	deviceSizeMetric          *prometheus.Desc
+	deviceFreeMetric          *prometheus.Desc
+	deviceLargestFreeMetric   *prometheus.Desc
+	deviceFreeFragmentsMetric *prometheus.Desc
+	devicePartitionsMetric    *prometheus.Desc
+	deviceMaxPartitionsMetric *prometheus.Desc
+
+	thinPoolSizeMetric         *prometheus.Desc
+	thinPoolUsedMetric         *prometheus.Desc
+	thinPoolMetadataSizeMetric *prometheus.Desc
+	thinPoolMetadataUsedMetric *prometheus.Desc
+	thinPoolProvisionedMetric  *prometheus.Desc
+
+	trimBytesMetric    *prometheus.Desc
+	trimRunsMetric     *prometheus.Desc
+	trimFailuresMetric *prometheus.Desc
+	trimDurationMetric *prometheus.Desc
+	trimLastRunMetric  *prometheus.Desc
+
+	volIOStats  ioStatDescs
+	diskIOStats ioStatDescs
+
 	mtx   sync.RWMutex
 	parts []device.PartUsed
+	pools []device.ThinPoolUsage
+	disks []device.DiskUsage
 }
 
 func (c *deviceCollector) Describe(descs chan<- *prometheus.Desc) {
 	descs <- c.volSizeMetric
+	descs <- c.deviceSizeMetric
+	descs <- c.deviceFreeMetric
+	descs <- c.deviceLargestFreeMetric
+	descs <- c.deviceFreeFragmentsMetric
+	descs <- c.devicePartitionsMetric
+	descs <- c.deviceMaxPartitionsMetric
+	descs <- c.thinPoolSizeMetric
+	descs <- c.thinPoolUsedMetric
+	descs <- c.thinPoolMetadataSizeMetric
+	descs <- c.thinPoolMetadataUsedMetric
+	descs <- c.thinPoolProvisionedMetric
+	descs <- c.trimBytesMetric
+	descs <- c.trimRunsMetric
+	descs <- c.trimFailuresMetric
+	descs <- c.trimDurationMetric
+	descs <- c.trimLastRunMetric
+	c.volIOStats.describe(descs)
+	c.diskIOStats.describe(descs)
 }
 
 func (c *deviceCollector) Collect(metrics chan<- prometheus.Metric) {
 	c.mtx.RLock()
 	parts := c.parts
+	pools := c.pools
+	disks := c.disks
 	c.mtx.RUnlock()
 
 	for _, part := range parts {
@@ -51,6 +96,90 @@ func (c *deviceCollector) Collect(metrics chan<- prometheus.Metric) {
 			part.GetPVName(), strings.TrimLeft(part.DevicePath, "/dev/"),
 		)
 	}
+
+	for _, disk := range disks {
+		for desc, value := range map[*prometheus.Desc]uint64{
+			c.deviceSizeMetric:          disk.Size,
+			c.deviceFreeMetric:          disk.Free,
+			c.deviceLargestFreeMetric:   disk.LargestFree,
+			c.deviceFreeFragmentsMetric: disk.FreeFragments,
+			c.devicePartitionsMetric:    disk.Partitions,
+			c.deviceMaxPartitionsMetric: disk.MaxPartitions,
+		} {
+			metrics <- prometheus.MustNewConstMetric(desc,
+				prometheus.GaugeValue, float64(value),
+				disk.DeviceName, disk.DiskPath,
+			)
+		}
+	}
+
+	for _, pool := range pools {
+		for desc, value := range map[*prometheus.Desc]uint64{
+			c.thinPoolSizeMetric:         pool.DataSize,
+			c.thinPoolUsedMetric:         pool.DataUsed,
+			c.thinPoolMetadataSizeMetric: pool.MetadataSize,
+			c.thinPoolMetadataUsedMetric: pool.MetadataUsed,
+			c.thinPoolProvisionedMetric:  pool.Provisioned,
+		} {
+			metrics <- prometheus.MustNewConstMetric(desc,
+				prometheus.GaugeValue, float64(value),
+				pool.DeviceName, pool.DiskPath,
+			)
+		}
+	}
+
+	c.collectIOStats(metrics, parts)
+
+	// the trim statistics are kept in memory, no need to cache them
+	for _, stat := range device.ListTrimStats() {
+		for desc, value := range map[*prometheus.Desc]float64{
+			c.trimBytesMetric:    float64(stat.Bytes),
+			c.trimRunsMetric:     float64(stat.Runs),
+			c.trimFailuresMetric: float64(stat.Failures),
+			c.trimDurationMetric: stat.Duration.Seconds(),
+		} {
+			metrics <- prometheus.MustNewConstMetric(desc,
+				prometheus.CounterValue, value,
+				stat.VolumeName, stat.Kind,
+			)
+		}
+		if !stat.LastRun.IsZero() {
+			metrics <- prometheus.MustNewConstMetric(c.trimLastRunMetric,
+				prometheus.GaugeValue, float64(stat.LastRun.Unix()),
+				stat.VolumeName, stat.Kind,
+			)
+		}
+	}
+}
+
+// collectIOStats exports the IO counters of the partitions of the volumes
+// and of the disks holding them. The counters are read on every scrape.
+func (c *deviceCollector) collectIOStats(metrics chan<- prometheus.Metric, parts []device.PartUsed) {
+	if len(parts) == 0 {
+		return
+	}
+	stats, err := readDiskStats(procDiskStats)
+	if err != nil {
+		klog.Errorf("read diskstats: %v", err)
+		return
+	}
+
+	disks := map[string]bool{}
+	for _, part := range parts {
+		partStats, ok := stats[filepath.Base(part.DevicePath)]
+		if !ok {
+			continue
+		}
+		c.volIOStats.collect(metrics, partStats,
+			part.GetPVName(), part.DiskPath, device.NodeID)
+		disks[part.DiskPath] = true
+	}
+	// the counters of a disk add up the IOs of all its partitions
+	for disk := range disks {
+		if diskStats, ok := stats[disk]; ok {
+			c.diskIOStats.collect(metrics, diskStats, disk, device.NodeID)
+		}
+	}
 }
 
 func (c *deviceCollector) listPartitions() {
@@ -59,11 +188,41 @@ func (c *deviceCollector) listPartitions() {
 		klog.Errorf("list device partitions: %v", err)
 		parts = nil
 	}
+	pools, err := device.ListThinPools()
+	if err != nil {
+		klog.Errorf("list thin pools: %v", err)
+		pools = nil
+	}
+	disks, err := device.ListDiskUsage()
+	if err != nil {
+		klog.Errorf("list device usage: %v", err)
+		disks = nil
+	}
 	c.mtx.Lock()
 	c.parts = parts
+	c.pools = pools
+	c.disks = disks
 	c.mtx.Unlock()
 }
 
+func newDeviceDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName("openebs", "device", name),
+		help, []string{"devname", "disk"}, nil)
+}
+
+func newThinPoolDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName("openebs", "thin_pool", name),
+		help, []string{"devname", "disk"}, nil)
+}
+
+func newTrimDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName("openebs", "volume_trim", name),
+		help, []string{"volumename", "kind"}, nil)
+}
+
 // NewDeviceCollector collects disk partition related metrics.
 func NewDeviceCollector(stopCh <-chan struct{}) prometheus.Collector {
 	dc := &deviceCollector{
@@ -71,6 +230,40 @@ func NewDeviceCollector(stopCh <-chan struct{}) prometheus.Collector {
 			prometheus.BuildFQName("openebs", "size_of", "volume"),
 			"Partition volume total size in bytes",
 			[]string{"volumename", "device"}, nil),
+		deviceSizeMetric: newDeviceDesc("size_bytes",
+			"Device total size in bytes"),
+		deviceFreeMetric: newDeviceDesc("free_bytes",
+			"Device free space in bytes, all the free slots included"),
+		deviceLargestFreeMetric: newDeviceDesc("largest_free_bytes",
+			"Device largest free slot in bytes, the largest partition which can be created"),
+		deviceFreeFragmentsMetric: newDeviceDesc("free_fragments",
+			"Number of free slots of the device"),
+		devicePartitionsMetric: newDeviceDesc("partitions",
+			"Number of GPT entries used on the device"),
+		deviceMaxPartitionsMetric: newDeviceDesc("max_partitions",
+			"Number of GPT entries of the device"),
+		thinPoolSizeMetric: newThinPoolDesc("size_bytes",
+			"Thin pool data device size in bytes"),
+		thinPoolUsedMetric: newThinPoolDesc("used_bytes",
+			"Thin pool data space allocated by thin volumes in bytes"),
+		thinPoolMetadataSizeMetric: newThinPoolDesc("metadata_size_bytes",
+			"Thin pool metadata device size in bytes"),
+		thinPoolMetadataUsedMetric: newThinPoolDesc("metadata_used_bytes",
+			"Thin pool metadata space used in bytes"),
+		thinPoolProvisionedMetric: newThinPoolDesc("provisioned_bytes",
+			"Total size of the thin volumes provisioned in the pool in bytes"),
+		trimBytesMetric: newTrimDesc("bytes_total",
+			"Bytes discarded by the trims of the volume"),
+		trimRunsMetric: newTrimDesc("runs_total",
+			"Successful trims of the volume"),
+		trimFailuresMetric: newTrimDesc("failures_total",
+			"Failed trims of the volume"),
+		trimDurationMetric: newTrimDesc("duration_seconds_total",
+			"Time spent trimming the volume in seconds"),
+		trimLastRunMetric: newTrimDesc("last_run_timestamp_seconds",
+			"Time of the last successful trim of the volume"),
+		volIOStats:  newIOStatDescs("volume", []string{"volumename", "disk", "node"}),
+		diskIOStats: newIOStatDescs("disk", []string{"disk", "node"}),
 	}
 
 	dc.listPartitions()
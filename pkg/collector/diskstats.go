@@ -0,0 +1,147 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const procDiskStats = "/proc/diskstats"
+
+// diskstats reports the sectors in units of 512 bytes, whatever
+// the sector size of the device is
+const diskStatsSectorSize = 512
+
+// diskStats holds the IO counters of a block device,
+// see https://www.kernel.org/doc/Documentation/ABI/testing/procfs-diskstats
+type diskStats struct {
+	ReadsCompleted  uint64
+	ReadSectors     uint64
+	ReadTimeMs      uint64
+	WritesCompleted uint64
+	WriteSectors    uint64
+	WriteTimeMs     uint64
+	IOsInProgress   uint64
+	IOTimeMs        uint64
+}
+
+// parseDiskStats parses the diskstats, indexed by the kernel device name
+func parseDiskStats(r io.Reader) (map[string]diskStats, error) {
+	stats := map[string]diskStats{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// major minor name + at least 11 counters
+		if len(fields) < 14 {
+			continue
+		}
+		var counters [11]uint64
+		for i := range counters {
+			value, err := strconv.ParseUint(fields[i+3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid diskstats line %q: %v", scanner.Text(), err)
+			}
+			counters[i] = value
+		}
+		stats[fields[2]] = diskStats{
+			ReadsCompleted:  counters[0],
+			ReadSectors:     counters[2],
+			ReadTimeMs:      counters[3],
+			WritesCompleted: counters[4],
+			WriteSectors:    counters[6],
+			WriteTimeMs:     counters[7],
+			IOsInProgress:   counters[8],
+			IOTimeMs:        counters[9],
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// readDiskStats reads the IO counters of all the block devices
+func readDiskStats(path string) (map[string]diskStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseDiskStats(f)
+}
+
+// ioStatDescs describes the IO metrics of a volume or of a disk
+type ioStatDescs struct {
+	readBytes  *prometheus.Desc
+	writeBytes *prometheus.Desc
+	reads      *prometheus.Desc
+	writes     *prometheus.Desc
+	readTime   *prometheus.Desc
+	writeTime  *prometheus.Desc
+	inFlight   *prometheus.Desc
+	ioTime     *prometheus.Desc
+}
+
+func newIOStatDescs(subsystem string, labels []string) ioStatDescs {
+	newDesc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName("openebs", subsystem, name),
+			help, labels, nil)
+	}
+	return ioStatDescs{
+		readBytes:  newDesc("read_bytes_total", "Bytes read"),
+		writeBytes: newDesc("write_bytes_total", "Bytes written"),
+		reads:      newDesc("reads_completed_total", "Reads completed"),
+		writes:     newDesc("writes_completed_total", "Writes completed"),
+		readTime:   newDesc("read_time_seconds_total", "Time spent reading in seconds"),
+		writeTime:  newDesc("write_time_seconds_total", "Time spent writing in seconds"),
+		inFlight:   newDesc("io_now", "IOs in progress"),
+		ioTime:     newDesc("io_time_seconds_total", "Time spent doing IOs in seconds"),
+	}
+}
+
+func (d ioStatDescs) describe(descs chan<- *prometheus.Desc) {
+	descs <- d.readBytes
+	descs <- d.writeBytes
+	descs <- d.reads
+	descs <- d.writes
+	descs <- d.readTime
+	descs <- d.writeTime
+	descs <- d.inFlight
+	descs <- d.ioTime
+}
+
+func (d ioStatDescs) collect(metrics chan<- prometheus.Metric, stats diskStats, labelValues ...string) {
+	for desc, value := range map[*prometheus.Desc]float64{
+		d.readBytes:  float64(stats.ReadSectors * diskStatsSectorSize),
+		d.writeBytes: float64(stats.WriteSectors * diskStatsSectorSize),
+		d.reads:      float64(stats.ReadsCompleted),
+		d.writes:     float64(stats.WritesCompleted),
+		d.readTime:   float64(stats.ReadTimeMs) / 1000,
+		d.writeTime:  float64(stats.WriteTimeMs) / 1000,
+		d.ioTime:     float64(stats.IOTimeMs) / 1000,
+	} {
+		metrics <- prometheus.MustNewConstMetric(desc,
+			prometheus.CounterValue, value, labelValues...)
+	}
+	metrics <- prometheus.MustNewConstMetric(d.inFlight,
+		prometheus.GaugeValue, float64(stats.IOsInProgress), labelValues...)
+}
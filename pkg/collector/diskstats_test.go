@@ -0,0 +1,59 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseDiskStats(t *testing.T) {
+	input := `   8      16 sdb 1200 10 96000 800 3400 20 272000 5100 2 4300 5900 0 0 0 0 0 0
+   8      18 sdb2 200 0 16000 120 700 0 56000 900 1 1000 1020 0 0 0 0 0 0
+ 259       1 nvme0n1p3 5 0 40 1 6 0 48 2 0 3 3
+   7       0 loop0 1 2 3
+`
+	got, err := parseDiskStats(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseDiskStats() error = %v", err)
+	}
+	want := map[string]diskStats{
+		"sdb": {
+			ReadsCompleted: 1200, ReadSectors: 96000, ReadTimeMs: 800,
+			WritesCompleted: 3400, WriteSectors: 272000, WriteTimeMs: 5100,
+			IOsInProgress: 2, IOTimeMs: 4300,
+		},
+		"sdb2": {
+			ReadsCompleted: 200, ReadSectors: 16000, ReadTimeMs: 120,
+			WritesCompleted: 700, WriteSectors: 56000, WriteTimeMs: 900,
+			IOsInProgress: 1, IOTimeMs: 1000,
+		},
+		"nvme0n1p3": {
+			ReadsCompleted: 5, ReadSectors: 40, ReadTimeMs: 1,
+			WritesCompleted: 6, WriteSectors: 48, WriteTimeMs: 2,
+			IOsInProgress: 0, IOTimeMs: 3,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDiskStats() = %+v, want %+v", got, want)
+	}
+
+	if _, err = parseDiskStats(strings.NewReader("8 16 sdb 1 2 3 4 5 6 7 8 x 10 11\n")); err == nil {
+		t.Errorf("parseDiskStats() expected an error on invalid counter")
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+var (
+	orphanedPartitions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "openebs",
+		Name:      "orphaned_partition_bytes",
+		Help:      "Size in bytes of the partitions created by the driver which belong to no volume",
+	}, []string{"partition", "disk"})
+
+	missingPartitions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "openebs",
+		Name:      "volume_partition_missing",
+		Help:      "Ready volumes of the node whose partition is not found on the devices",
+	}, []string{"volumename"})
+)
+
+// SetPartitionDrift replaces the orphaned partitions and the volumes
+// missing their partition reported by the node.
+func SetPartitionDrift(orphans []device.PartUsed, missing []string) {
+	orphanedPartitions.Reset()
+	for _, part := range orphans {
+		orphanedPartitions.WithLabelValues(part.Name, part.DiskPath).Set(float64(part.Size))
+	}
+	missingPartitions.Reset()
+	for _, vol := range missing {
+		missingPartitions.WithLabelValues(vol).Set(1)
+	}
+}
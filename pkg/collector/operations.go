@@ -0,0 +1,120 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+// Operations of the node agent
+const (
+	OperationCreate  = "create"
+	OperationDestroy = "destroy"
+	OperationMount   = "mount"
+	OperationUnmount = "unmount"
+)
+
+// Results of the scheduling of a volume
+const (
+	ScheduleSucceeded     = "scheduled"
+	ScheduleUnschedulable = "unschedulable"
+)
+
+// codeOK is the code of the operations which succeeded
+const codeOK = "OK"
+
+var (
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "openebs",
+		Subsystem: "volume_operation",
+		Name:      "duration_seconds",
+		Help:      "Duration of the volume operations in seconds, by operation and error code",
+		// the partitioning and the filesystem checks take a few seconds,
+		// the image populated volumes take a lot more
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"operation", "code"})
+
+	schedulerDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openebs",
+		Subsystem: "scheduler",
+		Name:      "decisions_total",
+		Help:      "Scheduling decisions of the controller, by scheduler, result and selected node",
+	}, []string{"scheduler", "result", "node"})
+
+	volumeReschedules = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openebs",
+		Subsystem: "scheduler",
+		Name:      "reschedules_total",
+		Help:      "Volumes which failed on a node and were rescheduled, by error code",
+	}, []string{"code"})
+)
+
+// ErrorCode returns the code labelling the metrics of an operation which
+// returned the error: the code of a VolumeError, the gRPC code of a status
+// error and Internal for the other errors.
+func ErrorCode(err error) string {
+	if err == nil {
+		return codeOK
+	}
+	if volErr, ok := err.(*apis.VolumeError); ok && volErr.Code != "" {
+		return string(volErr.Code)
+	}
+	if st, ok := status.FromError(err); ok {
+		return st.Code().String()
+	}
+	return codes.Internal.String()
+}
+
+// ObserveOperation records the duration of an operation which started
+// at the given time, labelled with the code of the returned error.
+func ObserveOperation(operation string, start time.Time, err error) {
+	operationDuration.WithLabelValues(operation, ErrorCode(err)).
+		Observe(time.Since(start).Seconds())
+}
+
+// RecordSchedulerDecision records the node selected by the scheduler,
+// an empty node means that no node could host the volume.
+func RecordSchedulerDecision(scheduler string, node string) {
+	result := ScheduleSucceeded
+	if node == "" {
+		result = ScheduleUnschedulable
+	}
+	schedulerDecisions.WithLabelValues(scheduler, result, node).Inc()
+}
+
+// RecordReschedule records a volume which failed on its node and is
+// rescheduled on another one.
+func RecordReschedule(volErr *apis.VolumeError) {
+	volumeReschedules.WithLabelValues(ErrorCode(volErr)).Inc()
+}
+
+// NodeCollectors returns the collectors of the operations of the node
+// agent and of the drift between the partitions and the volumes.
+func NodeCollectors() []prometheus.Collector {
+	return []prometheus.Collector{operationDuration, orphanedPartitions, missingPartitions}
+}
+
+// ControllerCollectors returns the collectors of the controller.
+func ControllerCollectors() []prometheus.Collector {
+	return []prometheus.Collector{schedulerDecisions, volumeReschedules}
+}
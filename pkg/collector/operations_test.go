@@ -0,0 +1,63 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package collector
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "success",
+			err:  nil,
+			want: "OK",
+		},
+		{
+			name: "volume error",
+			err:  &apis.VolumeError{Code: apis.InsufficientCapacity, Message: "no space"},
+			want: "InsufficientCapacity",
+		},
+		{
+			name: "status error",
+			err:  status.Error(codes.FailedPrecondition, "volume is already mounted"),
+			want: "FailedPrecondition",
+		},
+		{
+			name: "plain error",
+			err:  errors.New("exit status 32"),
+			want: "Internal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorCode(tt.err); got != tt.want {
+				t.Errorf("ErrorCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -16,6 +16,8 @@ limitations under the License.
 
 package config
 
+import "time"
+
 // Config struct fills the parameters of request or user input
 type Config struct {
 	// DriverName to be registered at CSI
@@ -57,6 +59,55 @@ type Config struct {
 
 	// Ignore the Block devices by specifying the matching Regular Expression
 	IgnoreBlockDevicesRegex string
+
+	// FilePoolDir is the host directory where the backing files
+	// of the file pools are kept
+	FilePoolDir string
+
+	// FilePools is the list of file pools in "name=size" format separated
+	// by comma. Each pool is attached as a loop device and exposed as a device
+	// having the pool name as meta partition name.
+	FilePools string
+
+	// ImageDir is the host directory holding the disk images which
+	// can be referred by a local path in the image source of a volume
+	ImageDir string
+
+	// PublishDir is the host directory where the node agent records the
+	// access modes the volumes are published with
+	PublishDir string
+
+	// WholeDiskRegex is the regular expression matching the kernel names of
+	// the unpartitioned disks which can be claimed entirely by a volume
+	WholeDiskRegex string
+
+	// DiscardInterval is the interval between the trims of the mounted
+	// volumes having the periodic discard
+	DiscardInterval time.Duration
+
+	// DiscardRateLimit is the quantity of bytes trimmed per second
+	// by the periodic discard
+	DiscardRateLimit string
+
+	// ThinPoolSize is the quantity of bytes the new dm-thin
+	// pools are created with
+	ThinPoolSize string
+
+	// CgroupRoot is the path where the cgroup v2 hierarchy
+	// of the host is mounted
+	CgroupRoot string
+
+	// OrphanCleanup is the cleanup policy of the partitions belonging
+	// to no volume, empty to only report them or delete-after=<duration>
+	OrphanCleanup string
+
+	// EphemeralDevices is the comma separated list of the devnames the
+	// CSI ephemeral inline volumes can be created on, empty to disable them
+	EphemeralDevices string
+
+	// InstanceID identifies the driver instance owning the disks,
+	// empty for the driver name qualified with the cluster uid
+	InstanceID string
 }
 
 // Default returns a new instance of config
@@ -27,6 +27,7 @@ import (
 
 	"github.com/openebs/lib-csi/pkg/common/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/klog/v2"
 
 	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
@@ -52,9 +53,17 @@ const (
 const (
 	partitionTypeGPT    = "gpt"
 	metaPartitionNumber = 1
+	// GPT partition names are limited to 36 UTF-16 code units
+	maxPartitionNameLen = 36
 	freeSlotFSType      = "free"
+	// parted creates GPT tables with the default number of entries
+	gptMaxPartitions = 128
 )
 
+// provisionedUIDRegex matches the uid in the name of the provisioned
+// volumes, pvc-<uid>, which former versions named the partitions after
+var provisionedUIDRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
 // column indices for command outputs
 const (
 	partedDiskInfoPartTypeIndex = 5
@@ -111,11 +120,36 @@ type PartUsed struct {
 
 	// Total size of the partition in bytes.
 	Size uint64
+
+	// Start and End are the offsets in bytes of the first
+	// and the last byte of the partition on the disk.
+	Start uint64
+	End   uint64
+
+	// DevName denotes the meta partition name of the disk.
+	DevName string
+
+	// PVName denotes the name of the volume recorded in the journal
+	// of the disk, if any.
+	PVName string
 }
 
-// GetPVName returns the related persistent volume name.
+// GetPVName returns the related persistent volume name. The name of the
+// volumes missing from the journal is only known for the partitions a former
+// version of the driver named after a provisioned volume, i.e. pvc-<uid>,
+// it is empty for the others.
 func (p *PartUsed) GetPVName() string {
-	return fmt.Sprintf("pvc-%v", p.Name)
+	if p.PVName != "" {
+		return p.PVName
+	}
+	if !provisionedUIDRegex.MatchString(p.Name) {
+		return ""
+	}
+	pvName := "pvc-" + p.Name
+	if name, ok := legacyPartitionName(pvName); !ok || name != p.Name {
+		return ""
+	}
+	return pvName
 }
 
 type partFree struct {
@@ -134,9 +168,26 @@ type diskDetail struct {
 // CreateVolume creates a partition on the disk with partition name as the pv name
 // and size as pv size.
 func CreateVolume(vol *apis.DeviceVolume) error {
+	if vol.Spec.Import != nil {
+		return importVolume(vol)
+	}
+	switch vol.Spec.Allocation {
+	case AllocationThin:
+		return createThinVolume(vol)
+	case AllocationWholeDisk:
+		return createWholeDiskVolume(vol)
+	}
 	//func CreatePartition(diskName string, partitionName string, size int) error {
 	diskMetaName := vol.Spec.DevName
-	partitionName := vol.Name[4:]
+	partitionName := vol.Status.PartitionName
+	if partitionName == "" {
+		name, err := assignPartitionName(vol)
+		if err != nil {
+			klog.Errorf("GetAllPartsUsed failed %s", err)
+			return err
+		}
+		partitionName = name
+	}
 
 	capacityBytes, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
 	if err != nil {
@@ -153,7 +204,7 @@ func CreateVolume(vol *apis.DeviceVolume) error {
 	if len(pList) > 0 {
 		klog.Infof("Partition %s already exist, Skipping creation", partitionName)
 		// Making Volume creation Idempotent
-		return nil
+		return completePartCreate(vol, pList[0])
 	}
 	disk, start, err := findBestPart(diskMetaName, capacityMiB)
 	if err != nil {
@@ -163,27 +214,73 @@ func CreateVolume(vol *apis.DeviceVolume) error {
 			Message: err.Error(),
 		}
 	}
-	return createPartAndWipeFS(disk, start, partitionName, capacityMiB, diskMetaName)
+
+	// the intent is logged before the partition is created, so that a
+	// partition left without wipefs by a crash is wiped on the next try
+	rec := newPartitionRecord(vol, partitionName, capacityMiB*1024*1024)
+	if err = updateJournal(disk, func(j *Journal) { j.setIntent(IntentCreate, rec) }); err != nil {
+		klog.Errorf("could not log the creation of partition %s in the journal of disk %s: %v",
+			partitionName, disk, err)
+		return err
+	}
+	part, err := createPartAndWipeFS(disk, start, partitionName, capacityMiB, diskMetaName)
+	if err != nil {
+		return err
+	}
+	vol.Status.Partition = getPartitionLocation(part)
+	return updateJournal(disk, func(j *Journal) {
+		j.clearIntent(partitionName)
+		j.putPartition(rec)
+	})
+}
+
+// completePartCreate completes the creation of the existing partition of the
+// volume. The partition is wiped if its creation was interrupted before.
+func completePartCreate(vol *apis.DeviceVolume, part PartUsed) error {
+	if vol.Status.Partition == nil {
+		vol.Status.Partition = getPartitionLocation(part)
+	}
+	j, err := ReadJournal(part.DiskPath)
+	if err != nil || j == nil {
+		return err
+	}
+	intent := j.getIntent(part.Name)
+	if intent == nil || intent.Op != IntentCreate {
+		if j.getPartition(part.Name) != nil {
+			return nil
+		}
+		return updateJournal(part.DiskPath, func(j *Journal) {
+			j.putPartition(newPartitionRecord(vol, part.Name, part.Size))
+		})
+	}
+	klog.Infof("Partition %s was not wiped after its creation, wiping it", part.Name)
+	if err = wipeFsPartition(part.DiskPath, part.PartNum); err != nil {
+		return err
+	}
+	return updateJournal(part.DiskPath, func(j *Journal) {
+		j.clearIntent(part.Name)
+		j.putPartition(intent.Partition)
+	})
 }
 
 // createPartAndWipeFS creates a partition at the provided start address
 // and perform a wipefs operation on the created partition.
-func createPartAndWipeFS(disk string, start uint64, partitionName string, size uint64, diskMetaName string) error {
+func createPartAndWipeFS(disk string, start uint64, partitionName string, size uint64, diskMetaName string) (PartUsed, error) {
 	klog.Infof("Creating Partition %s %s", partitionName, diskMetaName)
 	_, err := RunCommand(strings.Split(fmt.Sprintf(PartitionCreate, disk, partitionName, start, start+size), " "))
 	if err != nil {
 		klog.Errorf("Create Partition failed %s", err)
-		return err
+		return PartUsed{}, err
 	}
 
 	pList, err := getAllPartsUsed(diskMetaName, partitionName)
 	if err != nil {
 		klog.Errorf("GetAllPartsUsed failed %s", err)
-		return err
+		return PartUsed{}, err
 	}
 
 	if len(pList) == 0 {
-		return fmt.Errorf("could not find created partition %s", partitionName)
+		return PartUsed{}, fmt.Errorf("could not find created partition %s", partitionName)
 	}
 
 	err = wipeFsPartition(pList[0].DiskPath, pList[0].PartNum)
@@ -195,9 +292,9 @@ func createPartAndWipeFS(disk string, start uint64, partitionName string, size u
 		}
 		// the error will be returned irrespective of the return value of delete partition,
 		// as create partition has failed.
-		return err
+		return PartUsed{}, err
 	}
-	return nil
+	return pList[0], nil
 }
 
 // getAllPartsFree lists all the free slots on the disk with the provided
@@ -285,6 +382,8 @@ func parsePartUsed(diskPath string, row partedOutput) (PartUsed, error) {
 	p.Name = row.partName
 	p.DevicePath = getPartitionPath(diskPath, p.PartNum)
 	p.Size = row.size
+	p.Start = row.beginBytes
+	p.End = row.endBytes
 	return p, nil
 }
 
@@ -307,13 +406,28 @@ func parsePartFree(row partedOutput) partFree {
 // DestroyVolume gets the partition corresponding to a DeviceVolume resource, wipes
 // the partition and delete the partition from the disk.
 func DestroyVolume(vol *apis.DeviceVolume) error {
+	switch vol.Spec.Allocation {
+	case AllocationThin:
+		return destroyThinVolume(vol)
+	case AllocationWholeDisk:
+		return destroyWholeDiskVolume(vol)
+	}
 	diskMetaName := vol.Spec.DevName
-	partitionName := getPartitionName(vol.Name)
+	partitionName := GetVolumePartitionName(vol)
 	pList, err := getAllPartsUsed(diskMetaName, partitionName)
 	if err != nil {
 		klog.Errorf("GetAllPartsUsed failed %s", err)
 		return err
 	}
+	// the creation of the volume may have been interrupted
+	// before the generated partition name was recorded
+	if len(pList) == 0 && vol.Status.PartitionName == "" && vol.UID != "" {
+		partitionName = string(vol.UID)
+		if pList, err = getAllPartsUsed(diskMetaName, partitionName); err != nil {
+			klog.Errorf("GetAllPartsUsed failed %s", err)
+			return err
+		}
+	}
 	if len(pList) > 1 {
 		klog.Errorf("More than one partition of same name %s\n", partitionName)
 		return errors.New("More than one partition of same name")
@@ -322,8 +436,25 @@ func DestroyVolume(vol *apis.DeviceVolume) error {
 		klog.Infof("%s Partition not found, Skipping Deletion\n", partitionName)
 		return nil
 	}
-	return wipeFSAndDeletePart(pList[0].DiskPath, pList[0].PartNum)
-
+	part := pList[0]
+	rec := newPartitionRecord(vol, partitionName, part.Size)
+	if err = updateJournal(part.DiskPath, func(j *Journal) { j.setIntent(IntentDelete, rec) }); err != nil {
+		klog.Errorf("could not log the deletion of partition %s in the journal of disk %s: %v",
+			partitionName, part.DiskPath, err)
+		return err
+	}
+	// let the disk know that the blocks of the partition are free
+	// before the partition is gone
+	if err = discardPartition(vol.Name, part.DevicePath, part.Size); err != nil {
+		klog.Errorf("Discard of partition %s failed: %v", part.DevicePath, err)
+	}
+	if err = wipeFSAndDeletePart(part.DiskPath, part.PartNum); err != nil {
+		return err
+	}
+	return updateJournal(part.DiskPath, func(j *Journal) {
+		j.clearIntent(partitionName)
+		j.removePartition(partitionName)
+	})
 }
 
 // wipeFSAndDeletePart performs a wipefs operation on the partition and then
@@ -358,8 +489,22 @@ func wipeFsPartition(disk string, partNum uint32) error {
 // GetVolumeDevPath returns the path to the volume.
 // eg: /dev/sda1, /dev/nvme0n1p1
 func GetVolumeDevPath(vol *apis.DeviceVolume) (string, error) {
+	switch vol.Spec.Allocation {
+	case AllocationThin:
+		return getThinVolumeDevPath(vol)
+	case AllocationWholeDisk:
+		return getWholeDiskDevPath(vol)
+	}
+	// the recorded location spares the scan of the disks
+	if loc := vol.Status.Partition; loc != nil {
+		path, err := resolvePartitionLocation(diskByPartUUIDDir, sysfsBlockDir, loc)
+		if err == nil {
+			return path, nil
+		}
+		klog.V(4).Infof("could not resolve the partition of volume %s, scanning the disks: %v", vol.Name, err)
+	}
 	diskMetaName := vol.Spec.DevName
-	partitionName := getPartitionName(vol.Name)
+	partitionName := GetVolumePartitionName(vol)
 	pList, err := getAllPartsUsed(diskMetaName, partitionName)
 	if err != nil {
 		klog.Errorf("GetAllPartsUsed failed %s", err)
@@ -449,6 +594,18 @@ func GetPartitionList(diskPath string, diskMetaName string, free bool) ([]parted
 
 		result = append(result, partitionRow)
 	}
+
+	// the disks of other driver instances are not managed,
+	// their partitions are neither listed nor allocated
+	for _, row := range result {
+		if _, ok := getMetaPartition(row); ok {
+			if err = checkDiskOwner(diskPath); err != nil {
+				klog.V(4).Infof("Disk: %s skipped: %v", diskPath, err)
+				return nil, err
+			}
+			break
+		}
+	}
 	return result, nil
 }
 
@@ -508,7 +665,7 @@ func getDiskList() ([]diskDetail, error) {
 			continue
 		}
 
-		// loop is added here for testing purposes
+		// loop devices are used by the file pools and for testing purposes
 		if tmp[lsblkDevTypeIndex] == deviceTypeDisk ||
 			tmp[lsblkDevTypeIndex] == deviceTypeLoop {
 			diskSize, err := strconv.ParseUint(tmp[lsblkSizeIndex], 10, 64)
@@ -579,7 +736,19 @@ func GetDiskDetails() ([]apis.Device, error) {
 		klog.Errorf("Device LocalPV: could not list disk error: %+v", err)
 		return nil, err
 	}
+	wholeDisks, err := listWholeDisks()
+	if err != nil {
+		klog.Errorf("Device LocalPV: could not list whole disks error: %+v", err)
+		return nil, err
+	}
+	isWholeDisk := map[string]bool{}
+	for _, disk := range wholeDisks {
+		isWholeDisk[disk.DiskPath] = true
+	}
 	for _, diskIter := range diskList {
+		if isWholeDisk[diskIter.DiskPath] {
+			continue
+		}
 
 		metaName, err := getDiskMetaName(diskIter.DiskPath)
 		if err != nil {
@@ -596,15 +765,33 @@ func GetDiskDetails() ([]apis.Device, error) {
 			klog.Errorf("Device LocalPV: GetFreeCapacity Failed %s, error: %v", diskIter.DiskPath, err)
 			continue
 		}
-		result = append(result, apis.Device{
+		device := apis.Device{
 			Name: metaName,
 			UUID: id,
 			Size: *resource.NewQuantity(int64(diskIter.Size), resource.BinarySI),
 			Free: *resource.NewQuantity(int64(free*1024*1024), resource.BinarySI),
-		})
+		}
+		rows, err := GetPartitionList(diskIter.DiskPath, metaName, false)
+		if err != nil {
+			klog.Errorf("Device LocalPV: GetPartitionList Failed %s, error: %v", diskIter.DiskPath, err)
+			continue
+		}
+		if recycled := getRecycledCapacity(rows); recycled > 0 {
+			device.Recycled = resource.NewQuantity(int64(recycled), resource.BinarySI)
+		}
+		if usage, ok := getDiskThinPoolUsage(diskIter.DiskPath); ok {
+			device.ThinPool = &apis.ThinPool{
+				Size:         *resource.NewQuantity(int64(usage.DataSize), resource.BinarySI),
+				Used:         *resource.NewQuantity(int64(usage.DataUsed), resource.BinarySI),
+				MetadataSize: *resource.NewQuantity(int64(usage.MetadataSize), resource.BinarySI),
+				MetadataUsed: *resource.NewQuantity(int64(usage.MetadataUsed), resource.BinarySI),
+				Provisioned:  *resource.NewQuantity(int64(usage.Provisioned), resource.BinarySI),
+			}
+		}
+		result = append(result, device)
 	}
 
-	return result, nil
+	return append(result, getWholeDiskDetails(wholeDisks)...), nil
 }
 
 // ListPartUsed lists all disk partitions created by plugin.
@@ -624,21 +811,108 @@ func ListPartUsed() ([]PartUsed, error) {
 			continue
 		}
 		// see if the first partition is meta partition or not.
-		if _, ok := getMetaPartition(tmpList[0]); !ok {
+		metaName, ok := getMetaPartition(tmpList[0])
+		if !ok {
 			continue
 		}
+		j, err := ReadJournal(disk.DiskPath)
+		if err != nil {
+			klog.V(4).Infof("failed to read the journal of disk %q: %v", disk.DiskPath, err)
+		}
 		// ignoring first meta partition
 		for i := 1; i < len(tmpList); i++ {
+			// the partitions backing the thin pool are not volumes
+			if isThinPoolPartition(tmpList[i].partName) {
+				continue
+			}
 			part, err := parsePartUsed(disk.DiskPath, tmpList[i])
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse parted output: %v", err)
 			}
+			part.DevName = metaName
+			if j != nil {
+				if rec := j.getPartition(part.Name); rec != nil {
+					part.PVName = rec.PVName
+				}
+			}
 			plist = append(plist, part)
 		}
 	}
 	return plist, nil
 }
 
+// DiskUsage represents the allocation state of a disk managed by plugin.
+type DiskUsage struct {
+	// DeviceName denotes the name of the meta partition of the disk.
+	DeviceName string
+	DiskPath   string
+
+	// Total size of the disk in bytes.
+	Size uint64
+	// Free denotes the total size of the free slots in bytes.
+	Free uint64
+	// LargestFree denotes the size of the largest free slot in bytes,
+	// which bounds the size of the next partitioned volume.
+	LargestFree uint64
+	// FreeFragments denotes the number of free slots of at least 1MiB.
+	FreeFragments uint64
+
+	// Partitions denotes the number of GPT entries in use, the meta
+	// partition included.
+	Partitions uint64
+	// MaxPartitions denotes the number of GPT entries of the disk.
+	MaxPartitions uint64
+}
+
+// ListDiskUsage lists the allocation state of all the partitioned disks
+// having a meta partition, the disks listed by GetDiskDetails.
+func ListDiskUsage() ([]DiskUsage, error) {
+	diskList, err := getDiskList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disk: %v", err)
+	}
+	var result []DiskUsage
+	for _, disk := range diskList {
+		metaName, err := getDiskMetaName(disk.DiskPath)
+		if err != nil {
+			continue
+		}
+		rows, err := GetPartitionList(disk.DiskPath, metaName, true)
+		if err != nil {
+			klog.Errorf("failed to list partition for disk %q: %v", disk.DiskPath, err)
+			continue
+		}
+		usage := getDiskUsage(rows)
+		usage.DeviceName = metaName
+		usage.DiskPath = disk.DiskPath
+		usage.Size = disk.Size
+		result = append(result, usage)
+	}
+	return result, nil
+}
+
+// getDiskUsage computes the usage of a disk from its parted rows, free
+// slots included. The free slots are rounded to MiB, as the partitions are.
+func getDiskUsage(rows []partedOutput) DiskUsage {
+	usage := DiskUsage{MaxPartitions: gptMaxPartitions}
+	for _, row := range rows {
+		if row.fsType != freeSlotFSType {
+			usage.Partitions++
+			continue
+		}
+		free := parsePartFree(row).SizeMiB * 1024 * 1024
+		if free == 0 {
+			continue
+		}
+		usage.FreeFragments++
+		usage.Free += free
+		if free > usage.LargestFree {
+			usage.LargestFree = free
+		}
+	}
+	return usage
+}
+
 // getPartitionPath gets the partition path from disk name and partition number.
 func getPartitionPath(diskName string, partNum uint32) string {
 	r := regexp.MustCompile(".+[0-9]+$")
@@ -649,9 +923,61 @@ func getPartitionPath(diskName string, partNum uint32) string {
 	return fmt.Sprintf("/dev/%s%d", diskName, partNum)
 }
 
-// getPartitionName returns the partition name from volume name
+// assignPartitionName records the name of the partition of a new volume in
+// its status. The partition is named after the uid of the volume, unless a
+// partition named after the volume was created by a former version of the
+// driver.
+func assignPartitionName(vol *apis.DeviceVolume) (string, error) {
+	name := newPartitionName(vol)
+	if legacy, ok := legacyPartitionName(vol.Name); ok {
+		pList, err := getAllPartsUsed(vol.Spec.DevName, legacy)
+		if err != nil {
+			return "", err
+		}
+		if len(pList) > 0 {
+			name = legacy
+		}
+	}
+	vol.Status.PartitionName = name
+	return name, nil
+}
+
+// newPartitionName returns the generated name of the partition of the volume,
+// the uid of the volume, which fits in a GPT partition name whatever the
+// name of the volume.
+func newPartitionName(vol *apis.DeviceVolume) string {
+	if vol.UID != "" {
+		return string(vol.UID)
+	}
+	return string(uuid.NewUUID())
+}
+
+// legacyPartitionName returns the partition name a former version of the
+// driver gave to the volume. Only the names of the provisioned and the
+// ephemeral volumes are trusted, the others may collide once truncated.
+func legacyPartitionName(volumeName string) (string, bool) {
+	if !strings.HasPrefix(volumeName, "pvc-") && !strings.HasPrefix(volumeName, "csi-") {
+		return "", false
+	}
+	name := getPartitionName(volumeName)
+	return name, name != ""
+}
+
+// getPartitionName returns the partition name from volume name. The prefix of
+// the provisioned (pvc-) and the ephemeral (csi-) volumes is dropped, and the
+// name is capped to the maximum length of a GPT partition name.
 func getPartitionName(volumeName string) string {
-	return strings.TrimPrefix(volumeName, "pvc-")
+	name := volumeName
+	for _, prefix := range []string{"pvc-", "csi-"} {
+		if strings.HasPrefix(name, prefix) {
+			name = strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+	if len(name) > maxPartitionNameLen {
+		name = name[:maxPartitionNameLen]
+	}
+	return name
 }
 
 // parsePartedPartitionRow parses a single partition row in the output of parted command
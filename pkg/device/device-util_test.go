@@ -20,6 +20,8 @@ import (
 	"reflect"
 	"strconv"
 	"testing"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
 )
 
 func Test_getMetaPartition(t *testing.T) {
@@ -103,6 +105,8 @@ func Test_parsePartUsed(t *testing.T) {
 				Name:       "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75",
 				DevicePath: "/dev/sdc2",
 				Size:       9500467658752,
+				Start:      2097152,
+				End:        9500469755903,
 			},
 			wantErr: false,
 		},
@@ -219,6 +223,50 @@ func Test_parsePartFree(t *testing.T) {
 	}
 }
 
+func Test_getDiskUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []partedOutput
+		want DiskUsage
+	}{
+		{
+			name: "fragmented disk",
+			rows: []partedOutput{
+				{partNum: 1, beginBytes: 17408, endBytes: 1048575, size: 1031168, fsType: freeSlotFSType},
+				{partNum: 1, beginBytes: 1048576, endBytes: 2097151, size: 1048576, partName: "test-device"},
+				{partNum: 2, beginBytes: 2097152, endBytes: 12582911, size: 10485760, partName: "pvc-1"},
+				{partNum: 1, beginBytes: 12582912, endBytes: 33554431, size: 20971520, fsType: freeSlotFSType},
+				{partNum: 3, beginBytes: 33554432, endBytes: 44040191, size: 10485760, partName: "pvc-2"},
+				{partNum: 1, beginBytes: 44040192, endBytes: 54525951, size: 10485760, fsType: freeSlotFSType},
+			},
+			want: DiskUsage{
+				Free:          28 * 1024 * 1024,
+				LargestFree:   19 * 1024 * 1024,
+				FreeFragments: 2,
+				Partitions:    3,
+				MaxPartitions: gptMaxPartitions,
+			},
+		},
+		{
+			name: "full disk",
+			rows: []partedOutput{
+				{partNum: 1, beginBytes: 1048576, endBytes: 2097151, size: 1048576, partName: "test-device"},
+			},
+			want: DiskUsage{
+				Partitions:    1,
+				MaxPartitions: gptMaxPartitions,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getDiskUsage(tt.rows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getDiskUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_getPartitionPath(t *testing.T) {
 	type args struct {
 		diskName string
@@ -254,3 +302,127 @@ func Test_getPartitionPath(t *testing.T) {
 		})
 	}
 }
+
+func Test_getPartitionName(t *testing.T) {
+	tests := []struct {
+		name       string
+		volumeName string
+		want       string
+	}{
+		{
+			name:       "provisioned volume",
+			volumeName: "pvc-2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d",
+			want:       "2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d",
+		},
+		{
+			name:       "ephemeral volume",
+			volumeName: "csi-5f8e1a2b3c4d2f5d1b4e1d7e4c2b9f1a5f8e1a2b3c4d2f5d1b4e1d7e4c2b9f1a",
+			want:       "5f8e1a2b3c4d2f5d1b4e1d7e4c2b9f1a5f8e",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getPartitionName(tt.volumeName); got != tt.want {
+				t.Errorf("getPartitionName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_legacyPartitionName(t *testing.T) {
+	tests := []struct {
+		name       string
+		volumeName string
+		want       string
+		wantOk     bool
+	}{
+		{
+			name:       "provisioned volume",
+			volumeName: "pvc-2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d",
+			want:       "2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d",
+			wantOk:     true,
+		},
+		{
+			name:       "ephemeral volume",
+			volumeName: "csi-5f8e1a2b3c4d2f5d1b4e1d7e4c2b9f1a5f8e1a2b3c4d2f5d1b4e1d7e4c2b9f1a",
+			want:       "5f8e1a2b3c4d2f5d1b4e1d7e4c2b9f1a5f8e",
+			wantOk:     true,
+		},
+		{
+			name:       "custom prefix",
+			volumeName: "data-2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d",
+		},
+		{
+			name:       "short name",
+			volumeName: "pvc",
+		},
+		{
+			name:       "prefix only",
+			volumeName: "pvc-",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := legacyPartitionName(tt.volumeName)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("legacyPartitionName() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_GetVolumePartitionName(t *testing.T) {
+	vol := &apis.DeviceVolume{}
+	vol.Name = "pvc-2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d"
+	if got := GetVolumePartitionName(vol); got != "2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d" {
+		t.Errorf("GetVolumePartitionName() of a former volume = %v", got)
+	}
+	vol.Status.PartitionName = "9c1e8a55-37a4-4a8b-8a36-1f4ad5f1c0b2"
+	if got := GetVolumePartitionName(vol); got != vol.Status.PartitionName {
+		t.Errorf("GetVolumePartitionName() = %v, want %v", got, vol.Status.PartitionName)
+	}
+
+	vol.UID = "9c1e8a55-37a4-4a8b-8a36-1f4ad5f1c0b2"
+	vol.Name = "a-volume-name-much-longer-than-a-gpt-partition-name"
+	if got := newPartitionName(vol); got != string(vol.UID) || len(got) > maxPartitionNameLen {
+		t.Errorf("newPartitionName() = %v, want %v", got, vol.UID)
+	}
+}
+
+func TestPartUsed_GetPVName(t *testing.T) {
+	tests := []struct {
+		name string
+		part PartUsed
+		want string
+	}{
+		{
+			name: "recorded in the journal",
+			part: PartUsed{Name: "9c1e8a55-37a4-4a8b-8a36-1f4ad5f1c0b2", PVName: "data"},
+			want: "data",
+		},
+		{
+			name: "named after a provisioned volume",
+			part: PartUsed{Name: "2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d"},
+			want: "pvc-2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d",
+		},
+		{
+			name: "named after an ephemeral volume",
+			part: PartUsed{Name: "5f8e1a2b3c4d2f5d1b4e1d7e4c2b9f1a5f8e"},
+		},
+		{
+			name: "partitioned by hand",
+			part: PartUsed{Name: "scratch"},
+		},
+		{
+			name: "no name",
+			part: PartUsed{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.part.GetPVName(); got != tt.want {
+				t.Errorf("GetPVName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
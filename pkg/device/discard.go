@@ -0,0 +1,286 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+
+	mnt "github.com/openebs/lib-csi/pkg/mount"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// Discard modes of the volumes
+const (
+	// DiscardMount mounts the volume with the discard option, so that
+	// the filesystem discards the blocks as soon as they are freed
+	DiscardMount = "mount"
+	// DiscardPeriodic trims the mounted volume periodically
+	DiscardPeriodic = "periodic"
+)
+
+// Kinds of discard recorded in the trim statistics
+const (
+	// TrimKindFstrim is the FITRIM of a mounted filesystem
+	TrimKindFstrim = "fstrim"
+	// TrimKindBlkdiscard is the BLKDISCARD of a deleted partition
+	TrimKindBlkdiscard = "blkdiscard"
+)
+
+// fitrim is the FITRIM ioctl, _IOWR('X', 121, struct fstrim_range)
+const fitrim = 0xc0185879
+
+// fstrimRange is the struct fstrim_range of the FITRIM ioctl
+type fstrimRange struct {
+	Start  uint64
+	Len    uint64
+	Minlen uint64
+}
+
+// TrimStat holds the discard activity of a volume since the agent started
+type TrimStat struct {
+	// VolumeName is the name of the volume
+	VolumeName string
+	// Kind is either TrimKindFstrim or TrimKindBlkdiscard
+	Kind string
+	// Runs is the number of successful discards
+	Runs uint64
+	// Failures is the number of failed discards
+	Failures uint64
+	// Bytes is the number of bytes discarded
+	Bytes uint64
+	// Duration is the time spent discarding
+	Duration time.Duration
+	// LastRun is the time of the last successful discard
+	LastRun time.Time
+}
+
+type trimStatKey struct {
+	volume string
+	kind   string
+}
+
+var (
+	trimStatsLock sync.Mutex
+	trimStats     = map[trimStatKey]*TrimStat{}
+)
+
+// recordTrim adds a discard to the trim statistics of the volume
+func recordTrim(volume string, kind string, bytes uint64, duration time.Duration, err error) {
+	trimStatsLock.Lock()
+	defer trimStatsLock.Unlock()
+
+	key := trimStatKey{volume: volume, kind: kind}
+	stat, ok := trimStats[key]
+	if !ok {
+		stat = &TrimStat{VolumeName: volume, Kind: kind}
+		trimStats[key] = stat
+	}
+	stat.Duration += duration
+	if err != nil {
+		stat.Failures++
+		return
+	}
+	stat.Runs++
+	stat.Bytes += bytes
+	stat.LastRun = time.Now()
+}
+
+// ListTrimStats returns the trim statistics of the volumes
+func ListTrimStats() []TrimStat {
+	trimStatsLock.Lock()
+	defer trimStatsLock.Unlock()
+
+	stats := make([]TrimStat, 0, len(trimStats))
+	for _, stat := range trimStats {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].VolumeName != stats[j].VolumeName {
+			return stats[i].VolumeName < stats[j].VolumeName
+		}
+		return stats[i].Kind < stats[j].Kind
+	})
+	return stats
+}
+
+// discardPartition discards all the blocks of a partition which is about
+// to be deleted, so that the SSD knows that they don't hold data anymore.
+// The devices which don't support discard are skipped.
+func discardPartition(volume string, devicePath string, size uint64) error {
+	f, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	klog.Infof("Discarding %d bytes of %s for volume %s", size, devicePath, volume)
+	start := time.Now()
+	rng := [2]uint64{0, size}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKDISCARD, uintptr(unsafe.Pointer(&rng)))
+	if errno == unix.EOPNOTSUPP {
+		klog.Infof("%s does not support discard, skipping", devicePath)
+		return nil
+	}
+	if errno != 0 {
+		recordTrim(volume, TrimKindBlkdiscard, 0, time.Since(start), errno)
+		return errno
+	}
+	recordTrim(volume, TrimKindBlkdiscard, size, time.Since(start), nil)
+	return nil
+}
+
+// getBlockDeviceSize returns the size in bytes of the block device
+func getBlockDeviceSize(devicePath string) (uint64, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var size uint64
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return size, nil
+}
+
+// trimFilesystem discards the free blocks of the filesystem on the device,
+// mounted at the mount path. The filesystem is trimmed in ranges of
+// rateLimit bytes, one range per second, so that the discards don't starve
+// the IOs of the other volumes sharing the disk. The ranges cover the whole
+// device, statfs does not count the blocks of the filesystem metadata and
+// would leave the end of the filesystem untrimmed. It returns the number of
+// bytes trimmed.
+func trimFilesystem(devicePath string, mountPath string, rateLimit uint64,
+	stopCh <-chan struct{}) (uint64, error) {
+	devSize, err := getBlockDeviceSize(devicePath)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(mountPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var trimmed uint64
+	for offset := uint64(0); offset < devSize; offset += rateLimit {
+		begin := time.Now()
+		rng := fstrimRange{Start: offset, Len: rateLimit}
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fitrim, uintptr(unsafe.Pointer(&rng)))
+		// the kernel rejects a range starting past the end of the
+		// filesystem, which may be smaller than the device
+		if errno == unix.EINVAL && offset > 0 {
+			return trimmed, nil
+		}
+		if errno != 0 {
+			return trimmed, errno
+		}
+		// the kernel sets the length to the number of bytes trimmed
+		trimmed += rng.Len
+
+		select {
+		case <-stopCh:
+			return trimmed, nil
+		case <-time.After(time.Second - time.Since(begin)):
+		}
+	}
+	return trimmed, nil
+}
+
+// trimVolume trims the filesystem of the volume, if it is mounted
+func trimVolume(vol *apis.DeviceVolume, stopCh <-chan struct{}) {
+	devicePath, err := GetVolumeDevPath(vol)
+	if err != nil {
+		klog.Errorf("trim: could not get the device of volume %s: %v", vol.Name, err)
+		return
+	}
+	mounts, err := mnt.GetMounts(devicePath)
+	if err != nil {
+		klog.Errorf("trim: could not get the mounts of volume %s: %v", vol.Name, err)
+		return
+	}
+	if len(mounts) == 0 {
+		klog.V(4).Infof("trim: volume %s is not mounted, skipping", vol.Name)
+		return
+	}
+
+	start := time.Now()
+	trimmed, err := trimFilesystem(devicePath, mounts[0], DeviceConfiguration.DiscardRateLimit, stopCh)
+	recordTrim(vol.Name, TrimKindFstrim, trimmed, time.Since(start), err)
+	if err != nil {
+		klog.Errorf("trim: failed to trim volume %s at %s: %v", vol.Name, mounts[0], err)
+		return
+	}
+	klog.Infof("trim: trimmed %d bytes of volume %s in %v", trimmed, vol.Name, time.Since(start))
+}
+
+// trimVolumes trims the mounted volumes of this node having periodic discard
+func trimVolumes(stopCh <-chan struct{}) {
+	vols, err := volbuilder.NewKubeclient().
+		WithNamespace(DeviceNamespace).
+		List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("trim: could not list the volumes: %v", err)
+		return
+	}
+	for i := range vols.Items {
+		vol := &vols.Items[i]
+		if vol.Spec.OwnerNodeID != NodeID ||
+			vol.Spec.Discard != DiscardPeriodic ||
+			vol.Status.State != DeviceStatusReady {
+			continue
+		}
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		trimVolume(vol, stopCh)
+	}
+}
+
+// RunPeriodicTrim trims the volumes with periodic discard every discard
+// interval, until the stop channel is closed.
+func RunPeriodicTrim(stopCh <-chan struct{}) {
+	if DeviceConfiguration.DiscardInterval <= 0 {
+		klog.Info("trim: periodic discard is disabled")
+		return
+	}
+	ticker := time.NewTicker(DeviceConfiguration.DiscardInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			trimVolumes(stopCh)
+		case <-stopCh:
+			klog.Info("shutting down periodic trim")
+			return
+		}
+	}
+}
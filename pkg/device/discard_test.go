@@ -0,0 +1,42 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListTrimStats(t *testing.T) {
+	recordTrim("pvc-b", TrimKindFstrim, 100, time.Second, nil)
+	recordTrim("pvc-b", TrimKindFstrim, 50, time.Second, nil)
+	recordTrim("pvc-b", TrimKindFstrim, 0, time.Second, errors.New("EIO"))
+	recordTrim("pvc-a", TrimKindBlkdiscard, 1024, time.Second, nil)
+
+	stats := ListTrimStats()
+	if len(stats) != 2 {
+		t.Fatalf("ListTrimStats() returned %d stats, want 2", len(stats))
+	}
+	if stats[0].VolumeName != "pvc-a" || stats[0].Kind != TrimKindBlkdiscard || stats[0].Bytes != 1024 {
+		t.Errorf("ListTrimStats()[0] = %+v", stats[0])
+	}
+	got := stats[1]
+	if got.Runs != 2 || got.Failures != 1 || got.Bytes != 150 || got.Duration != 3*time.Second || got.LastRun.IsZero() {
+		t.Errorf("ListTrimStats()[1] = %+v", got)
+	}
+}
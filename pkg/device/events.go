@@ -0,0 +1,57 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	openebsScheme "github.com/openebs/device-localpv/pkg/generated/clientset/internalclientset/scheme"
+)
+
+// EventComponent is the source component of the events of the node agent
+const EventComponent = "device-localpv-node"
+
+// eventRecorder records the events of the node agent, it is nil until
+// SetupEventRecorder has been called
+var eventRecorder record.EventRecorder
+
+// SetupEventRecorder creates the recorder of the events emitted
+// by the node agent on the DeviceVolumes and the DeviceNodes
+func SetupEventRecorder() error {
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return err
+	}
+	if err = openebsScheme.AddToScheme(scheme.Scheme); err != nil {
+		return err
+	}
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	eventRecorder = eventBroadcaster.NewRecorder(scheme.Scheme,
+		corev1.EventSource{Component: EventComponent, Host: NodeID})
+	return nil
+}
+
+// EventRecorder returns the recorder of the events of the node agent,
+// nil until SetupEventRecorder has been called
+func EventRecorder() record.EventRecorder {
+	return eventRecorder
+}
@@ -0,0 +1,270 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// Loop device and partition table commands used for file pools
+const (
+	LoopDeviceFind       = "losetup -j %s"
+	LoopDeviceAttach     = "losetup --find --show --partscan %s"
+	PartitionTableCreate = "parted /dev/%s mklabel gpt --script"
+	// SignatureList lists the filesystem, RAID and partition table
+	// signatures found on the device, without erasing them
+	SignatureList = "wipefs --no-act --noheadings --output TYPE /dev/%s"
+)
+
+const (
+	// filePoolFileSuffix is the extension of the sparse backing files
+	// created inside the file pool directory.
+	filePoolFileSuffix = ".img"
+
+	// metaPartitionStartMiB and metaPartitionEndMiB are the boundaries
+	// of the meta partition created on a file pool.
+	metaPartitionStartMiB = 1
+	metaPartitionEndMiB   = 10
+)
+
+// metaNameRegex is the set of characters allowed in a meta partition name.
+var metaNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// FilePool is a sparse file on the host which is attached as a loop
+// device and used as a disk by the plugin.
+type FilePool struct {
+	// Name is the meta partition name of the pool. It is also used
+	// as the name of the backing file.
+	Name string
+
+	// Size of the backing file in bytes.
+	Size uint64
+}
+
+// ParseFilePools parses the pool list in the format
+// "name1=size1,name2=size2" where size is a kubernetes quantity (e.g. 10Gi).
+func ParseFilePools(pools string) ([]FilePool, error) {
+	var result []FilePool
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(pools, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid file pool %q, expected name=size", entry)
+		}
+		name := strings.TrimSpace(kv[0])
+		if !metaNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid file pool name %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate file pool name %q", name)
+		}
+		size, err := resource.ParseQuantity(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size for file pool %q: %v", name, err)
+		}
+		// the pool must hold at least the meta partition and the GPT backup header
+		if size.Value() <= (metaPartitionEndMiB+1)*1024*1024 {
+			return nil, fmt.Errorf("file pool %q is too small: %s", name, size.String())
+		}
+		seen[name] = true
+		result = append(result, FilePool{Name: name, Size: uint64(size.Value())})
+	}
+	return result, nil
+}
+
+// SetupFilePools makes sure that every configured file pool has a backing
+// file, is attached as a loop device and carries a meta partition. It is
+// called on every agent start, so the loop devices get re-attached after
+// a node reboot.
+func SetupFilePools() error {
+	for _, pool := range DeviceConfiguration.FilePools {
+		if err := setupFilePool(DeviceConfiguration.FilePoolDir, pool); err != nil {
+			return fmt.Errorf("setup file pool %s: %v", pool.Name, err)
+		}
+	}
+	return nil
+}
+
+// setupFilePool brings up a single file pool
+func setupFilePool(dir string, pool FilePool) error {
+	file := filepath.Join(dir, pool.Name+filePoolFileSuffix)
+	if err := ensureBackingFile(file, pool.Size); err != nil {
+		return err
+	}
+
+	loopDev, err := attachLoopDevice(file)
+	if err != nil {
+		return err
+	}
+	klog.Infof("file pool %s is attached at /dev/%s", pool.Name, loopDev)
+
+	metaName, err := getDiskMetaName(loopDev)
+	if err == nil {
+		if metaName != pool.Name {
+			return fmt.Errorf("loop device %s has meta partition %q", loopDev, metaName)
+		}
+		return nil
+	}
+
+	// the meta partition may not be found because of a transient failure,
+	// or the disk may be owned by another driver instance, only a blank
+	// pool, or one left with an empty partition table by an interrupted
+	// setup, is labelled, so that the volumes of the pool are never lost
+	out, blankErr := RunCommand(strings.Split(fmt.Sprintf(SignatureList, loopDev), " "))
+	if blankErr != nil {
+		return fmt.Errorf("could not check if loop device %s is blank: %v", loopDev, blankErr)
+	}
+	signatures := parseSignatures(out)
+	switch {
+	case len(signatures) == 0:
+		if _, err = RunCommand(strings.Split(fmt.Sprintf(PartitionTableCreate, loopDev), " ")); err != nil {
+			return err
+		}
+	case isEmptyPartitionTable(loopDev, signatures):
+		// the setup was interrupted between the creation of the
+		// partition table and the one of the meta partition
+		klog.Infof("loop device %s has an empty partition table, completing its setup", loopDev)
+	default:
+		return fmt.Errorf("loop device %s has no usable meta partition but holds %v, left alone: %v",
+			loopDev, signatures, err)
+	}
+
+	klog.Infof("creating meta partition %s on /dev/%s", pool.Name, loopDev)
+	_, err = RunCommand(strings.Split(fmt.Sprintf(PartitionCreate, loopDev, pool.Name,
+		metaPartitionStartMiB, metaPartitionEndMiB), " "))
+	return err
+}
+
+// isEmptyPartitionTable checks if the only signatures of the device are the
+// ones of a GPT partition table, and that the table has no partition.
+func isEmptyPartitionTable(disk string, signatures []string) bool {
+	if !isPartitionTableSignatures(signatures) {
+		return false
+	}
+	parts, err := GetPartitionList(disk, "", false)
+	if err != nil {
+		klog.Errorf("could not list the partitions of %s: %v", disk, err)
+		return false
+	}
+	return len(parts) == 0
+}
+
+// isPartitionTableSignatures checks if the signatures are the ones of a GPT
+// partition table, i.e. the primary and backup tables and the protective MBR
+func isPartitionTableSignatures(signatures []string) bool {
+	for _, signature := range signatures {
+		if signature != partitionTypeGPT && signature != "PMBR" {
+			return false
+		}
+	}
+	return len(signatures) > 0
+}
+
+// parseSignatures parses the output of wipefs and returns the types of
+// the signatures found on the device, e.g. gpt or ext4.
+func parseSignatures(out string) []string {
+	var signatures []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			signatures = append(signatures, line)
+		}
+	}
+	return signatures
+}
+
+// ensureBackingFile creates a sparse file of the given size if it
+// does not exist yet.
+func ensureBackingFile(file string, size uint64) error {
+	info, err := os.Stat(file)
+	if err == nil {
+		if uint64(info.Size()) != size {
+			klog.Warningf("file pool %s has size %d, configured size %d is ignored",
+				file, info.Size(), size)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	klog.Infof("creating file pool backing file %s of size %d", file, size)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err = f.Truncate(int64(size)); err != nil {
+		_ = f.Close()
+		_ = os.Remove(file)
+		return err
+	}
+	return f.Close()
+}
+
+// attachLoopDevice returns the name of the loop device (e.g. loop0) backed
+// by the given file, attaching a new one if required.
+func attachLoopDevice(file string) (string, error) {
+	out, err := RunCommand(strings.Split(fmt.Sprintf(LoopDeviceFind, file), " "))
+	if err != nil {
+		return "", err
+	}
+	if dev := parseLoopDevice(out); dev != "" {
+		return dev, nil
+	}
+
+	out, err = RunCommand(strings.Split(fmt.Sprintf(LoopDeviceAttach, file), " "))
+	if err != nil {
+		return "", err
+	}
+	if dev := parseLoopDevice(out); dev != "" {
+		return dev, nil
+	}
+	return "", fmt.Errorf("could not attach loop device for %s, out: %s", file, out)
+}
+
+// parseLoopDevice parses the output of losetup and returns the name of
+// the first loop device.
+// A sample output of `losetup -j` looks like
+// /dev/loop0: [2049]:1835113 (/var/openebs/device-pools/test-device.img)
+// and the one of `losetup --show` looks like
+// /dev/loop0
+func parseLoopDevice(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		dev := strings.TrimSuffix(fields[0], ":")
+		if strings.HasPrefix(dev, "/dev/") {
+			return strings.TrimPrefix(dev, "/dev/")
+		}
+	}
+	return ""
+}
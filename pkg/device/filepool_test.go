@@ -0,0 +1,149 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilePools(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    []FilePool
+		wantErr bool
+	}{
+		{
+			name: "no file pools",
+			args: "",
+			want: nil,
+		},
+		{
+			name: "multiple file pools",
+			args: "dev-pool=1Gi, ci_pool=512Mi",
+			want: []FilePool{
+				{Name: "dev-pool", Size: 1024 * 1024 * 1024},
+				{Name: "ci_pool", Size: 512 * 1024 * 1024},
+			},
+		},
+		{
+			name:    "missing size",
+			args:    "dev-pool",
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			args:    "dev/pool=1Gi",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			args:    "dev-pool=1Gi,dev-pool=2Gi",
+			wantErr: true,
+		},
+		{
+			name:    "too small",
+			args:    "dev-pool=10Mi",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilePools(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseFilePools() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilePools() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseLoopDevice(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{
+			name: "attached loop device",
+			args: "/dev/loop7: [2049]:1835113 (/var/openebs/device-pools/dev-pool.img)\n",
+			want: "loop7",
+		},
+		{
+			name: "newly attached loop device",
+			args: "/dev/loop8\n",
+			want: "loop8",
+		},
+		{
+			name: "not attached",
+			args: "",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLoopDevice(tt.args); got != tt.want {
+				t.Errorf("parseLoopDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseSignatures(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want []string
+	}{
+		{name: "blank", args: "", want: nil},
+		{name: "blank with newline", args: "\n", want: nil},
+		{name: "partition table", args: "gpt\nPMBR\n", want: []string{"gpt", "PMBR"}},
+		{name: "filesystem", args: "ext4\n", want: []string{"ext4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSignatures(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSignatures() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isPartitionTableSignatures(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{name: "blank", args: nil, want: false},
+		{name: "partition table", args: []string{"gpt", "PMBR"}, want: true},
+		{name: "partition table and backup", args: []string{"gpt", "gpt", "PMBR"}, want: true},
+		{name: "filesystem", args: []string{"ext4"}, want: false},
+		{name: "partition table and filesystem", args: []string{"gpt", "PMBR", "xfs"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPartitionTableSignatures(tt.args); got != tt.want {
+				t.Errorf("isPartitionTableSignatures() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+)
+
+// DefaultFsType is the filesystem created when neither the storage
+// class nor the volume capability asks for a specific one
+const DefaultFsType = "ext4"
+
+// SupportedFsTypes lists the filesystems the volumes can be formatted with
+var SupportedFsTypes = []string{"ext3", "ext4", "xfs", "btrfs", "f2fs"}
+
+// IsSupportedFsType checks if the volumes can be formatted with the filesystem
+func IsSupportedFsType(fsType string) bool {
+	for _, fs := range SupportedFsTypes {
+		if fs == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+// getMkfsArgs returns the arguments of mkfs.<fsType> for the device. The
+// user options come after the defaults of the filesystem, so that they
+// take precedence, e.g. "-m 5" for ext4.
+func getMkfsArgs(fsType string, mkfsOptions string, devicePath string) ([]string, error) {
+	var args []string
+	switch fsType {
+	case "ext3", "ext4":
+		// force the creation on a whole disk and don't reserve any block for root
+		args = []string{"-F", "-m0"}
+	case "xfs", "btrfs":
+		args = []string{"-f"}
+	}
+	options, err := SplitMkfsOptions(mkfsOptions)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, options...)
+	return append(args, devicePath), nil
+}
+
+// SplitMkfsOptions splits the mkfs options into arguments the way a shell
+// does: arguments are separated by whitespace, which is kept inside single
+// or double quotes, and a backslash escapes the next character, except
+// inside single quotes. mkfs is run without a shell, so nothing else is
+// interpreted.
+func SplitMkfsOptions(options string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range options {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == '\\':
+			escaped, inArg = true, true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in mkfs options %q", quote, options)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in mkfs options %q", options)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// formatDevice creates the filesystem on the device with the mkfs options,
+// unless the device is already formatted or the mount is read only. The
+// check of an existing filesystem and the mount are left to FormatAndMount.
+func formatDevice(mounter *mount.SafeFormatAndMount, devicePath string,
+	mountInfo *MountInfo, mkfsOptions string) error {
+	existingFormat, err := mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return err
+	}
+	if existingFormat != "" {
+		return nil
+	}
+	if isReadOnly(mountInfo.MountOptions) {
+		return nil
+	}
+
+	args, err := getMkfsArgs(mountInfo.FSType, mkfsOptions, devicePath)
+	if err != nil {
+		return err
+	}
+	klog.Infof("device: formatting %s as %s with args %v", devicePath, mountInfo.FSType, args)
+	out, err := mounter.Exec.Command("mkfs."+mountInfo.FSType, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mkfs.%s %v failed: %v, output: %s",
+			mountInfo.FSType, args, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
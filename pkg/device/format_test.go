@@ -0,0 +1,111 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_getMkfsArgs(t *testing.T) {
+	tests := map[string]struct {
+		fsType      string
+		mkfsOptions string
+		want        []string
+	}{
+		"ext4 defaults": {
+			fsType: "ext4",
+			want:   []string{"-F", "-m0", "/dev/sdb1"},
+		},
+		"ext4 with options": {
+			fsType:      "ext4",
+			mkfsOptions: "-E lazy_itable_init=1  -i 65536 -L data",
+			want:        []string{"-F", "-m0", "-E", "lazy_itable_init=1", "-i", "65536", "-L", "data", "/dev/sdb1"},
+		},
+		"xfs with options": {
+			fsType:      "xfs",
+			mkfsOptions: "-m reflink=1 -l size=64m",
+			want:        []string{"-f", "-m", "reflink=1", "-l", "size=64m", "/dev/sdb1"},
+		},
+		"f2fs defaults": {
+			fsType: "f2fs",
+			want:   []string{"/dev/sdb1"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := getMkfsArgs(tt.fsType, tt.mkfsOptions, "/dev/sdb1")
+			if err != nil {
+				t.Fatalf("getMkfsArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getMkfsArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMkfsOptions(t *testing.T) {
+	tests := map[string]struct {
+		options string
+		want    []string
+		wantErr bool
+	}{
+		"empty": {
+			options: "  ",
+		},
+		"plain": {
+			options: " -L data  -i 65536 ",
+			want:    []string{"-L", "data", "-i", "65536"},
+		},
+		"double quotes": {
+			options: `-L "my data" -E "a=1"`,
+			want:    []string{"-L", "my data", "-E", "a=1"},
+		},
+		"single quotes": {
+			options: `-L 'it''s "x"'`,
+			want:    []string{"-L", `its "x"`},
+		},
+		"escapes": {
+			options: `-L my\ data -E "say \"hi\""`,
+			want:    []string{"-L", "my data", "-E", `say "hi"`},
+		},
+		"empty argument": {
+			options: `-L ""`,
+			want:    []string{"-L", ""},
+		},
+		"unterminated quote": {
+			options: `-L "my data`,
+			wantErr: true,
+		},
+		"trailing backslash": {
+			options: `-L data\`,
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := SplitMkfsOptions(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitMkfsOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitMkfsOptions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// Filesystem check policies of the volumes
+const (
+	// FsckPolicyNever mounts the volume without checking it
+	FsckPolicyNever = "never"
+	// FsckPolicyOnError checks the volume only when the mount fails
+	FsckPolicyOnError = "onError"
+	// FsckPolicyAlways checks the volume before every mount
+	FsckPolicyAlways = "always"
+
+	// FsckFallbackFail fails the mount of a volume with uncorrected errors
+	FsckFallbackFail = "fail"
+	// FsckFallbackReadOnly mounts a volume with uncorrected errors read only
+	FsckFallbackReadOnly = "readOnly"
+)
+
+// Results of a filesystem check, used as the reason of the
+// FilesystemCheck condition and of the events
+const (
+	FsckClean            = "Clean"
+	FsckRepaired         = "Repaired"
+	FsckUncorrected      = "UncorrectedErrors"
+	FsckCheckFailed      = "CheckFailed"
+	FsckReadOnlyFallback = "ReadOnlyFallback"
+)
+
+// FsckConditionType is the type of the DeviceVolume condition
+// recording the result of the last filesystem check
+const FsckConditionType = "FilesystemCheck"
+
+// fsck output kept in the condition message, the events and the errors
+const maxFsckOutputLen = 512
+
+// fsckResult is the outcome of a filesystem check
+type fsckResult struct {
+	// Status is one of FsckClean, FsckRepaired, FsckUncorrected
+	// and FsckCheckFailed
+	Status string
+	// Output is the output of the check tool
+	Output string
+}
+
+// getFsckCommand returns the command checking the filesystem. ext* filesystems
+// are repaired in preen mode, which only fixes what is safe to fix without a
+// human, and checked in no-modify mode when mounted read only. xfs_repair
+// has no preen mode so that xfs is always checked in no-modify mode, i.e. an
+// xfs check is never FsckRepaired and its errors are left to the fallback.
+func getFsckCommand(fsType string, devicePath string, readOnly bool) (string, []string) {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		if readOnly {
+			return "e2fsck", []string{"-n", devicePath}
+		}
+		return "e2fsck", []string{"-p", devicePath}
+	case "xfs":
+		return "xfs_repair", []string{"-n", devicePath}
+	case "btrfs":
+		return "btrfs", []string{"check", "--readonly", devicePath}
+	case "f2fs":
+		if readOnly {
+			return "fsck.f2fs", []string{"--dry-run", devicePath}
+		}
+		return "fsck.f2fs", []string{"-a", devicePath}
+	}
+	return "", nil
+}
+
+// parseFsckExitCode maps the exit code of the check tool to the result
+func parseFsckExitCode(fsType string, code int) string {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		// 1: errors corrected, 2: errors corrected, reboot needed
+		// 4: errors left uncorrected, 8 and above: operational error
+		switch {
+		case code == 0:
+			return FsckClean
+		case code < 4:
+			return FsckRepaired
+		case code < 8:
+			return FsckUncorrected
+		}
+		return FsckCheckFailed
+	case "xfs":
+		// 2 means the log is dirty, it is replayed by the mount
+		switch code {
+		case 0, 2:
+			return FsckClean
+		case 1:
+			return FsckUncorrected
+		}
+		return FsckCheckFailed
+	}
+	if code == 0 {
+		return FsckClean
+	}
+	return FsckUncorrected
+}
+
+// checkFilesystem runs the check tool of the filesystem on the device
+func checkFilesystem(exec utilexec.Interface, fsType string, devicePath string, readOnly bool) fsckResult {
+	cmd, args := getFsckCommand(fsType, devicePath, readOnly)
+	if cmd == "" {
+		return fsckResult{
+			Status: FsckCheckFailed,
+			Output: fmt.Sprintf("no filesystem check available for %s", fsType),
+		}
+	}
+
+	klog.Infof("device: checking filesystem on %s: %s %v", devicePath, cmd, args)
+	out, err := exec.Command(cmd, args...).CombinedOutput()
+	result := fsckResult{Status: FsckClean, Output: truncateFsckOutput(string(out))}
+	if err != nil {
+		exitErr, ok := err.(utilexec.ExitError)
+		if !ok {
+			result.Status = FsckCheckFailed
+			result.Output = err.Error()
+			return result
+		}
+		result.Status = parseFsckExitCode(fsType, exitErr.ExitStatus())
+	}
+	klog.Infof("device: filesystem check of %s: %s", devicePath, result.Status)
+	return result
+}
+
+// truncateFsckOutput keeps the end of the output, where the tools
+// print the summary
+func truncateFsckOutput(out string) string {
+	out = strings.TrimSpace(out)
+	if len(out) > maxFsckOutputLen {
+		out = "..." + out[len(out)-maxFsckOutputLen:]
+	}
+	return out
+}
+
+// mountWithFsckPolicy checks the filesystem of the volume as per its fsck
+// policy and mounts it. A volume with errors which could not be corrected
+// is mounted read only if the fsck fallback of the volume says so.
+func mountWithFsckPolicy(mounter *mount.SafeFormatAndMount, vol *apis.DeviceVolume,
+	devicePath string, mountInfo *MountInfo) error {
+	readOnly := isReadOnly(mountInfo.MountOptions)
+
+	existingFormat, err := mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return err
+	}
+	if existingFormat == "" {
+		return fmt.Errorf("cannot mount unformatted volume %s read only", vol.Name)
+	}
+
+	target := mountInfo.MountPath
+	fsType := mountInfo.FSType
+	options := mountInfo.MountOptions
+
+	var result fsckResult
+	switch vol.Spec.FsckPolicy {
+	case FsckPolicyAlways:
+		result = checkFilesystem(mounter.Exec, fsType, devicePath, readOnly)
+		recordFsckResult(vol, result)
+		if result.Status != FsckUncorrected {
+			return mounter.Mount(devicePath, target, fsType, options)
+		}
+	case FsckPolicyOnError:
+		err = mounter.Mount(devicePath, target, fsType, options)
+		if err == nil {
+			return nil
+		}
+		klog.Errorf("device: mount of volume %s failed, checking the filesystem: %v", vol.Name, err)
+		result = checkFilesystem(mounter.Exec, fsType, devicePath, readOnly)
+		recordFsckResult(vol, result)
+		switch result.Status {
+		case FsckUncorrected:
+		case FsckRepaired:
+			if err = mounter.Mount(devicePath, target, fsType, options); err == nil {
+				return nil
+			}
+			return fmt.Errorf("mount failed after the filesystem was repaired: %v", err)
+		default:
+			// the filesystem is not the cause of the failure, or it
+			// could not be checked, the fallback does not apply
+			return err
+		}
+	default:
+		return mounter.Mount(devicePath, target, fsType, options)
+	}
+
+	if vol.Spec.FsckFallback != FsckFallbackReadOnly || readOnly {
+		if err == nil {
+			err = fmt.Errorf("filesystem check of %s found uncorrected errors", devicePath)
+		}
+		return fmt.Errorf("%v: %s", err, result.Output)
+	}
+
+	// skip the journal replay, which writes to the device even
+	// when the filesystem is mounted read only
+	options = append(append([]string{}, options...), "ro")
+	switch fsType {
+	case "ext4", "xfs":
+		options = append(options, "norecovery")
+	case "ext3":
+		options = append(options, "noload")
+	}
+	klog.Warningf("device: mounting volume %s read only at %s", vol.Name, target)
+	if err = mounter.Mount(devicePath, target, fsType, options); err != nil {
+		return fmt.Errorf("read only mount failed: %v: %s", err, result.Output)
+	}
+	recordFsckResult(vol, fsckResult{Status: FsckReadOnlyFallback, Output: result.Output})
+	return nil
+}
+
+// recordFsckResult sets the FilesystemCheck condition of the volume and
+// emits an event, unless the filesystem was clean.
+func recordFsckResult(vol *apis.DeviceVolume, result fsckResult) {
+	condition := metav1.Condition{
+		Type:    FsckConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  result.Status,
+		Message: result.Output,
+	}
+	eventType := corev1.EventTypeWarning
+	switch result.Status {
+	case FsckClean:
+		eventType = ""
+	case FsckRepaired:
+		eventType = corev1.EventTypeNormal
+	case FsckUncorrected, FsckReadOnlyFallback:
+		condition.Status = metav1.ConditionFalse
+	case FsckCheckFailed:
+		condition.Status = metav1.ConditionUnknown
+	}
+	if condition.Message == "" {
+		condition.Message = "filesystem check: " + result.Status
+	}
+
+	if eventRecorder != nil && eventType != "" {
+		eventRecorder.Event(vol, eventType, result.Status, condition.Message)
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		newVol, err := GetDeviceVolume(vol.Name)
+		if err != nil {
+			return err
+		}
+		condition.ObservedGeneration = newVol.Generation
+		meta.SetStatusCondition(&newVol.Status.Conditions, condition)
+		_, err = volbuilder.NewKubeclient().WithNamespace(DeviceNamespace).Update(newVol)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("device: could not record the filesystem check of %s: %v", vol.Name, err)
+	}
+}
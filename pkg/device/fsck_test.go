@@ -0,0 +1,172 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/mount"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func Test_parseFsckExitCode(t *testing.T) {
+	tests := []struct {
+		fsType string
+		code   int
+		want   string
+	}{
+		{fsType: "ext4", code: 0, want: FsckClean},
+		{fsType: "ext4", code: 1, want: FsckRepaired},
+		{fsType: "ext4", code: 2, want: FsckRepaired},
+		{fsType: "ext4", code: 4, want: FsckUncorrected},
+		{fsType: "ext4", code: 8, want: FsckCheckFailed},
+		{fsType: "xfs", code: 0, want: FsckClean},
+		{fsType: "xfs", code: 1, want: FsckUncorrected},
+		{fsType: "xfs", code: 2, want: FsckClean},
+		{fsType: "btrfs", code: 1, want: FsckUncorrected},
+	}
+	for _, tt := range tests {
+		if got := parseFsckExitCode(tt.fsType, tt.code); got != tt.want {
+			t.Errorf("parseFsckExitCode(%s, %d) = %s, want %s", tt.fsType, tt.code, got, tt.want)
+		}
+	}
+}
+
+func Test_getFsckCommand(t *testing.T) {
+	tests := []struct {
+		fsType   string
+		readOnly bool
+		wantCmd  string
+		wantArgs []string
+	}{
+		{fsType: "ext4", wantCmd: "e2fsck", wantArgs: []string{"-p", "/dev/sdb1"}},
+		{fsType: "ext4", readOnly: true, wantCmd: "e2fsck", wantArgs: []string{"-n", "/dev/sdb1"}},
+		{fsType: "xfs", wantCmd: "xfs_repair", wantArgs: []string{"-n", "/dev/sdb1"}},
+		{fsType: "btrfs", wantCmd: "btrfs", wantArgs: []string{"check", "--readonly", "/dev/sdb1"}},
+		{fsType: "vfat"},
+	}
+	for _, tt := range tests {
+		cmd, args := getFsckCommand(tt.fsType, "/dev/sdb1", tt.readOnly)
+		if cmd != tt.wantCmd || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("getFsckCommand(%s, %v) = %s %v, want %s %v",
+				tt.fsType, tt.readOnly, cmd, args, tt.wantCmd, tt.wantArgs)
+		}
+	}
+}
+
+// failingMounter fails the first mounts
+type failingMounter struct {
+	*mount.FakeMounter
+	failures int
+}
+
+func (m *failingMounter) Mount(source string, target string, fstype string, options []string) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("mount failed")
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func Test_mountWithFsckPolicy_onError(t *testing.T) {
+	fakeCmd := func(out string, err error) testingexec.FakeCommandAction {
+		return func(cmd string, args ...string) utilexec.Cmd {
+			fake := &testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{
+					func() ([]byte, []byte, error) { return []byte(out), nil, err },
+				},
+			}
+			return testingexec.InitFakeCmd(fake, cmd, args...)
+		}
+	}
+
+	tests := map[string]struct {
+		fsckCode    int
+		fallback    string
+		failures    int
+		isErr       bool
+		mountedOpts []string
+	}{
+		"clean filesystem returns the mount error": {
+			fsckCode: 0, fallback: FsckFallbackReadOnly, failures: 1, isErr: true,
+		},
+		"check failure returns the mount error": {
+			fsckCode: 8, fallback: FsckFallbackReadOnly, failures: 1, isErr: true,
+		},
+		"repaired filesystem is mounted": {
+			fsckCode: 1, failures: 1, mountedOpts: []string{},
+		},
+		"repaired filesystem failing again": {
+			fsckCode: 1, failures: 2, isErr: true,
+		},
+		"uncorrected errors fail the mount": {
+			fsckCode: 4, fallback: FsckFallbackFail, failures: 1, isErr: true,
+		},
+		"uncorrected errors fall back to read only": {
+			fsckCode: 4, fallback: FsckFallbackReadOnly, failures: 1,
+			mountedOpts: []string{"ro", "norecovery"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var fsckErr error
+			if test.fsckCode != 0 {
+				fsckErr = testingexec.FakeExitError{Status: test.fsckCode}
+			}
+			mounter := &failingMounter{FakeMounter: mount.NewFakeMounter(nil), failures: test.failures}
+			safeMounter := &mount.SafeFormatAndMount{
+				Interface: mounter,
+				Exec: &testingexec.FakeExec{CommandScript: []testingexec.FakeCommandAction{
+					fakeCmd("DEVNAME=/dev/sda1\nTYPE=ext4\n", nil),
+					fakeCmd("", fsckErr),
+				}},
+			}
+			vol := &apis.DeviceVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+				Spec: apis.VolumeInfo{
+					FsckPolicy:   FsckPolicyOnError,
+					FsckFallback: test.fallback,
+				},
+			}
+			mountInfo := &MountInfo{MountPath: "/mnt/pvc-1", FSType: "ext4"}
+
+			err := mountWithFsckPolicy(safeMounter, vol, "/dev/sda1", mountInfo)
+			if (err != nil) != test.isErr {
+				t.Fatalf("mountWithFsckPolicy() error = %v, isErr %v", err, test.isErr)
+			}
+			if test.isErr {
+				if len(mounter.MountPoints) != 0 {
+					t.Errorf("mountWithFsckPolicy() mounted %v", mounter.MountPoints)
+				}
+				return
+			}
+			if len(mounter.MountPoints) != 1 {
+				t.Fatalf("mountWithFsckPolicy() mounted %v", mounter.MountPoints)
+			}
+			if opts := mounter.MountPoints[0].Opts; !reflect.DeepEqual(opts, test.mountedOpts) {
+				t.Errorf("mountWithFsckPolicy() mount options = %v, want %v", opts, test.mountedOpts)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/mount"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+const (
+	// procMountInfo lists the mounts of the agent, with the
+	// options of the mounts and of their superblocks
+	procMountInfo = "/proc/self/mountinfo"
+
+	// sysfsExt4Dir holds the counters of the mounted ext4 filesystems
+	sysfsExt4Dir = "/sys/fs/ext4"
+)
+
+// VolumeCondition is the health of a volume as seen by the node agent
+type VolumeCondition struct {
+	// Abnormal is set when the volume needs attention
+	Abnormal bool
+	// Message describes the condition of the volume
+	Message string
+}
+
+// GetVolumeCondition checks the health of the volume mounted at the given
+// path. The volume is abnormal when its partition is missing, when the
+// filesystem went read only behind the back of the pods or when the kernel
+// recorded filesystem errors.
+func GetVolumeCondition(vol *apis.DeviceVolume, path string) VolumeCondition {
+	devicePath, err := GetVolumeDevPath(vol)
+	if err == nil {
+		_, err = os.Stat(devicePath)
+	}
+	if err != nil {
+		return VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("device of the volume is missing: %v", err),
+		}
+	}
+
+	cond := meta.FindStatusCondition(vol.Status.Conditions, FsckConditionType)
+	if cond != nil && cond.Status == metav1.ConditionFalse {
+		return VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("filesystem check: %s: %s", cond.Reason, cond.Message),
+		}
+	}
+
+	if readOnly, err := isSuperblockReadOnly(procMountInfo, path); err == nil && readOnly {
+		return VolumeCondition{
+			Abnormal: true,
+			Message:  "filesystem was remounted read only, check the kernel log of the node",
+		}
+	}
+
+	if count, err := getExt4ErrorCount(devicePath); err == nil && count > 0 {
+		return VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("kernel recorded %d filesystem errors", count),
+		}
+	}
+
+	return VolumeCondition{Message: "volume is healthy"}
+}
+
+// isSuperblockReadOnly checks if the filesystem mounted read write at the
+// path went read only, which the kernel does on errors when mounted with
+// errors=remount-ro. The read only mounts asked by the pods are fine.
+func isSuperblockReadOnly(mountInfoPath string, path string) (bool, error) {
+	infos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		if info.MountPoint != path {
+			continue
+		}
+		return hasOption(info.SuperOptions, "ro") && hasOption(info.MountOptions, "rw"), nil
+	}
+	return false, fmt.Errorf("%s is not mounted", path)
+}
+
+func hasOption(options []string, option string) bool {
+	for _, opt := range options {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// getExt4ErrorCount returns the number of errors recorded by the kernel in
+// the superblock of the ext4 filesystem of the device. The counter is kept
+// across mounts until the filesystem is repaired. Other filesystems don't
+// expose such a counter, a shut down xfs fails the statfs of the volume.
+func getExt4ErrorCount(devicePath string) (uint64, error) {
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(filepath.Join(sysfsExt4Dir, filepath.Base(resolved), "errors_count"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
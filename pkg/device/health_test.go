@@ -0,0 +1,71 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testMountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+120 22 8:18 / /var/lib/kubelet/plugins/kubernetes.io/csi/device.csi.openebs.io/abc/globalmount rw,relatime shared:60 - ext4 /dev/sdb2 ro,errors=remount-ro
+121 22 8:18 / /var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pvc-1/mount rw,relatime shared:60 - ext4 /dev/sdb2 ro,errors=remount-ro
+122 22 8:19 / /var/lib/kubelet/pods/p2/volumes/kubernetes.io~csi/pvc-2/mount ro,relatime shared:61 - xfs /dev/sdb3 ro
+123 22 8:20 / /var/lib/kubelet/pods/p3/volumes/kubernetes.io~csi/pvc-3/mount rw,relatime shared:62 - xfs /dev/sdb4 rw
+`
+
+func Test_isSuperblockReadOnly(t *testing.T) {
+	mountInfoPath := filepath.Join(t.TempDir(), "mountinfo")
+	if err := os.WriteFile(mountInfoPath, []byte(testMountInfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		path    string
+		want    bool
+		wantErr bool
+	}{
+		"remounted read only": {
+			path: "/var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pvc-1/mount",
+			want: true,
+		},
+		"read only mount": {
+			path: "/var/lib/kubelet/pods/p2/volumes/kubernetes.io~csi/pvc-2/mount",
+			want: false,
+		},
+		"read write": {
+			path: "/var/lib/kubelet/pods/p3/volumes/kubernetes.io~csi/pvc-3/mount",
+			want: false,
+		},
+		"not mounted": {
+			path:    "/var/lib/kubelet/pods/p4/volumes/kubernetes.io~csi/pvc-4/mount",
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := isSuperblockReadOnly(mountInfoPath, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isSuperblockReadOnly() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isSuperblockReadOnly() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+// Disk image formats supported by the populator
+const (
+	ImageFormatRaw   = "raw"
+	ImageFormatQcow2 = "qcow2"
+)
+
+// ImageConvert converts a qcow2 image into raw format straight on the
+// device, -n skips the creation of the target which already exists.
+const ImageConvert = "qemu-img convert -n -f qcow2 -O raw"
+
+// qcow2 header, see https://github.com/qemu/qemu/blob/master/docs/interop/qcow2.txt
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+const (
+	qcow2VersionOffset     = 4
+	qcow2BackingFileOffset = 8
+	qcow2SizeOffset        = 24
+	// qcow2IncompatibleOffset is the offset of the incompatible
+	// features of a version 3 header
+	qcow2IncompatibleOffset = 72
+	// qcow2HeaderSize and qcow2V3HeaderSize are the minimum sizes of
+	// the version 2 and the version 3 headers
+	qcow2HeaderSize   = 72
+	qcow2V3HeaderSize = 104
+	// qcow2ExternalDataFile is the incompatible feature bit of the
+	// images whose data is stored in an external file
+	qcow2ExternalDataFile = 1 << 2
+)
+
+// imageDownloadTimeout bounds the download of an image, the volumes of
+// the node are provisioned one at a time
+const imageDownloadTimeout = 10 * time.Minute
+
+// PopulateVolume writes the disk image set in the volume spec into the
+// volume, converting qcow2 images to raw. The image must fit the volume
+// capacity. The image errors which won't go away on a retry, e.g. an image
+// too large for the volume, are returned as VolumeError.
+func PopulateVolume(vol *apis.DeviceVolume) error {
+	capacityBytes, err := strconv.ParseUint(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	path, cleanup, err := fetchImage(vol.Spec.ImageSource, capacityBytes)
+	if err != nil {
+		klog.Errorf("could not fetch image %s for volume %s: %v", vol.Spec.ImageSource, vol.Name, err)
+		return err
+	}
+	defer cleanup()
+
+	format, size, err := inspectImage(path)
+	if err != nil {
+		return &apis.VolumeError{Code: apis.Internal, Message: err.Error()}
+	}
+	if size > capacityBytes {
+		return &apis.VolumeError{
+			Code: apis.Internal,
+			Message: fmt.Sprintf("image %s of size %d does not fit the volume capacity %d",
+				vol.Spec.ImageSource, size, capacityBytes),
+		}
+	}
+
+	devicePath, err := GetVolumeDevPath(vol)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Writing %s image %s of size %d into volume %s at %s",
+		format, vol.Spec.ImageSource, size, vol.Name, devicePath)
+	switch format {
+	case ImageFormatQcow2:
+		_, err = RunCommand(append(strings.Split(ImageConvert, " "), path, devicePath))
+	default:
+		err = writeRawImage(path, devicePath)
+	}
+	if err != nil {
+		klog.Errorf("could not write image %s into volume %s: %v", vol.Spec.ImageSource, vol.Name, err)
+	}
+	return err
+}
+
+// fetchImage returns the local path of the image. Images served over http
+// are downloaded into a temporary file, which is removed by the returned
+// cleanup function, and can't be larger than the capacity. Local images
+// must reside in the image directory.
+func fetchImage(source string, capacity uint64) (string, func(), error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return downloadImage(source, capacity)
+	}
+
+	path := strings.TrimPrefix(source, "file://")
+	if !filepath.IsAbs(path) {
+		return "", nil, &apis.VolumeError{
+			Code:    apis.Internal,
+			Message: fmt.Sprintf("invalid image source %q, expected an absolute path or a http(s) URL", source),
+		}
+	}
+	if err := checkImagePath(path, DeviceConfiguration.ImageDir); err != nil {
+		return "", nil, err
+	}
+	return path, func() {}, nil
+}
+
+// checkImagePath makes sure that the image path, after resolving the
+// symlinks, is inside the image directory.
+func checkImagePath(path string, imageDir string) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.EvalSymlinks(imageDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return &apis.VolumeError{
+			Code:    apis.Internal,
+			Message: fmt.Sprintf("image %s is not inside the image directory %s", path, imageDir),
+		}
+	}
+	return nil
+}
+
+// downloadImage downloads the image into a temporary file. The download
+// is stopped once the image is larger than the capacity, so that an endless
+// response can't fill the filesystem of the node.
+func downloadImage(url string, capacity uint64) (string, func(), error) {
+	client := &http.Client{Timeout: imageDownloadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("could not download image %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength > 0 && uint64(resp.ContentLength) > capacity {
+		return "", nil, imageTooLargeError(url, capacity)
+	}
+
+	f, err := os.CreateTemp("", "device-image-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		if err := os.Remove(f.Name()); err != nil {
+			klog.Errorf("could not remove downloaded image %s: %v", f.Name(), err)
+		}
+	}
+	n, err := io.Copy(f, io.LimitReader(resp.Body, int64(capacity)+1))
+	if err == nil && uint64(n) > capacity {
+		err = imageTooLargeError(url, capacity)
+	}
+	if err != nil {
+		_ = f.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err = f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// imageTooLargeError returns the error of an image which can't fit the volume
+func imageTooLargeError(url string, capacity uint64) error {
+	return &apis.VolumeError{
+		Code:    apis.Internal,
+		Message: fmt.Sprintf("image %s is larger than the volume capacity %d", url, capacity),
+	}
+}
+
+// inspectImage detects the format of the image and returns it along with
+// the size of the disk the image represents.
+func inspectImage(path string) (string, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return "", 0, fmt.Errorf("image %s is not a regular file", path)
+	}
+
+	header := make([]byte, qcow2V3HeaderSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", 0, err
+	}
+	if n < len(qcow2Magic) || !bytes.Equal(header[:len(qcow2Magic)], qcow2Magic) {
+		return ImageFormatRaw, uint64(info.Size()), nil
+	}
+	if err = checkQcow2Header(header[:n]); err != nil {
+		return "", 0, fmt.Errorf("image %s: %v", path, err)
+	}
+	return ImageFormatQcow2, binary.BigEndian.Uint64(header[qcow2SizeOffset:]), nil
+}
+
+// checkQcow2Header makes sure that the qcow2 image is standalone. The images
+// with a backing file or an external data file would have qemu-img, run as
+// root, copy any file or device of the node into the volume.
+func checkQcow2Header(header []byte) error {
+	if len(header) < qcow2HeaderSize {
+		return fmt.Errorf("truncated qcow2 header")
+	}
+	version := binary.BigEndian.Uint32(header[qcow2VersionOffset:])
+	if version != 2 && version != 3 {
+		return fmt.Errorf("unsupported qcow2 version %d", version)
+	}
+	if binary.BigEndian.Uint64(header[qcow2BackingFileOffset:]) != 0 {
+		return fmt.Errorf("qcow2 images with a backing file are not supported")
+	}
+	if version == 3 {
+		if len(header) < qcow2V3HeaderSize {
+			return fmt.Errorf("truncated qcow2 header")
+		}
+		if binary.BigEndian.Uint64(header[qcow2IncompatibleOffset:])&qcow2ExternalDataFile != 0 {
+			return fmt.Errorf("qcow2 images with an external data file are not supported")
+		}
+	}
+	return nil
+}
+
+// writeRawImage copies the raw image onto the device
+func writeRawImage(path string, devicePath string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,187 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func qcow2Header(size uint64) []byte {
+	header := make([]byte, 512)
+	copy(header, qcow2Magic)
+	binary.BigEndian.PutUint32(header[4:], 3)
+	binary.BigEndian.PutUint64(header[qcow2SizeOffset:], size)
+	return header
+}
+
+func qcow2BackingHeader(size uint64) []byte {
+	header := qcow2Header(size)
+	binary.BigEndian.PutUint64(header[qcow2BackingFileOffset:], 512)
+	copy(header[512-len("/dev/sda"):], "/dev/sda")
+	return header
+}
+
+func qcow2ExternalDataHeader(size uint64) []byte {
+	header := qcow2Header(size)
+	binary.BigEndian.PutUint64(header[qcow2IncompatibleOffset:], qcow2ExternalDataFile)
+	return header
+}
+
+func TestFetchAndInspectImage(t *testing.T) {
+	images := map[string][]byte{
+		"/disk.qcow2":     qcow2Header(1 << 30),
+		"/disk.img":       make([]byte, 4096),
+		"/backing.qcow2":  qcow2BackingHeader(1 << 30),
+		"/external.qcow2": qcow2ExternalDataHeader(1 << 30),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/endless.img" {
+			// flushing the first chunk leaves the length unknown
+			for i := 0; i < 16; i++ {
+				_, _ = w.Write(make([]byte, 1024))
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
+		data, ok := images[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		source     string
+		capacity   uint64
+		wantFormat string
+		wantSize   uint64
+		wantErr    bool
+		// wantInspectErr is set for the images rejected by inspectImage
+		wantInspectErr bool
+	}{
+		{
+			name:       "qcow2 image",
+			source:     server.URL + "/disk.qcow2",
+			wantFormat: ImageFormatQcow2,
+			wantSize:   1 << 30,
+		},
+		{
+			name:       "raw image",
+			source:     server.URL + "/disk.img",
+			wantFormat: ImageFormatRaw,
+			wantSize:   4096,
+		},
+		{
+			name:    "missing image",
+			source:  server.URL + "/missing.img",
+			wantErr: true,
+		},
+		{
+			name:     "image larger than the volume",
+			source:   server.URL + "/disk.img",
+			capacity: 1024,
+			wantErr:  true,
+		},
+		{
+			name:     "endless image",
+			source:   server.URL + "/endless.img",
+			capacity: 4096,
+			wantErr:  true,
+		},
+		{
+			name:           "qcow2 image with a backing file",
+			source:         server.URL + "/backing.qcow2",
+			wantInspectErr: true,
+		},
+		{
+			name:           "qcow2 image with an external data file",
+			source:         server.URL + "/external.qcow2",
+			wantInspectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capacity := tt.capacity
+			if capacity == 0 {
+				capacity = 1 << 20
+			}
+			path, cleanup, err := fetchImage(tt.source, capacity)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer cleanup()
+			format, size, err := inspectImage(path)
+			if (err != nil) != tt.wantInspectErr {
+				t.Fatalf("inspectImage() error = %v, wantInspectErr %v", err, tt.wantInspectErr)
+			}
+			if tt.wantInspectErr {
+				return
+			}
+			if format != tt.wantFormat || size != tt.wantSize {
+				t.Errorf("inspectImage() = %v, %v, want %v, %v", format, size, tt.wantFormat, tt.wantSize)
+			}
+		})
+	}
+}
+
+func Test_checkImagePath(t *testing.T) {
+	dir := t.TempDir()
+	imageDir := filepath.Join(dir, "images")
+	if err := os.Mkdir(imageDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(imageDir, "disk.img")
+	outside := filepath.Join(dir, "secret")
+	link := filepath.Join(imageDir, "link.img")
+	for _, f := range []string{inside, outside} {
+		if err := os.WriteFile(f, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "image inside the directory", path: inside},
+		{name: "image outside the directory", path: outside, wantErr: true},
+		{name: "symlink escaping the directory", path: link, wantErr: true},
+		{name: "relative path escaping the directory", path: filepath.Join(imageDir, "..", "secret"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkImagePath(tt.path, imageDir); (err != nil) != tt.wantErr {
+				t.Errorf("checkImagePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mnt "github.com/openebs/lib-csi/pkg/mount"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// PartitionRename sets the GPT name of a partition
+const PartitionRename = "parted /dev/%s name %d %s --script"
+
+// diskByPartUUIDDir holds the links named after the GPT partition GUIDs
+const diskByPartUUIDDir = "/dev/disk/by-partuuid"
+
+// importError returns the error failing the import of a volume for good,
+// the other errors are retried.
+func importError(format string, args ...interface{}) error {
+	return &apis.VolumeError{
+		Code:    apis.ImportFailed,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// resolveImport returns the path of the link naming the imported partition
+// or disk.
+func resolveImport(imp *apis.VolumeImport) (string, error) {
+	switch {
+	case imp.PartUUID != "" && imp.DiskID != "":
+		return "", importError("only one of partUUID and diskID can be set")
+	case imp.PartUUID != "":
+		// udev names the links after the lower case GUIDs
+		return filepath.Join(diskByPartUUIDDir, strings.ToLower(imp.PartUUID)), nil
+	case imp.DiskID != "":
+		return filepath.Join(diskByIDDir, imp.DiskID), nil
+	}
+	return "", importError("neither partUUID nor diskID is set")
+}
+
+// getPartitionOf returns the kernel name of the disk holding the partition
+// and the number of the partition. isPart is false for a whole disk.
+func getPartitionOf(sysBlockDir string, name string) (disk string, partNum uint32, isPart bool, err error) {
+	dir := filepath.Join(sysBlockDir, name)
+	data, err := os.ReadFile(filepath.Join(dir, "partition"))
+	if os.IsNotExist(err) {
+		if _, err = os.Stat(dir); err != nil {
+			return "", 0, false, err
+		}
+		return name, 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+	num, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("invalid partition number of %s: %v", name, err)
+	}
+	// the entry of a partition links inside the one of its disk
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return filepath.Base(filepath.Dir(resolved)), uint32(num), true, nil
+}
+
+// importVolume adopts the partition or the disk named by the import of the
+// volume, keeping its content. The partition is renamed after the volume,
+// the whole disk is claimed by the volume.
+func importVolume(vol *apis.DeviceVolume) error {
+	if vol.Spec.ImageSource != "" {
+		return importError("an imported volume can't be populated from an image")
+	}
+	link, err := resolveImport(vol.Spec.Import)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		// the disk may not be attached yet
+		return fmt.Errorf("could not resolve %s: %v", link, err)
+	}
+	disk, partNum, isPart, err := getPartitionOf(sysfsBlockDir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	capacity, err := strconv.ParseUint(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return importError("invalid capacity %q: %v", vol.Spec.Capacity, err)
+	}
+
+	switch {
+	case vol.Spec.Allocation == AllocationWholeDisk && isPart:
+		return importError("%s is a partition, a wholeDisk volume imports a disk", link)
+	case vol.Spec.Allocation == AllocationWholeDisk:
+		return importWholeDisk(vol, disk, capacity)
+	case !HasPartition(vol):
+		return importError("volumes with %s allocation can't be imported", vol.Spec.Allocation)
+	case !isPart:
+		return importError("%s is a disk, a partition volume imports a partition", link)
+	}
+	return importPartition(vol, disk, partNum, capacity)
+}
+
+// importPartition renames the partition after the volume. The partition
+// must be on a disk with the meta partition matching the device name of the
+// volume, so that it is found like the partitions created by the driver.
+func importPartition(vol *apis.DeviceVolume, disk string, partNum uint32, capacity uint64) error {
+	rows, err := GetPartitionList(disk, vol.Spec.DevName, false)
+	if err != nil {
+		return importError("could not list the partitions of disk %s with device name %s: %v",
+			disk, vol.Spec.DevName, err)
+	}
+	if len(rows) == 0 {
+		return importError("disk %s has no partition", disk)
+	}
+	if _, ok := getMetaPartition(rows[0]); !ok {
+		return importError("disk %s has no meta partition, it must be brought under management with manage-disk", disk)
+	}
+	if partNum == metaPartitionNumber {
+		return importError("partition %d of disk %s is the meta partition", partNum, disk)
+	}
+
+	partName := vol.Status.PartitionName
+	if partName == "" {
+		partName = newPartitionName(vol)
+		vol.Status.PartitionName = partName
+	}
+	var part *partedOutput
+	for i := range rows {
+		if rows[i].partNum == partNum {
+			part = &rows[i]
+		} else if rows[i].partName == partName {
+			return importError("partition %d of disk %s is already named %s",
+				rows[i].partNum, disk, partName)
+		}
+	}
+	if part == nil {
+		return fmt.Errorf("partition %d not found on disk %s", partNum, disk)
+	}
+	if part.partName == partName {
+		klog.Infof("Partition %d of disk %s already imported by %s", partNum, disk, vol.Name)
+		if vol.Status.Partition == nil {
+			used, err := parsePartUsed(disk, *part)
+			if err != nil {
+				return err
+			}
+			vol.Status.Partition = getPartitionLocation(used)
+		}
+		return updateJournal(disk, func(j *Journal) {
+			if j.getPartition(partName) == nil {
+				j.putPartition(newPartitionRecord(vol, partName, part.size))
+			}
+		})
+	}
+	if isThinPoolPartition(part.partName) {
+		return importError("partition %d of disk %s backs the thin pool", partNum, disk)
+	}
+	if part.size < capacity {
+		return importError("partition %d of disk %s has %d bytes, less than the capacity %d",
+			partNum, disk, part.size, capacity)
+	}
+	if owner, err := getPartitionOwner(part.partName); err != nil {
+		return err
+	} else if owner != "" {
+		return importError("partition %d of disk %s belongs to volume %s", partNum, disk, owner)
+	}
+
+	devicePath := getPartitionPath(disk, partNum)
+	mounts, err := mnt.GetMounts(devicePath)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return importError("partition %s is mounted at %v, it must be unmounted to be imported",
+			devicePath, mounts)
+	}
+
+	klog.Infof("Importing partition %s as volume %s, renaming %q to %q",
+		devicePath, vol.Name, part.partName, partName)
+	_, err = RunCommand(strings.Split(fmt.Sprintf(PartitionRename, disk, partNum, partName), " "))
+	if err != nil {
+		return err
+	}
+	used, err := parsePartUsed(disk, *part)
+	if err != nil {
+		return err
+	}
+	vol.Status.Partition = getPartitionLocation(used)
+	return updateJournal(disk, func(j *Journal) {
+		j.putPartition(newPartitionRecord(vol, partName, part.size))
+	})
+}
+
+// getPartitionOwner returns the volume of this node backed by the
+// partition with the given name, if any.
+func getPartitionOwner(partName string) (string, error) {
+	vols, err := volbuilder.NewKubeclient().
+		WithNamespace(DeviceNamespace).
+		List(metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for i := range vols.Items {
+		vol := &vols.Items[i]
+		if vol.Spec.OwnerNodeID == NodeID && HasPartition(vol) &&
+			GetVolumePartitionName(vol) == partName {
+			return vol.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// importWholeDisk claims the disk for the volume without wiping it. The
+// disk must match the whole disk regex of the node, and its stable name
+// the device name of the volume.
+func importWholeDisk(vol *apis.DeviceVolume, disk string, capacity uint64) error {
+	if DeviceConfiguration.WholeDiskRegex == nil ||
+		!DeviceConfiguration.WholeDiskRegex.MatchString(disk) {
+		return importError("disk %s doesn't match the whole disk regex of the node", disk)
+	}
+	name := getDiskStableName(disk)
+	if vol.Status.ClaimedDisk == name {
+		klog.Infof("Disk %s already imported by %s", name, vol.Name)
+		return nil
+	}
+	devRegex, err := regexp.Compile(vol.Spec.DevName)
+	if err != nil {
+		return importError("invalid device name %q: %v", vol.Spec.DevName, err)
+	}
+	if !devRegex.MatchString(name) {
+		return importError("disk %s doesn't match the device name %s", name, vol.Spec.DevName)
+	}
+	claims, err := getWholeDiskClaims()
+	if err != nil {
+		return err
+	}
+	if owner := claims[name]; owner != "" && owner != vol.Name {
+		return importError("disk %s is claimed by volume %s", name, owner)
+	}
+	diskList, err := getDiskList()
+	if err != nil {
+		return err
+	}
+	for _, d := range diskList {
+		if d.DiskPath == disk && d.Size < capacity {
+			return importError("disk %s has %d bytes, less than the capacity %d", name, d.Size, capacity)
+		}
+	}
+	mounts, err := mnt.GetMounts("/dev/" + disk)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return importError("disk %s is mounted at %v, it must be unmounted to be imported", name, mounts)
+	}
+
+	klog.Infof("Importing disk %s (/dev/%s) as volume %s", name, disk, vol.Name)
+	vol.Status.ClaimedDisk = name
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func Test_resolveImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		imp     apis.VolumeImport
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "partuuid",
+			imp:  apis.VolumeImport{PartUUID: "6F3B2C1A-7E1D-4D6B-9C1E-2B8F0A5D4E3C"},
+			want: "/dev/disk/by-partuuid/6f3b2c1a-7e1d-4d6b-9c1e-2b8f0a5d4e3c",
+		},
+		{
+			name: "disk id",
+			imp:  apis.VolumeImport{DiskID: "ata-ST4000DM004_ZFN0XXXX-part2"},
+			want: "/dev/disk/by-id/ata-ST4000DM004_ZFN0XXXX-part2",
+		},
+		{
+			name:    "both",
+			imp:     apis.VolumeImport{PartUUID: "6f3b2c1a", DiskID: "ata-ST4000DM004_ZFN0XXXX-part2"},
+			wantErr: true,
+		},
+		{
+			name:    "none",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveImport(&tt.imp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveImport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if volErr, ok := err.(*apis.VolumeError); !ok || volErr.Code != apis.ImportFailed {
+					t.Errorf("resolveImport() error = %v, want an ImportFailed error", err)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("resolveImport() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getPartitionOf(t *testing.T) {
+	dir := t.TempDir()
+	sysBlockDir := filepath.Join(dir, "class", "block")
+	diskDir := filepath.Join(dir, "devices", "nvme0n1")
+	for _, d := range []string{sysBlockDir, filepath.Join(diskDir, "nvme0n1p3")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(diskDir, "nvme0n1p3", "partition"), []byte("3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		filepath.Join(sysBlockDir, "nvme0n1"):   diskDir,
+		filepath.Join(sysBlockDir, "nvme0n1p3"): filepath.Join(diskDir, "nvme0n1p3"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		wantDisk    string
+		wantPartNum uint32
+		wantIsPart  bool
+		wantErr     bool
+	}{
+		{name: "nvme0n1p3", wantDisk: "nvme0n1", wantPartNum: 3, wantIsPart: true},
+		{name: "nvme0n1", wantDisk: "nvme0n1"},
+		{name: "sdz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk, partNum, isPart, err := getPartitionOf(sysBlockDir, tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getPartitionOf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if disk != tt.wantDisk || partNum != tt.wantPartNum || isPart != tt.wantIsPart {
+				t.Errorf("getPartitionOf() = %v, %v, %v, want %v, %v, %v",
+					disk, partNum, isPart, tt.wantDisk, tt.wantPartNum, tt.wantIsPart)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	clientset "github.com/openebs/device-localpv/pkg/generated/clientset/internalclientset"
+	informers "github.com/openebs/device-localpv/pkg/generated/informer/externalversions"
+	listers "github.com/openebs/device-localpv/pkg/generated/lister/device/v1alpha1"
+)
+
+const (
+	// DefaultCgroupRoot is where the cgroup v2 hierarchy is mounted
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+
+	// cgroupIOMax is the file of the cgroup v2 io controller holding
+	// the bandwidth and IOPS limits per device
+	cgroupIOMax = "io.max"
+
+	// sysfsBlockDir holds the block devices and their partitions
+	sysfsBlockDir = "/sys/class/block"
+
+	// kubeletDir is the root directory of the kubelet, holding
+	// the publish paths of the volumes
+	kubeletDir = "/var/lib/kubelet"
+
+	// ioThrottleInterval is the interval between the reconciliations
+	// of the io.max entries of the pods
+	ioThrottleInterval = time.Minute
+)
+
+// volumeLister lists the DeviceVolumes from the informer cache, it is nil
+// until SetupVolumeLister has been called
+var volumeLister listers.DeviceVolumeLister
+
+var (
+	// publish path of a filesystem volume,
+	// <kubelet>/pods/<pod uid>/volumes/kubernetes.io~csi/<pv>/mount
+	podVolumeRegex = regexp.MustCompile(`/pods/([0-9a-f-]+)/volumes/`)
+	// publish path of a block volume,
+	// <kubelet>/plugins/kubernetes.io/csi/volumeDevices/publish/<pv>/<pod uid>
+	podBlockRegex = regexp.MustCompile(`/volumeDevices/publish/[^/]+/([0-9a-f-]+)$`)
+)
+
+// getPodUID returns the UID of the pod a volume is published to,
+// from the target path of the publish
+func getPodUID(targetPath string) (string, error) {
+	for _, r := range []*regexp.Regexp{podVolumeRegex, podBlockRegex} {
+		if match := r.FindStringSubmatch(targetPath); match != nil {
+			return match[1], nil
+		}
+	}
+	return "", fmt.Errorf("no pod uid in target path %s", targetPath)
+}
+
+// findPodCgroup looks for the cgroup of the pod under the kubepods cgroups,
+// named pod<uid> by the cgroupfs driver and kubepods-<qos>-pod<uid>.slice,
+// with the dashes of the uid replaced by underscores, by the systemd driver.
+func findPodCgroup(root string, podUID string) (string, error) {
+	names := []string{
+		"pod" + podUID,
+		"pod" + strings.ReplaceAll(podUID, "-", "_") + ".slice",
+	}
+	isPodCgroup := func(name string) bool {
+		for _, n := range names {
+			if strings.HasSuffix(name, n) {
+				return true
+			}
+		}
+		return false
+	}
+	// descend into the kubepods and the qos cgroups only
+	isKubepodsCgroup := func(name string) bool {
+		return strings.HasPrefix(name, "kubepods") ||
+			name == "burstable" || name == "besteffort"
+	}
+
+	dirs := []string{root}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if isPodCgroup(entry.Name()) {
+				return path, nil
+			}
+			if isKubepodsCgroup(entry.Name()) {
+				dirs = append(dirs, path)
+			}
+		}
+	}
+	return "", fmt.Errorf("cgroup of pod %s not found in %s", podUID, root)
+}
+
+// getThrottledDevice returns the major:minor of the disk whose IOs are
+// throttled for the device. The io controller only accepts whole disks,
+// so that the IOs of a partition are throttled on its disk.
+func getThrottledDevice(sysBlockDir string, devicePath string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(sysBlockDir, filepath.Base(resolved))
+	if _, err = os.Stat(filepath.Join(dir, "partition")); err == nil {
+		// the entry of a partition links inside the one of its disk
+		if dir, err = filepath.EvalSymlinks(dir); err != nil {
+			return "", err
+		}
+		dir = filepath.Dir(dir)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "dev"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// getIOMaxEntry returns the io.max entry of the device throttled
+// with the limits, the zero or nil limits being unlimited
+func getIOMaxEntry(dev string, limits *apis.IOLimits) string {
+	if limits == nil {
+		limits = &apis.IOLimits{}
+	}
+	format := func(limit int64) string {
+		if limit <= 0 {
+			return "max"
+		}
+		return strconv.FormatInt(limit, 10)
+	}
+	return fmt.Sprintf("%s rbps=%s wbps=%s riops=%s wiops=%s", dev,
+		format(limits.ReadBps), format(limits.WriteBps),
+		format(limits.ReadIOPS), format(limits.WriteIOPS))
+}
+
+// hasIOMaxEntry checks if the content of an io.max file holds the entry
+func hasIOMaxEntry(content string, entry string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// hasIOMaxDevice checks if the content of an io.max file holds
+// an entry for the device
+func hasIOMaxDevice(content string, dev string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, dev+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeIOLimits merges the limits of two volumes throttled on the same disk
+// for a pod, the io controller having a single io.max entry per disk. The
+// strictest limit is kept, so that no volume exceeds its limits at the cost
+// of sharing them with the other volumes, and a zero limit is unlimited.
+func mergeIOLimits(a *apis.IOLimits, b *apis.IOLimits) *apis.IOLimits {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	minLimit := func(x, y int64) int64 {
+		if x <= 0 || (y > 0 && y < x) {
+			return y
+		}
+		return x
+	}
+	return &apis.IOLimits{
+		ReadBps:   minLimit(a.ReadBps, b.ReadBps),
+		WriteBps:  minLimit(a.WriteBps, b.WriteBps),
+		ReadIOPS:  minLimit(a.ReadIOPS, b.ReadIOPS),
+		WriteIOPS: minLimit(a.WriteIOPS, b.WriteIOPS),
+	}
+}
+
+// getPodDiskIOLimits returns the limits of the io.max entry of the disk dev
+// in the cgroup of the pod: the limits of the volume merged with the ones
+// of the other volumes of the node published to the pod on the same disk.
+func getPodDiskIOLimits(vol *apis.DeviceVolume, limits *apis.IOLimits,
+	podUID string, dev string) (*apis.IOLimits, error) {
+	if volumeLister == nil {
+		return limits, nil
+	}
+	vols, err := volumeLister.DeviceVolumes(DeviceNamespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range vols {
+		if other.Name == vol.Name ||
+			other.Spec.OwnerNodeID != NodeID ||
+			other.Spec.IOLimits == nil ||
+			other.Status.State != DeviceStatusReady ||
+			!isPublishedToPod(other, podUID) {
+			continue
+		}
+		devicePath, err := GetVolumeDevPath(other)
+		if err != nil {
+			return nil, err
+		}
+		otherDev, err := getThrottledDevice(sysfsBlockDir, devicePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the disk of %s: %v", devicePath, err)
+		}
+		if otherDev == dev {
+			limits = mergeIOLimits(limits, other.Spec.IOLimits)
+		}
+	}
+	return limits, nil
+}
+
+// isPublishedToPod checks if the volume is published to the pod
+func isPublishedToPod(vol *apis.DeviceVolume, podUID string) bool {
+	for _, path := range getPublishPaths(vol) {
+		if uid, err := getPodUID(path); err == nil && uid == podUID {
+			return true
+		}
+	}
+	return false
+}
+
+// ThrottleVolume applies the IO limits of the volume to the pod which
+// the volume is published to at the target path, by writing the io.max
+// entry of the disk of the volume into the cgroup of the pod. It is a
+// no-op for the volumes without limits and when the entry is already set.
+func ThrottleVolume(vol *apis.DeviceVolume, targetPath string) error {
+	if vol.Spec.IOLimits == nil {
+		return nil
+	}
+	return setPodIOLimits(vol, targetPath, vol.Spec.IOLimits)
+}
+
+// setPodIOLimits writes the io.max entry of the disk of the volume into the
+// cgroup of the pod consuming the volume at the target path, merged with the
+// limits of the other volumes of the pod on the disk. Nil limits remove the
+// entry of the disk, if any, unless another volume of the disk is throttled.
+func setPodIOLimits(vol *apis.DeviceVolume, targetPath string, limits *apis.IOLimits) error {
+	podUID, err := getPodUID(targetPath)
+	if err != nil {
+		return err
+	}
+	cgroup, err := findPodCgroup(DeviceConfiguration.CgroupRoot, podUID)
+	if err != nil {
+		return err
+	}
+	ioMax := filepath.Join(cgroup, cgroupIOMax)
+	content, err := os.ReadFile(ioMax)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("io controller is not enabled for %s, "+
+				"IO limits require the cgroup v2 io controller", cgroup)
+		}
+		return err
+	}
+
+	devicePath, err := GetVolumeDevPath(vol)
+	if err != nil {
+		return err
+	}
+	dev, err := getThrottledDevice(sysfsBlockDir, devicePath)
+	if err != nil {
+		return fmt.Errorf("could not get the disk of %s: %v", devicePath, err)
+	}
+	if limits, err = getPodDiskIOLimits(vol, limits, podUID, dev); err != nil {
+		return err
+	}
+
+	if limits == nil && !hasIOMaxDevice(string(content), dev) {
+		return nil
+	}
+	// an entry without any limit is removed by the kernel
+	entry := getIOMaxEntry(dev, limits)
+	if hasIOMaxEntry(string(content), entry) {
+		return nil
+	}
+	klog.Infof("throttling volume %s of pod %s: %s", vol.Name, podUID, entry)
+	return os.WriteFile(ioMax, []byte(entry), 0)
+}
+
+// getPublishPaths lists the paths the volume is published to on
+// this node, filesystem and block volumes alike
+func getPublishPaths(vol *apis.DeviceVolume) []string {
+	var paths []string
+	for _, pattern := range []string{
+		filepath.Join(kubeletDir, "pods", "*", "volumes", "kubernetes.io~csi", vol.Name, "mount"),
+		filepath.Join(kubeletDir, "plugins", "kubernetes.io", "csi", "volumeDevices", "publish", vol.Name, "*"),
+	} {
+		// the patterns are valid, no need to check the error
+		matches, _ := filepath.Glob(pattern)
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// throttleVolumes sets the io.max entries of the pods consuming the
+// throttled volumes of this node again, in case a pod cgroup was
+// recreated or the limits of a volume were modified
+func throttleVolumes() {
+	vols, err := volumeLister.DeviceVolumes(DeviceNamespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("throttle: could not list the volumes: %v", err)
+		return
+	}
+	for _, vol := range vols {
+		if vol.Spec.OwnerNodeID != NodeID ||
+			vol.Spec.IOLimits == nil ||
+			vol.Status.State != DeviceStatusReady {
+			continue
+		}
+		for _, path := range getPublishPaths(vol) {
+			if err = ThrottleVolume(vol, path); err != nil {
+				klog.V(4).Infof("throttle: could not throttle volume %s at %s: %v", vol.Name, path, err)
+			}
+		}
+	}
+}
+
+// SetupVolumeLister starts the informer of the DeviceVolumes listed by the
+// throttling of the volumes, until the stop channel is closed, and waits
+// for its cache to be synced.
+func SetupVolumeLister(stopCh <-chan struct{}) error {
+	cfg, err := k8sapi.Config().Get()
+	if err != nil {
+		return err
+	}
+	openebsClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(openebsClient,
+		0, informers.WithNamespace(DeviceNamespace))
+	informer := factory.Local().V1alpha1().DeviceVolumes()
+	lister := informer.Lister()
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced) {
+		return fmt.Errorf("device volume informer cache not synced")
+	}
+	volumeLister = lister
+	return nil
+}
+
+// RunIOThrottle reconciles the io.max entries of the pods consuming the
+// throttled volumes, until the stop channel is closed. It requires the
+// lister set up by SetupVolumeLister.
+func RunIOThrottle(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(ioThrottleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			throttleVolumes()
+		case <-stopCh:
+			klog.Info("shutting down io throttle")
+			return
+		}
+	}
+}
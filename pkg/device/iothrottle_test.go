@@ -0,0 +1,197 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+const testPodUID = "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+
+func Test_getPodUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "filesystem volume",
+			path: "/var/lib/kubelet/pods/" + testPodUID + "/volumes/kubernetes.io~csi/pvc-1/mount",
+			want: testPodUID,
+		},
+		{
+			name: "block volume",
+			path: "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-1/" + testPodUID,
+			want: testPodUID,
+		},
+		{
+			name:    "staging path",
+			path:    "/var/lib/kubelet/plugins/kubernetes.io/csi/device.csi.openebs.io/abc/globalmount",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getPodUID(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getPodUID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getPodUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findPodCgroup(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+	}{
+		{
+			name: "systemd driver",
+			dir: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" +
+				"0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice",
+		},
+		{
+			name: "systemd driver guaranteed pod",
+			dir:  "kubepods.slice/kubepods-pod0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice",
+		},
+		{
+			name: "cgroupfs driver",
+			dir:  "kubepods/besteffort/pod" + testPodUID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			want := filepath.Join(root, tt.dir)
+			if err := os.MkdirAll(want, 0755); err != nil {
+				t.Fatal(err)
+			}
+			// cgroups outside of the kubepods are not looked into
+			if err := os.MkdirAll(filepath.Join(root, "system.slice", "pod"+testPodUID), 0755); err != nil {
+				t.Fatal(err)
+			}
+			got, err := findPodCgroup(root, testPodUID)
+			if err != nil {
+				t.Fatalf("findPodCgroup() error = %v", err)
+			}
+			if got != want {
+				t.Errorf("findPodCgroup() = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := findPodCgroup(t.TempDir(), testPodUID); err == nil {
+		t.Errorf("findPodCgroup() expected an error for a missing pod")
+	}
+}
+
+func Test_getThrottledDevice(t *testing.T) {
+	dir := t.TempDir()
+	devDir := filepath.Join(dir, "dev")
+	sysBlockDir := filepath.Join(dir, "class", "block")
+	diskDir := filepath.Join(dir, "devices", "sdb")
+	for _, d := range []string{devDir, sysBlockDir, filepath.Join(diskDir, "sdb2")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(diskDir, "dev"):               "8:16\n",
+		filepath.Join(diskDir, "sdb2", "dev"):       "8:18\n",
+		filepath.Join(diskDir, "sdb2", "partition"): "2\n",
+		filepath.Join(devDir, "sdb"):                "",
+		filepath.Join(devDir, "sdb2"):               "",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		filepath.Join(sysBlockDir, "sdb"):  diskDir,
+		filepath.Join(sysBlockDir, "sdb2"): filepath.Join(diskDir, "sdb2"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for device, want := range map[string]string{
+		"sdb":  "8:16",
+		"sdb2": "8:16",
+	} {
+		got, err := getThrottledDevice(sysBlockDir, filepath.Join(devDir, device))
+		if err != nil {
+			t.Fatalf("getThrottledDevice(%s) error = %v", device, err)
+		}
+		if got != want {
+			t.Errorf("getThrottledDevice(%s) = %v, want %v", device, got, want)
+		}
+	}
+}
+
+func Test_getIOMaxEntry(t *testing.T) {
+	entry := getIOMaxEntry("8:16", &apis.IOLimits{ReadBps: 104857600, WriteIOPS: 500})
+	want := "8:16 rbps=104857600 wbps=max riops=max wiops=500"
+	if entry != want {
+		t.Errorf("getIOMaxEntry() = %v, want %v", entry, want)
+	}
+	content := "259:0 rbps=max wbps=1048576 riops=max wiops=max\n" + want + "\n"
+	if !hasIOMaxEntry(content, want) {
+		t.Errorf("hasIOMaxEntry() = false, want true")
+	}
+	if hasIOMaxEntry(content, "8:16 rbps=max wbps=max riops=max wiops=500") {
+		t.Errorf("hasIOMaxEntry() = true, want false")
+	}
+	if !hasIOMaxDevice(content, "8:16") || hasIOMaxDevice(content, "8:1") {
+		t.Errorf("hasIOMaxDevice() did not match the device of the entries")
+	}
+	if got := getIOMaxEntry("8:16", nil); got != "8:16 rbps=max wbps=max riops=max wiops=max" {
+		t.Errorf("getIOMaxEntry() = %v, want an entry without limits", got)
+	}
+}
+
+func Test_mergeIOLimits(t *testing.T) {
+	tests := map[string]struct {
+		a, b *apis.IOLimits
+		want *apis.IOLimits
+	}{
+		"no limits":  {a: nil, b: nil, want: nil},
+		"one volume": {a: nil, b: &apis.IOLimits{ReadBps: 100}, want: &apis.IOLimits{ReadBps: 100}},
+		"strictest limit": {
+			a:    &apis.IOLimits{ReadBps: 100, WriteBps: 50, ReadIOPS: 10},
+			b:    &apis.IOLimits{ReadBps: 200, WriteBps: 20, WriteIOPS: 5},
+			want: &apis.IOLimits{ReadBps: 100, WriteBps: 20, ReadIOPS: 10, WriteIOPS: 5},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := mergeIOLimits(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeIOLimits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
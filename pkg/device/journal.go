@@ -0,0 +1,455 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+// The journal of a disk is kept in its meta partition, in two slots written
+// alternately, so that a torn write never loses the previous journal. Each
+// slot holds a header followed by the JSON encoded journal:
+//
+//	magic   [8]byte  "OEBSJRNL"
+//	version uint32   journalVersion
+//	length  uint32   length of the JSON payload
+//	seq     uint64   sequence number, the highest valid slot is current
+//	crc     uint32   CRC-32C of the payload
+//	        uint32   reserved
+//
+// The integers are little endian.
+const (
+	journalMagic      = "OEBSJRNL"
+	journalVersion    = 1
+	journalHeaderSize = 32
+	journalSlotSize   = 512 * 1024
+	journalSlots      = 2
+)
+
+// Operations recorded in the intent log of a journal
+const (
+	IntentCreate = "create"
+	IntentDelete = "delete"
+)
+
+var (
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errNoJournal is returned when the meta partition has no journal yet
+	errNoJournal = errors.New("no journal")
+
+	// journalMtx serializes the updates of the journals
+	journalMtx sync.Mutex
+)
+
+// Journal records the partitions of the volumes of a disk, and the create
+// and delete operations in flight on the disk.
+type Journal struct {
+	// InstanceID identifies the driver instance which owns the disk
+	InstanceID string `json:"instanceID,omitempty"`
+
+	// Partitions are the partitions of the volumes of the disk
+	Partitions []PartitionRecord `json:"partitions,omitempty"`
+
+	// Intents are the operations started but not completed on the disk
+	Intents []Intent `json:"intents,omitempty"`
+}
+
+// PartitionRecord records the volume of a partition.
+type PartitionRecord struct {
+	// Name of the partition
+	Name string `json:"name"`
+
+	// PVName is the name of the volume
+	PVName string `json:"pvName"`
+
+	// PVCNamespace and PVCName identify the claim of the volume,
+	// if it was known when the volume was provisioned
+	PVCNamespace string `json:"pvcNamespace,omitempty"`
+	PVCName      string `json:"pvcName,omitempty"`
+
+	// Capacity of the partition in bytes
+	Capacity uint64 `json:"capacity"`
+
+	// FsType is the filesystem of the volume, empty for block volumes
+	FsType string `json:"fsType,omitempty"`
+
+	// VolumeMode is the mode of the volume, Filesystem or Block. The
+	// volumes recorded without mode are filesystem volumes.
+	VolumeMode string `json:"volumeMode,omitempty"`
+
+	// MkfsOptions, FsckPolicy, FsckFallback, Discard, Shared, Retention
+	// and IOLimits are the attributes of the spec of the volume, which
+	// it is recovered with
+	MkfsOptions  string         `json:"mkfsOptions,omitempty"`
+	FsckPolicy   string         `json:"fsckPolicy,omitempty"`
+	FsckFallback string         `json:"fsckFallback,omitempty"`
+	Discard      string         `json:"discard,omitempty"`
+	Shared       string         `json:"shared,omitempty"`
+	Retention    string         `json:"retention,omitempty"`
+	IOLimits     *apis.IOLimits `json:"ioLimits,omitempty"`
+
+	// CreationTime is the time the partition was created
+	CreationTime time.Time `json:"creationTime"`
+
+	// PurgeTime is the time the partition of a Released volume is
+	// purged from the recycle bin, it is only set for such partitions
+	PurgeTime *time.Time `json:"purgeTime,omitempty"`
+}
+
+// Intent records an operation on a partition which is in flight.
+type Intent struct {
+	// Op is the operation, IntentCreate or IntentDelete
+	Op string `json:"op"`
+
+	// Partition is the partition created or deleted
+	Partition PartitionRecord `json:"partition"`
+
+	// Time is the time the operation started
+	Time time.Time `json:"time"`
+}
+
+// getIntent returns the intent on the partition, nil if there's none
+func (j *Journal) getIntent(name string) *Intent {
+	for i := range j.Intents {
+		if j.Intents[i].Partition.Name == name {
+			return &j.Intents[i]
+		}
+	}
+	return nil
+}
+
+// setIntent records the operation on the partition, replacing
+// any previous intent on the same partition
+func (j *Journal) setIntent(op string, rec PartitionRecord) {
+	j.clearIntent(rec.Name)
+	j.Intents = append(j.Intents, Intent{Op: op, Partition: rec, Time: time.Now().UTC()})
+}
+
+// clearIntent removes the intent on the partition
+func (j *Journal) clearIntent(name string) {
+	var intents []Intent
+	for _, intent := range j.Intents {
+		if intent.Partition.Name != name {
+			intents = append(intents, intent)
+		}
+	}
+	j.Intents = intents
+}
+
+// getPartition returns the record of the partition, nil if there's none
+func (j *Journal) getPartition(name string) *PartitionRecord {
+	for i := range j.Partitions {
+		if j.Partitions[i].Name == name {
+			return &j.Partitions[i]
+		}
+	}
+	return nil
+}
+
+// putPartition adds or replaces the record of the partition
+func (j *Journal) putPartition(rec PartitionRecord) {
+	j.removePartition(rec.Name)
+	j.Partitions = append(j.Partitions, rec)
+}
+
+// removePartition removes the record of the partition
+func (j *Journal) removePartition(name string) {
+	var partitions []PartitionRecord
+	for _, rec := range j.Partitions {
+		if rec.Name != name {
+			partitions = append(partitions, rec)
+		}
+	}
+	j.Partitions = partitions
+}
+
+// encodeJournalSlot returns the content of a slot holding the journal
+func encodeJournalSlot(j *Journal, seq uint64) ([]byte, error) {
+	payload, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > journalSlotSize-journalHeaderSize {
+		return nil, fmt.Errorf("journal of %d bytes exceeds the slot size", len(payload))
+	}
+	buf := make([]byte, journalHeaderSize, journalHeaderSize+len(payload))
+	copy(buf, journalMagic)
+	binary.LittleEndian.PutUint32(buf[8:], journalVersion)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(buf[16:], seq)
+	binary.LittleEndian.PutUint32(buf[24:], crc32.Checksum(payload, crc32cTable))
+	return append(buf, payload...), nil
+}
+
+// decodeJournalSlot returns the journal held by a slot and its sequence
+// number. errNoJournal is returned for a slot which was never written.
+func decodeJournalSlot(buf []byte) (*Journal, uint64, error) {
+	if len(buf) < journalHeaderSize || !bytes.Equal(buf[:8], []byte(journalMagic)) {
+		return nil, 0, errNoJournal
+	}
+	if version := binary.LittleEndian.Uint32(buf[8:]); version != journalVersion {
+		return nil, 0, fmt.Errorf("unsupported journal version %d", version)
+	}
+	length := binary.LittleEndian.Uint32(buf[12:])
+	if int(length) > len(buf)-journalHeaderSize {
+		return nil, 0, fmt.Errorf("invalid journal length %d", length)
+	}
+	payload := buf[journalHeaderSize : journalHeaderSize+int(length)]
+	if crc := binary.LittleEndian.Uint32(buf[24:]); crc != crc32.Checksum(payload, crc32cTable) {
+		return nil, 0, errors.New("journal checksum mismatch")
+	}
+	j := &Journal{}
+	if err := json.Unmarshal(payload, j); err != nil {
+		return nil, 0, err
+	}
+	return j, binary.LittleEndian.Uint64(buf[16:]), nil
+}
+
+// readJournal returns the current journal, the valid slot with the highest
+// sequence number. An empty journal is returned when no slot was written,
+// and an error when no written slot is valid.
+func readJournal(r io.ReaderAt) (*Journal, uint64, error) {
+	var current *Journal
+	var currentSeq uint64
+	var lastErr error
+	buf := make([]byte, journalSlotSize)
+	for slot := int64(0); slot < journalSlots; slot++ {
+		if _, err := r.ReadAt(buf, slot*journalSlotSize); err != nil {
+			return nil, 0, err
+		}
+		j, seq, err := decodeJournalSlot(buf)
+		if err == errNoJournal {
+			continue
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("slot %d: %v", slot, err)
+			continue
+		}
+		if current == nil || seq > currentSeq {
+			current, currentSeq = j, seq
+		}
+	}
+	if current == nil && lastErr != nil {
+		return nil, 0, lastErr
+	}
+	if current == nil {
+		return &Journal{}, 0, nil
+	}
+	return current, currentSeq, nil
+}
+
+// writeJournal writes the journal in the slot of the sequence number,
+// the other slot keeps the previous journal.
+func writeJournal(w io.WriterAt, j *Journal, seq uint64) error {
+	buf, err := encodeJournalSlot(j, seq)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteAt(buf, int64(seq%journalSlots)*journalSlotSize)
+	return err
+}
+
+// openJournal opens the meta partition of the disk holding the journal.
+// It returns nil for the meta partitions too small to hold one.
+func openJournal(disk string, flag int) (*os.File, error) {
+	path := getPartitionPath(disk, metaPartitionNumber)
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if size < journalSlots*journalSlotSize {
+		klog.V(4).Infof("meta partition %s of %d bytes is too small for the journal", path, size)
+		f.Close()
+		return nil, nil
+	}
+	return f, nil
+}
+
+// ReadJournal returns the journal of the disk, nil if the meta partition
+// of the disk can't hold one.
+func ReadJournal(disk string) (*Journal, error) {
+	f, err := openJournal(disk, os.O_RDONLY)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	defer f.Close()
+	j, _, err := readJournal(f)
+	return j, err
+}
+
+// updateJournal applies the update to the journal of the disk and writes
+// it back. The disk is claimed by this driver instance if it wasn't yet,
+// the journals of the disks of other instances are left alone.
+func updateJournal(disk string, update func(j *Journal)) error {
+	journalMtx.Lock()
+	defer journalMtx.Unlock()
+
+	f, err := openJournal(disk, os.O_RDWR|os.O_SYNC)
+	if err != nil || f == nil {
+		return err
+	}
+	defer f.Close()
+	defer invalidateDiskOwner(disk)
+	return updateJournalFile(f, disk, update)
+}
+
+// updateJournalFile applies the update to the journal held by the file,
+// claiming the disk for this driver instance if it has no owner yet.
+func updateJournalFile(f *os.File, disk string, update func(j *Journal)) error {
+	j, seq, err := readJournal(f)
+	if err != nil {
+		return fmt.Errorf("journal of disk %s is corrupted: %v", disk, err)
+	}
+	if !ownsJournal(j) {
+		return &foreignDiskError{disk: disk, owner: j.InstanceID}
+	}
+	if j.InstanceID == "" {
+		j.InstanceID = DeviceConfiguration.InstanceID
+	}
+	update(j)
+	return writeJournal(f, j, seq+1)
+}
+
+// newPartitionRecord returns the record of the partition of the volume
+func newPartitionRecord(vol *apis.DeviceVolume, name string, capacity uint64) PartitionRecord {
+	rec := PartitionRecord{
+		Name:         name,
+		PVName:       vol.Name,
+		PVCNamespace: vol.Annotations[PVCNamespaceKey],
+		PVCName:      vol.Annotations[PVCNameKey],
+		Capacity:     capacity,
+		FsType:       vol.Spec.FsType,
+		CreationTime: time.Now().UTC(),
+	}
+	rec.setVolumeSpec(&vol.Spec)
+	return rec
+}
+
+// setVolumeSpec records the attributes of the volume spec, which may be
+// modified once the volume is created. It returns true if any changed.
+func (rec *PartitionRecord) setVolumeSpec(spec *apis.VolumeInfo) bool {
+	old := *rec
+	rec.VolumeMode = spec.VolumeMode
+	rec.MkfsOptions = spec.MkfsOptions
+	rec.FsckPolicy = spec.FsckPolicy
+	rec.FsckFallback = spec.FsckFallback
+	rec.Discard = spec.Discard
+	rec.Shared = spec.Shared
+	rec.Retention = spec.Retention
+	rec.IOLimits = nil
+	if spec.IOLimits != nil {
+		limits := *spec.IOLimits
+		rec.IOLimits = &limits
+	}
+	return !reflect.DeepEqual(old, *rec)
+}
+
+// ReplayJournals completes the operations left in flight on the disks, e.g.
+// by a crash of the agent. A partition being created, whose volume never
+// became Ready, is wiped, and a partition being deleted is deleted.
+func ReplayJournals() error {
+	diskList, err := getDiskList()
+	if err != nil {
+		return err
+	}
+	for _, disk := range diskList {
+		rows, err := GetPartitionList(disk.DiskPath, "", false)
+		if IsForeignDiskError(err) {
+			klog.Warningf("%v, its partitions are left alone", err)
+			continue
+		}
+		if err != nil || len(rows) == 0 {
+			continue
+		}
+		if _, ok := getMetaPartition(rows[0]); !ok {
+			continue
+		}
+		j, err := ReadJournal(disk.DiskPath)
+		if err != nil {
+			klog.Errorf("could not read the journal of disk %s: %v", disk.DiskPath, err)
+			continue
+		}
+		if j == nil {
+			continue
+		}
+		for _, intent := range j.Intents {
+			if err = replayIntent(disk.DiskPath, rows, intent); err != nil {
+				klog.Errorf("could not replay the %s of partition %s on disk %s: %v",
+					intent.Op, intent.Partition.Name, disk.DiskPath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// replayIntent completes the operation on the partition of the disk
+func replayIntent(disk string, rows []partedOutput, intent Intent) error {
+	name := intent.Partition.Name
+	var part *partedOutput
+	for i := 1; i < len(rows); i++ {
+		if rows[i].partName == name {
+			part = &rows[i]
+			break
+		}
+	}
+	klog.Infof("replaying the %s of partition %s on disk %s", intent.Op, name, disk)
+
+	switch intent.Op {
+	case IntentCreate:
+		if part == nil {
+			return updateJournal(disk, func(j *Journal) { j.clearIntent(name) })
+		}
+		if err := wipeFsPartition(disk, part.partNum); err != nil {
+			return err
+		}
+		return updateJournal(disk, func(j *Journal) {
+			j.clearIntent(name)
+			j.putPartition(intent.Partition)
+		})
+	case IntentDelete:
+		if part != nil {
+			if err := wipeFSAndDeletePart(disk, part.partNum); err != nil {
+				return err
+			}
+		}
+		return updateJournal(disk, func(j *Journal) {
+			j.clearIntent(name)
+			j.removePartition(name)
+		})
+	}
+	return fmt.Errorf("unknown operation %q", intent.Op)
+}
@@ -0,0 +1,159 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testJournal() *Journal {
+	return &Journal{
+		InstanceID: "device.csi.openebs.io/3f1c5b8e-0a54-4c1e-9e43-7d1f5a8e2b60",
+		Partitions: []PartitionRecord{{
+			Name:         "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75",
+			PVName:       "pvc-5d8d56cb-e291-4dfd-81ac-fb664dd5ec75",
+			PVCNamespace: "default",
+			PVCName:      "data",
+			Capacity:     10737418240,
+			FsType:       "ext4",
+			CreationTime: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+}
+
+func Test_decodeJournalSlot(t *testing.T) {
+	valid, err := encodeJournalSlot(testJournal(), 7)
+	if err != nil {
+		t.Fatalf("encodeJournalSlot() error = %v", err)
+	}
+	corrupted := append([]byte{}, valid...)
+	corrupted[len(corrupted)-2] ^= 0xff
+	version := append([]byte{}, valid...)
+	version[8] = 2
+
+	tests := []struct {
+		name    string
+		buf     []byte
+		wantSeq uint64
+		wantErr bool
+		noJrnl  bool
+	}{
+		{name: "valid", buf: valid, wantSeq: 7},
+		{name: "blank", buf: make([]byte, journalHeaderSize), wantErr: true, noJrnl: true},
+		{name: "corrupted payload", buf: corrupted, wantErr: true},
+		{name: "unknown version", buf: version, wantErr: true},
+		{name: "truncated", buf: valid[:journalHeaderSize+4], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, seq, err := decodeJournalSlot(tt.buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeJournalSlot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (err == errNoJournal) != tt.noJrnl {
+				t.Errorf("decodeJournalSlot() error = %v, want errNoJournal %v", err, tt.noJrnl)
+			}
+			if err != nil {
+				return
+			}
+			if seq != tt.wantSeq {
+				t.Errorf("decodeJournalSlot() seq = %d, want %d", seq, tt.wantSeq)
+			}
+			if !reflect.DeepEqual(j, testJournal()) {
+				t.Errorf("decodeJournalSlot() = %+v, want %+v", j, testJournal())
+			}
+		})
+	}
+}
+
+func Test_readJournal(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Truncate(journalSlots * journalSlotSize); err != nil {
+		t.Fatal(err)
+	}
+
+	j, seq, err := readJournal(f)
+	if err != nil || seq != 0 || len(j.Partitions) != 0 {
+		t.Fatalf("readJournal() of a blank partition = %+v, %d, %v", j, seq, err)
+	}
+
+	// the second write goes to the other slot, the latest one is read
+	first := testJournal()
+	if err = writeJournal(f, first, 1); err != nil {
+		t.Fatal(err)
+	}
+	second := testJournal()
+	second.setIntent(IntentDelete, second.Partitions[0])
+	if err = writeJournal(f, second, 2); err != nil {
+		t.Fatal(err)
+	}
+	j, seq, err = readJournal(f)
+	if err != nil || seq != 2 || len(j.Intents) != 1 {
+		t.Errorf("readJournal() = %+v, %d, %v, want the journal with the intent", j, seq, err)
+	}
+
+	// a torn write of the latest slot falls back to the previous one
+	if _, err = f.WriteAt([]byte("torn"), journalHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	j, seq, err = readJournal(f)
+	if err != nil || seq != 1 || len(j.Intents) != 0 {
+		t.Errorf("readJournal() = %+v, %d, %v, want the previous journal", j, seq, err)
+	}
+
+	// no valid slot is an error
+	if _, err = f.WriteAt([]byte("torn"), journalSlotSize+journalHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = readJournal(f); err == nil {
+		t.Errorf("readJournal() of corrupted slots succeeded")
+	}
+}
+
+func TestJournal_intents(t *testing.T) {
+	j := testJournal()
+	rec := PartitionRecord{Name: "a", PVName: "pvc-a"}
+
+	j.setIntent(IntentCreate, rec)
+	j.setIntent(IntentDelete, rec)
+	if len(j.Intents) != 1 || j.getIntent("a").Op != IntentDelete {
+		t.Errorf("setIntent() intents = %+v, want the delete intent only", j.Intents)
+	}
+	j.clearIntent("a")
+	if j.getIntent("a") != nil {
+		t.Errorf("clearIntent() intents = %+v", j.Intents)
+	}
+
+	j.putPartition(rec)
+	rec.FsType = "xfs"
+	j.putPartition(rec)
+	if len(j.Partitions) != 2 || j.getPartition("a").FsType != "xfs" {
+		t.Errorf("putPartition() partitions = %+v", j.Partitions)
+	}
+	j.removePartition("a")
+	if len(j.Partitions) != 1 || j.getPartition("a") != nil {
+		t.Errorf("removePartition() partitions = %+v", j.Partitions)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// PartitionGUID prints the GPT partition GUID read from the partition table,
+// udev may not have probed a partition which was just created.
+const PartitionGUID = "sfdisk --part-uuid /dev/%s %d"
+
+// getPartitionLocation returns the location of the partition. The location
+// is returned without GUID if it can't be read.
+func getPartitionLocation(part PartUsed) *apis.PartitionLocation {
+	loc := &apis.PartitionLocation{
+		Disk:    getDiskStableName(part.DiskPath),
+		PartNum: part.PartNum,
+		Start:   part.Start,
+		End:     part.End,
+	}
+	out, err := RunCommand(strings.Split(fmt.Sprintf(PartitionGUID, part.DiskPath, part.PartNum), " "))
+	if err != nil {
+		klog.Warningf("could not read the GUID of partition %s: %v", part.DevicePath, err)
+		return loc
+	}
+	// udev names the links after the lower case GUIDs
+	loc.PartUUID = strings.ToLower(strings.TrimSpace(out))
+	return loc
+}
+
+// resolvePartitionLocation returns the path of the partition with the GUID
+// of the location, checking it still has the number of the location.
+func resolvePartitionLocation(partUUIDDir string, sysBlockDir string, loc *apis.PartitionLocation) (string, error) {
+	if loc.PartUUID == "" {
+		return "", errors.New("no partition GUID recorded")
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(partUUIDDir, loc.PartUUID))
+	if err != nil {
+		return "", err
+	}
+	_, partNum, isPart, err := getPartitionOf(sysBlockDir, filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if !isPart || partNum != loc.PartNum {
+		return "", fmt.Errorf("%s is not partition %d", path, loc.PartNum)
+	}
+	return path, nil
+}
+
+// RecordPartitionLocation records the location of the partition of a Ready
+// volume provisioned before the locations were recorded, so that the
+// partition is resolved without scanning the disks.
+func RecordPartitionLocation(vol *apis.DeviceVolume) error {
+	if !HasPartition(vol) || vol.Status.Partition != nil {
+		return nil
+	}
+	pList, err := getAllPartsUsed(vol.Spec.DevName, GetVolumePartitionName(vol))
+	if err != nil {
+		return err
+	}
+	if len(pList) != 1 {
+		return fmt.Errorf("found %d partitions of volume %s", len(pList), vol.Name)
+	}
+	vol.Status.Partition = getPartitionLocation(pList[0])
+	_, err = volbuilder.NewKubeclient().WithNamespace(DeviceNamespace).Update(vol)
+	return err
+}
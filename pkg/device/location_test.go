@@ -0,0 +1,91 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func Test_resolvePartitionLocation(t *testing.T) {
+	dir := t.TempDir()
+	sysBlockDir := filepath.Join(dir, "class", "block")
+	diskDir := filepath.Join(dir, "devices", "sdc")
+	devDir := filepath.Join(dir, "dev")
+	partUUIDDir := filepath.Join(devDir, "disk", "by-partuuid")
+	for _, d := range []string{sysBlockDir, filepath.Join(diskDir, "sdc2"), partUUIDDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(diskDir, "sdc2", "partition"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(devDir, "sdc2"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		filepath.Join(sysBlockDir, "sdc2"):                                 filepath.Join(diskDir, "sdc2"),
+		filepath.Join(partUUIDDir, "8f3b0f4e-6a5c-4d2b-9a53-0c5e8a1d2f70"): filepath.Join(devDir, "sdc2"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		loc      apis.PartitionLocation
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "recorded partition",
+			loc:      apis.PartitionLocation{Disk: "sdc", PartNum: 2, PartUUID: "8f3b0f4e-6a5c-4d2b-9a53-0c5e8a1d2f70"},
+			wantPath: filepath.Join(devDir, "sdc2"),
+		},
+		{
+			name:    "renumbered partition",
+			loc:     apis.PartitionLocation{Disk: "sdc", PartNum: 3, PartUUID: "8f3b0f4e-6a5c-4d2b-9a53-0c5e8a1d2f70"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown guid",
+			loc:     apis.PartitionLocation{Disk: "sdc", PartNum: 2, PartUUID: "0c5e8a1d-6a5c-4d2b-9a53-8f3b0f4e2f70"},
+			wantErr: true,
+		},
+		{
+			name:    "no guid",
+			loc:     apis.PartitionLocation{Disk: "sdc", PartNum: 2},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := resolvePartitionLocation(partUUIDDir, sysBlockDir, &tt.loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolvePartitionLocation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if path != tt.wantPath {
+				t.Errorf("resolvePartitionLocation() = %v, want %v", path, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	mnt "github.com/openebs/lib-csi/pkg/mount"
+	"k8s.io/klog/v2"
+)
+
+// sfdisk commands dumping and loading the partition table of a disk, the
+// table is loaded as is, without wiping any signature of the disk
+const (
+	PartitionTableDump = "sfdisk --dump /dev/%s"
+	PartitionTableLoad = "sfdisk --wipe never --wipe-partitions never /dev/%s"
+)
+
+// linuxFilesystemType is the GPT type of the partitions created by parted
+const linuxFilesystemType = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+
+var (
+	sfdiskPartitionRegex = regexp.MustCompile(`^(\S*?)(\d+) : (.*)$`)
+	sfdiskStartRegex     = regexp.MustCompile(`start=\s*(\d+)`)
+	sfdiskSizeRegex      = regexp.MustCompile(`size=\s*(\d+)`)
+)
+
+// sfdiskPartition is a partition of a sfdisk dump
+type sfdiskPartition struct {
+	num   uint32
+	start uint64
+	size  uint64
+	// attrs are the fields of the partition, e.g. its uuid and name,
+	// which are kept when the partition is renumbered
+	attrs string
+}
+
+// sfdiskTable is the partition table of a disk as dumped by sfdisk, with
+// the sizes in sectors
+type sfdiskTable struct {
+	header     []string
+	label      string
+	sectorSize uint64
+	firstLBA   uint64
+	lastLBA    uint64
+	partitions []sfdiskPartition
+}
+
+// parseSfdiskDump parses the output of sfdisk --dump
+func parseSfdiskDump(out string) (*sfdiskTable, error) {
+	table := &sfdiskTable{sectorSize: 512}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m := sfdiskPartitionRegex.FindStringSubmatch(line); m != nil {
+			num, err := strconv.ParseUint(m[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid partition %q: %v", line, err)
+			}
+			start := sfdiskStartRegex.FindStringSubmatch(m[3])
+			size := sfdiskSizeRegex.FindStringSubmatch(m[3])
+			if start == nil || size == nil {
+				return nil, fmt.Errorf("partition %q has no start or size", line)
+			}
+			part := sfdiskPartition{num: uint32(num), attrs: m[3]}
+			part.start, _ = strconv.ParseUint(start[1], 10, 64)
+			part.size, _ = strconv.ParseUint(size[1], 10, 64)
+			table.partitions = append(table.partitions, part)
+			continue
+		}
+		table.header = append(table.header, line)
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "label":
+			table.label = value
+		case "sector-size":
+			table.sectorSize, err = strconv.ParseUint(value, 10, 64)
+		case "first-lba":
+			table.firstLBA, err = strconv.ParseUint(value, 10, 64)
+		case "last-lba":
+			table.lastLBA, err = strconv.ParseUint(value, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", key, value, err)
+		}
+	}
+	if table.label != partitionTypeGPT {
+		return nil, fmt.Errorf("partition table %q is not gpt", table.label)
+	}
+	if table.sectorSize == 0 || table.lastLBA <= table.firstLBA {
+		return nil, fmt.Errorf("invalid partition table geometry")
+	}
+	return table, nil
+}
+
+// findMetaSlot returns the first sector of the free space holding a meta
+// partition of the given number of sectors. A start aligned on 1MiB is
+// preferred, like the partitions created by parted.
+func (t *sfdiskTable) findMetaSlot(sectors uint64) (uint64, bool) {
+	parts := append([]sfdiskPartition(nil), t.partitions...)
+	sort.Slice(parts, func(i, j int) bool { return parts[i].start < parts[j].start })
+	for _, alignBytes := range []uint64{1024 * 1024, 4096} {
+		align := alignBytes / t.sectorSize
+		if align == 0 {
+			align = 1
+		}
+		begin := t.firstLBA
+		for i := 0; i <= len(parts); i++ {
+			end := t.lastLBA + 1
+			if i < len(parts) {
+				end = parts[i].start
+			}
+			start := (begin + align - 1) / align * align
+			if start+sectors <= end {
+				return start, true
+			}
+			if i < len(parts) && parts[i].start+parts[i].size > begin {
+				begin = parts[i].start + parts[i].size
+			}
+		}
+	}
+	return 0, false
+}
+
+// PartitionRenumbering is a partition of a disk getting a new number, and
+// so a new device path, when the disk is managed
+type PartitionRenumbering struct {
+	From string
+	To   string
+}
+
+// planManagedTable returns the sfdisk script of the partition table of the
+// disk with a meta partition named devName added as partition 1, in the
+// free space of the disk. The partition already numbered 1 gets the first
+// free number, the partitions keep their location, uuid and name. It also
+// returns the partitions which get a new number.
+func planManagedTable(dump string, disk string, devName string) (string, []PartitionRenumbering, error) {
+	table, err := parseSfdiskDump(dump)
+	if err != nil {
+		return "", nil, err
+	}
+	sectors := uint64(journalSlots*journalSlotSize) / table.sectorSize
+	start, ok := table.findMetaSlot(sectors)
+	if !ok {
+		return "", nil, fmt.Errorf("disk %s has no free space of %d bytes for the meta partition",
+			disk, journalSlots*journalSlotSize)
+	}
+
+	used := map[uint32]bool{}
+	for _, part := range table.partitions {
+		used[part.num] = true
+	}
+	parts := append([]sfdiskPartition(nil), table.partitions...)
+	var renumbered []PartitionRenumbering
+	for i := range parts {
+		if parts[i].num != metaPartitionNumber {
+			continue
+		}
+		num := uint32(metaPartitionNumber + 1)
+		for used[num] {
+			num++
+		}
+		if num > gptMaxPartitions {
+			return "", nil, fmt.Errorf("disk %s has no free partition entry", disk)
+		}
+		klog.Infof("partition %d of disk %s becomes partition %d", metaPartitionNumber, disk, num)
+		parts[i].num = num
+		renumbered = append(renumbered, PartitionRenumbering{
+			From: getPartitionPath(disk, metaPartitionNumber),
+			To:   getPartitionPath(disk, num),
+		})
+	}
+	parts = append(parts, sfdiskPartition{
+		num:   metaPartitionNumber,
+		start: start,
+		size:  sectors,
+		attrs: fmt.Sprintf("start=%d, size=%d, type=%s, name=\"%s\"", start, sectors, linuxFilesystemType, devName),
+	})
+	sort.Slice(parts, func(i, j int) bool { return parts[i].num < parts[j].num })
+
+	lines := append([]string(nil), table.header...)
+	lines = append(lines, "")
+	for _, part := range parts {
+		lines = append(lines, fmt.Sprintf("%s : %s", getPartitionPath(disk, part.num), part.attrs))
+	}
+	return strings.Join(lines, "\n") + "\n", renumbered, nil
+}
+
+// checkDiskUnused makes sure that no partition of the disk is mounted or
+// held by another device, e.g. a device mapper, as the kernel can't reload
+// the partitions of the disk otherwise.
+func checkDiskUnused(disk string, table *sfdiskTable) error {
+	for _, part := range table.partitions {
+		devicePath := getPartitionPath(disk, part.num)
+		mounts, err := mnt.GetMounts(devicePath)
+		if err != nil {
+			return err
+		}
+		if len(mounts) > 0 {
+			return fmt.Errorf("partition %s is mounted at %v", devicePath, mounts)
+		}
+		holders, err := os.ReadDir(filepath.Join(sysfsBlockDir, filepath.Base(devicePath), "holders"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(holders) > 0 {
+			return fmt.Errorf("partition %s is held by %s", devicePath, holders[0].Name())
+		}
+	}
+	return nil
+}
+
+// ManageDisk brings a disk partitioned by hand under the management of the
+// driver, without moving any data: a meta partition named devName is added
+// as partition 1 in the free space of the disk, and the journal of the disk
+// is claimed by this driver instance. Only the partition table is written.
+// The partitions of the disk can then be imported as volumes. The partition
+// numbered 1 is only renumbered with renumber, as the references to its
+// device path break. It returns the partition table which is, or with dryRun
+// would be, loaded, and the partitions renumbered.
+func ManageDisk(disk string, devName string, renumber bool,
+	dryRun bool) (string, []PartitionRenumbering, error) {
+	if !metaNameRegex.MatchString(devName) || len(devName) > maxPartitionNameLen {
+		return "", nil, fmt.Errorf("invalid device name %q", devName)
+	}
+	if metaName, err := getDiskMetaName(disk); err == nil {
+		if metaName != devName {
+			return "", nil, fmt.Errorf("disk %s is already managed with device name %s", disk, metaName)
+		}
+		// the table was loaded by a previous attempt
+		klog.Infof("disk %s already has the meta partition %s", disk, devName)
+		if dryRun {
+			return "", nil, nil
+		}
+		return "", nil, updateJournal(disk, func(j *Journal) {})
+	}
+
+	dump, err := RunCommand(strings.Split(fmt.Sprintf(PartitionTableDump, disk), " "))
+	if err != nil {
+		return "", nil, err
+	}
+	table, err := parseSfdiskDump(dump)
+	if err != nil {
+		return "", nil, fmt.Errorf("disk %s: %v", disk, err)
+	}
+	if err = checkDiskUnused(disk, table); err != nil {
+		return "", nil, err
+	}
+	script, renumbered, err := planManagedTable(dump, disk, devName)
+	if err != nil || dryRun {
+		return script, renumbered, err
+	}
+	if len(renumbered) > 0 && !renumber {
+		return script, renumbered, fmt.Errorf("partition %s of disk %s would become %s, "+
+			"renumbering it must be allowed", renumbered[0].From, disk, renumbered[0].To)
+	}
+
+	klog.Infof("loading the partition table of disk %s:\n%s", disk, script)
+	args := strings.Split(fmt.Sprintf(PartitionTableLoad, disk), " ")
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(script)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return script, renumbered, fmt.Errorf("could not load the partition table of disk %s: %v, output: %s",
+			disk, err, strings.TrimSpace(string(out)))
+	}
+	if err = clearJournal(disk); err != nil {
+		return script, renumbered, err
+	}
+	return script, renumbered, updateJournal(disk, func(j *Journal) {})
+}
+
+// clearJournal zeroes the journal slots of the new meta partition of the
+// disk, which may hold stale data of the free space it was created in.
+func clearJournal(disk string) error {
+	journalMtx.Lock()
+	defer journalMtx.Unlock()
+
+	f, err := openJournal(disk, os.O_RDWR|os.O_SYNC)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return fmt.Errorf("meta partition of disk %s is too small for the journal", disk)
+	}
+	defer f.Close()
+	defer invalidateDiskOwner(disk)
+	_, err = f.WriteAt(make([]byte, journalSlots*journalSlotSize), 0)
+	return err
+}
@@ -0,0 +1,91 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+const sfdiskHeader = `label: gpt
+label-id: 5B9A1E6C-3C2F-4B7E-9D0A-1F2E3D4C5B6A
+device: /dev/sdb
+unit: sectors
+first-lba: 34
+last-lba: 41943006
+sector-size: 512
+`
+
+func Test_planManagedTable(t *testing.T) {
+	tests := map[string]struct {
+		dump           string
+		want           string
+		wantRenumbered []PartitionRenumbering
+		wantErr        bool
+	}{
+		"data partition renumbered, meta partition at the end": {
+			dump: sfdiskHeader + `
+/dev/sdb1 : start=        2048, size=    40960000, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, uuid=6F3B2C1A-7E1D-4D6B-9C1E-2B8F0A5D4E3C, name="data"
+`,
+			want: sfdiskHeader + `
+/dev/sdb1 : start=40962048, size=2048, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, name="test-device"
+/dev/sdb2 : start=        2048, size=    40960000, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, uuid=6F3B2C1A-7E1D-4D6B-9C1E-2B8F0A5D4E3C, name="data"
+`,
+			wantRenumbered: []PartitionRenumbering{{From: "/dev/sdb1", To: "/dev/sdb2"}},
+		},
+		"free first entry, meta partition in a gap": {
+			dump: sfdiskHeader + `
+/dev/sdb2 : start=        2048, size=     2048000, uuid=A1B2C3D4-0000-4000-8000-000000000001, name="logs"
+/dev/sdb3 : start=     2052096, size=    39890911, uuid=A1B2C3D4-0000-4000-8000-000000000002, name="data"
+`,
+			want: sfdiskHeader + `
+/dev/sdb1 : start=2050048, size=2048, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, name="test-device"
+/dev/sdb2 : start=        2048, size=     2048000, uuid=A1B2C3D4-0000-4000-8000-000000000001, name="logs"
+/dev/sdb3 : start=     2052096, size=    39890911, uuid=A1B2C3D4-0000-4000-8000-000000000002, name="data"
+`,
+		},
+		"no free space": {
+			dump: sfdiskHeader + `
+/dev/sdb1 : start=        2048, size=    41940959, name="data"
+`,
+			wantErr: true,
+		},
+		"dos partition table": {
+			dump: `label: dos
+device: /dev/sdb
+unit: sectors
+
+/dev/sdb1 : start=        2048, size=    40960000, type=83
+`,
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, renumbered, err := planManagedTable(tt.dump, "sdb", "test-device")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planManagedTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("planManagedTable() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(renumbered, tt.wantRenumbered) {
+				t.Errorf("planManagedTable() renumbered = %v, want %v", renumbered, tt.wantRenumbered)
+			}
+		})
+	}
+}
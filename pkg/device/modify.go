@@ -0,0 +1,140 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+
+	mnt "github.com/openebs/lib-csi/pkg/mount"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+// ReconcileVolume applies the modifiable attributes of the volume spec to
+// the mounts of the volume on this node: the discard mount option and the
+// IO limits of the pods consuming the volume. The fsck policy and fallback
+// are read at the next mount, and the periodic discard at the next trim.
+// The journal record of the partition of the volume is kept up to date too.
+func ReconcileVolume(vol *apis.DeviceVolume) error {
+	var errs []error
+	if err := recordVolumeSpec(vol); err != nil {
+		errs = append(errs, fmt.Errorf("could not record the spec in the journal: %v", err))
+	}
+	if err := reconcileDiscard(vol); err != nil {
+		errs = append(errs, err)
+	}
+	for _, path := range getPublishPaths(vol) {
+		err := setPodIOLimits(vol, path, vol.Spec.IOLimits)
+		if err == nil {
+			continue
+		}
+		// removing limits which were never set is best effort, the
+		// nodes without cgroup v2 don't have any limit to remove
+		if vol.Spec.IOLimits == nil {
+			klog.V(4).Infof("could not remove the IO limits of volume %s at %s: %v", vol.Name, path, err)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("could not set the IO limits at %s: %v", path, err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("reconcile of volume %s failed: %v", vol.Name, errs)
+	}
+	return nil
+}
+
+// recordVolumeSpec updates the attributes of the volume spec in the journal
+// record of its partition, if they changed, e.g. once the volume is modified
+// or for the records written before the attributes were recorded.
+func recordVolumeSpec(vol *apis.DeviceVolume) error {
+	if !HasPartition(vol) {
+		return nil
+	}
+	pList, err := getAllPartsUsed(vol.Spec.DevName, GetVolumePartitionName(vol))
+	if err != nil || len(pList) != 1 {
+		return err
+	}
+	part := pList[0]
+	j, err := ReadJournal(part.DiskPath)
+	if err != nil || j == nil {
+		return err
+	}
+	rec := j.getPartition(part.Name)
+	if rec == nil {
+		return nil
+	}
+	// the journal is only written when the spec changed
+	if updated := *rec; !updated.setVolumeSpec(&vol.Spec) {
+		return nil
+	}
+	klog.Infof("recording the modified spec of volume %s in the journal of disk %s", vol.Name, part.DiskPath)
+	return updateJournal(part.DiskPath, func(j *Journal) {
+		if rec := j.getPartition(part.Name); rec != nil {
+			rec.setVolumeSpec(&vol.Spec)
+		}
+	})
+}
+
+// reconcileDiscard remounts the mounted filesystem of the volume with or
+// without the discard option, as per the discard mode of the volume. The
+// option belongs to the superblock, which is shared by all the mounts. The
+// block volumes are not listed in the mounts of the device, the pods
+// consuming them discard the blocks themselves.
+func reconcileDiscard(vol *apis.DeviceVolume) error {
+	devicePath, err := GetVolumeDevPath(vol)
+	if err != nil {
+		return err
+	}
+	mounts, err := mnt.GetMounts(devicePath)
+	if err != nil || len(mounts) == 0 {
+		return err
+	}
+
+	discard, err := hasMountOption(procMountInfo, mounts[0], "discard")
+	if err != nil {
+		return err
+	}
+	want := vol.Spec.Discard == DiscardMount
+	if discard == want {
+		return nil
+	}
+
+	option := "remount,discard"
+	if !want {
+		option = "remount,nodiscard"
+	}
+	klog.Infof("remounting volume %s at %s with %s", vol.Name, mounts[0], option)
+	_, err = RunCommand([]string{"mount", "-o", option, mounts[0]})
+	return err
+}
+
+// hasMountOption checks if the mount at the path, or its superblock,
+// has the given option
+func hasMountOption(mountInfoPath string, path string, option string) (bool, error) {
+	infos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		if info.MountPoint == path {
+			return hasOption(info.MountOptions, option) ||
+				hasOption(info.SuperOptions, option), nil
+		}
+	}
+	return false, fmt.Errorf("%s is not mounted", path)
+}
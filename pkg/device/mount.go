@@ -18,7 +18,9 @@ package device
 import (
 	"fmt"
 	"os"
+	"sort"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	mnt "github.com/openebs/lib-csi/pkg/mount"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -48,12 +50,42 @@ type MountInfo struct {
 	// MountOptions specifies the options with
 	// which mount needs to be attempted
 	MountOptions []string `json:"mountOptions"`
+
+	// VolumeMountGroup is the group id which is given
+	// access to the volume, it is set by kubelet when
+	// the pod has a fsGroup
+	VolumeMountGroup string `json:"volumeMountGroup"`
+}
+
+// isReadOnly checks if the mount options make a read only mount
+func isReadOnly(options []string) bool {
+	return hasOption(options, "ro")
 }
 
-// FormatAndMountVol formats and mounts the created volume to the desired mount path
-func FormatAndMountVol(devicePath string, mountInfo *MountInfo) error {
+// FormatAndMountVol formats and mounts the created volume to the desired mount path,
+// the mkfs options of the volume are passed to mkfs when it is not yet formatted
+// and the filesystem is checked as per the fsck policy of the volume
+func FormatAndMountVol(vol *apis.DeviceVolume, devicePath string, mountInfo *MountInfo) error {
 	mounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: utilexec.New()}
 
+	if len(mountInfo.FSType) == 0 {
+		mountInfo.FSType = DefaultFsType
+	}
+	if err := formatDevice(mounter, devicePath, mountInfo, vol.Spec.MkfsOptions); err != nil {
+		klog.Errorf("device: failed to format volume %s [%s], error %v",
+			devicePath, mountInfo.FSType, err)
+		return err
+	}
+
+	if len(vol.Spec.FsckPolicy) != 0 {
+		err := mountWithFsckPolicy(mounter, vol, devicePath, mountInfo)
+		if err != nil {
+			klog.Errorf("device: failed to mount volume %s [%s] to %s, error %v",
+				devicePath, mountInfo.FSType, mountInfo.MountPath, err)
+		}
+		return err
+	}
+
 	err := mounter.FormatAndMount(devicePath, mountInfo.MountPath, mountInfo.FSType, mountInfo.MountOptions)
 	if err != nil {
 		klog.Errorf(
@@ -116,7 +148,24 @@ func UmountVolume(vol *apis.DeviceVolume, targetPath string,
 	return nil
 }
 
-func verifyMountRequest(vol *apis.DeviceVolume, mountpath string) (bool, error) {
+// CleanupMountPoint unmounts the path, if it is mounted, and removes it. It
+// is used once the DeviceVolume is gone, e.g. force deleted, so that the
+// mounts of the volume don't leak.
+func CleanupMountPoint(path string) error {
+	if err := mount.CleanupMountPoint(path, mount.New(""), false); err != nil {
+		klog.Errorf("device: failed to clean up mount point %s: %v", path, err)
+		return err
+	}
+	return nil
+}
+
+// verifyMountRequest checks that the volume can be mounted at the mount path
+// and returns true if it is already mounted there. The access modes of the
+// request and of the existing publish targets must all allow the volume to be
+// shared, the staging mount of the volume doesn't count as a publish. The
+// publish targets of the block volumes are their publication records.
+func verifyMountRequest(vol *apis.DeviceVolume, mountInfo *MountInfo, stagingPath string, block bool) (bool, error) {
+	mountpath := mountInfo.MountPath
 	if len(mountpath) == 0 {
 		return false, status.Error(codes.InvalidArgument, "verifyMount: mount path missing in request")
 	}
@@ -138,31 +187,114 @@ func verifyMountRequest(vol *apis.DeviceVolume, mountpath string) (bool, error)
 
 	/*
 	 * This check is the famous *Wall Of North*
-	 * It will not let the volume to be mounted
-	 * at more than two places. The volume should
-	 * be unmounted before proceeding to the mount
-	 * operation.
+	 * It will not let the volume to be published
+	 * at more than one place, unless the access
+	 * mode of the request allows it. The volume
+	 * should be unpublished before proceeding to
+	 * the mount operation otherwise.
 	 */
-	currentMounts, err := mnt.GetMounts(devicePath)
-	if err != nil {
-		klog.Errorf("can not get mounts for volume:%s dev %s err: %v",
-			vol.Name, devicePath, err.Error())
-		return false, status.Errorf(codes.Internal, "verifyMount: Getmounts failed %s", err.Error())
-	} else if len(currentMounts) >= 1 {
-		// if device is already mounted at the mount point, return successful
+	var published []string
+	var pubs publications
+	if block && DeviceConfiguration.PublishDir != "" {
+		// the bind mounts of the device node don't show up as mounts
+		// of the device, the publish targets are the recorded ones
+		if pubs, err = getPublications(vol.Name); err != nil {
+			return false, status.Errorf(codes.Internal,
+				"verifyMount: could not read the publications of volume %s: %v", vol.Name, err)
+		}
+		for target := range pubs {
+			if target != mountpath {
+				published = append(published, target)
+				continue
+			}
+			// the device is already bind mounted at the target
+			if notMnt, err := mount.New("").IsLikelyNotMountPoint(mountpath); err == nil && !notMnt {
+				return true, nil
+			}
+		}
+		sort.Strings(published)
+	} else {
+		currentMounts, err := mnt.GetMounts(devicePath)
+		if err != nil {
+			klog.Errorf("can not get mounts for volume:%s dev %s err: %v",
+				vol.Name, devicePath, err.Error())
+			return false, status.Errorf(codes.Internal, "verifyMount: Getmounts failed %s", err.Error())
+		}
 		for _, mp := range currentMounts {
+			// if device is already mounted at the mount point, return successful
 			if mp == mountpath {
 				return true, nil
 			}
+			if mp != stagingPath {
+				published = append(published, mp)
+			}
+		}
+	}
+	// the staging mount is shared by all the publish targets
+	if len(published) == 0 || mountpath == stagingPath {
+		return false, nil
+	}
+	if !isSharedAccess(vol, mountInfo.AccessModes) {
+		return false, status.Errorf(codes.FailedPrecondition,
+			"verifyMount: volume %s is already mounted at %v", vol.Name, published)
+	}
+	if pubs == nil {
+		if pubs, err = getPublications(vol.Name); err != nil {
+			return false, status.Errorf(codes.Internal,
+				"verifyMount: could not read the publications of volume %s: %v", vol.Name, err)
 		}
 	}
+	if mp, ok := exclusivePublication(vol, pubs, published); ok {
+		return false, status.Errorf(codes.FailedPrecondition,
+			"verifyMount: volume %s is already published at %s with an exclusive access mode", vol.Name, mp)
+	}
 	return false, nil
 }
 
+// exclusivePublication returns the publish target, if any, whose access mode
+// doesn't let the volume be published anywhere else. The targets published
+// before their access modes were recorded are known to be writers only.
+func exclusivePublication(vol *apis.DeviceVolume, pubs publications, published []string) (string, bool) {
+	for _, mp := range published {
+		accessModes, ok := pubs[mp]
+		if !ok {
+			accessModes = []string{csi.VolumeCapability_AccessMode_UNKNOWN.String()}
+		}
+		if !isSharedAccess(vol, accessModes) {
+			return mp, true
+		}
+	}
+	return "", false
+}
+
+// isSharedAccess checks if the access modes allow the volume to be published
+// to several pods at the same time. Readers can always share the volume,
+// a single writer never and other writers only if the volume is shared.
+func isSharedAccess(vol *apis.DeviceVolume, accessModes []string) bool {
+	for _, mode := range accessModes {
+		switch mode {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY.String():
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER.String():
+			return false
+		default:
+			if vol.Spec.Shared != "yes" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // MountVolume mounts the disk to the specified path
 func MountVolume(vol *apis.DeviceVolume, mount *MountInfo) error {
+	return mountVolume(vol, mount, "")
+}
+
+// mountVolume formats and mounts the disk to the specified path, the
+// volume may be mounted on the staging path as well.
+func mountVolume(vol *apis.DeviceVolume, mount *MountInfo, stagingPath string) error {
 	volume := vol.Name
-	mounted, err := verifyMountRequest(vol, mount.MountPath)
+	mounted, err := verifyMountRequest(vol, mount, stagingPath, false)
 	if err != nil {
 		return err
 	}
@@ -177,13 +309,32 @@ func MountVolume(vol *apis.DeviceVolume, mount *MountInfo) error {
 		return status.Error(codes.Internal, "Not able to find the device Path")
 	}
 
-	err = FormatAndMountVol(devicePath, mount)
+	// the filesystem chosen at provisioning time sticks with the volume
+	if len(vol.Spec.FsType) != 0 {
+		if len(mount.FSType) != 0 && mount.FSType != vol.Spec.FsType {
+			klog.Warningf("device: volume %s has fs %s, ignoring requested fs %s",
+				volume, vol.Spec.FsType, mount.FSType)
+		}
+		mount.FSType = vol.Spec.FsType
+	}
+
+	if vol.Spec.Discard == DiscardMount && !isReadOnly(mount.MountOptions) {
+		mount.MountOptions = append(mount.MountOptions, "discard")
+	}
+
+	err = FormatAndMountVol(vol, devicePath, mount)
 	if err != nil {
-		return status.Error(codes.Internal, "not able to format and mount the volume")
+		return status.Errorf(codes.Internal, "not able to format and mount the volume: %v", err)
 	}
 
 	klog.Infof("device: volume %v mounted %v fs %v", volume, mount.MountPath, mount.FSType)
 
+	if len(mount.VolumeMountGroup) != 0 && !isReadOnly(mount.MountOptions) {
+		if err = applyVolumeMountGroup(mount.MountPath, mount.VolumeMountGroup); err != nil {
+			return status.Errorf(codes.Internal, "not able to set the volume mount group: %v", err)
+		}
+	}
+
 	return err
 }
 
@@ -196,9 +347,91 @@ func MountFilesystem(vol *apis.DeviceVolume, mount *MountInfo) error {
 	return MountVolume(vol, mount)
 }
 
+// StageVolume formats and mounts the disk on the staging path, whatever
+// the publish targets the volume is bind mounted on.
+func StageVolume(vol *apis.DeviceVolume, mount *MountInfo) error {
+	if err := os.MkdirAll(mount.MountPath, 0755); err != nil {
+		return status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", mount.MountPath, err)
+	}
+
+	return mountVolume(vol, mount, mount.MountPath)
+}
+
+// BindMountVolume bind mounts the volume staged at the staging path onto
+// the target path, so that the volume formatted and mounted once on the node
+// can be published to any number of pods.
+func BindMountVolume(vol *apis.DeviceVolume, stagingPath string, mountInfo *MountInfo) error {
+	target := mountInfo.MountPath
+	mounter := mount.New("")
+
+	mounted, err := verifyMountRequest(vol, mountInfo, stagingPath, false)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		klog.Infof("device : already mounted %s => %s", vol.Name, target)
+		return nil
+	}
+
+	notMnt, err := mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil || notMnt {
+		return status.Errorf(codes.FailedPrecondition,
+			"volume %s is not staged at %s", vol.Name, stagingPath)
+	}
+
+	if err = os.MkdirAll(target, 0755); err != nil {
+		return status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", target, err)
+	}
+	notMnt, err = mounter.IsLikelyNotMountPoint(target)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not check mount point %s: %v", target, err)
+	}
+	if !notMnt {
+		klog.Infof("device : already mounted %s => %s", vol.Name, target)
+		return nil
+	}
+
+	mountopt := getBindMountOptions(mountInfo.MountOptions)
+	if err = mounter.Mount(stagingPath, target, "", mountopt); err != nil {
+		return status.Errorf(codes.Internal, "bind mount failed at %v err : %v", target, err)
+	}
+	klog.Infof("device: volume %v bind mounted %v => %v", vol.Name, stagingPath, target)
+	return nil
+}
+
+// UnstageVolume unmounts the volume from the staging path. The volume must
+// not be published anymore, i.e. the device can't be mounted anywhere else.
+func UnstageVolume(vol *apis.DeviceVolume, stagingPath string) error {
+	devicePath, err := GetVolumeDevPath(vol)
+	if err == nil {
+		currentMounts, err := mnt.GetMounts(devicePath)
+		if err != nil {
+			return status.Errorf(codes.Internal, "unstage: Getmounts failed %s", err.Error())
+		}
+		for _, mp := range currentMounts {
+			if mp != stagingPath {
+				return status.Errorf(codes.FailedPrecondition,
+					"volume %s is still published at %s", vol.Name, mp)
+			}
+		}
+	} else {
+		klog.Warningf("can not get device for volume %s, unmounting the staging path: %v", vol.Name, err)
+	}
+	return UmountVolume(vol, stagingPath)
+}
+
 // MountBlock mounts the block disk to the specified path
 func MountBlock(vol *apis.DeviceVolume, mountinfo *MountInfo) error {
 	target := mountinfo.MountPath
+	mounted, err := verifyMountRequest(vol, mountinfo, "", true)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		klog.Infof("device : already mounted %s => %s", vol.Name, target)
+		return nil
+	}
+
 	devicePath, err := GetVolumeDevPath(vol)
 	if err != nil {
 		return status.Errorf(codes.Internal, "could not get device path for block mount for volume %s: %v", vol.Name, err)
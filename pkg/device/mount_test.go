@@ -0,0 +1,128 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func Test_isSharedAccess(t *testing.T) {
+	tests := map[string]struct {
+		shared      string
+		accessModes []string
+		want        bool
+	}{
+		"staging mount": {
+			accessModes: nil,
+			want:        true,
+		},
+		"multi writer, not shared": {
+			accessModes: []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER.String()},
+			want:        false,
+		},
+		"multi writer, shared": {
+			shared:      "yes",
+			accessModes: []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER.String()},
+			want:        true,
+		},
+		"single node writer, shared": {
+			shared:      "yes",
+			accessModes: []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER.String()},
+			want:        true,
+		},
+		"single writer, shared": {
+			shared:      "yes",
+			accessModes: []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER.String()},
+			want:        false,
+		},
+		"reader only, not shared": {
+			accessModes: []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY.String()},
+			want:        true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := &apis.DeviceVolume{Spec: apis.VolumeInfo{Shared: tt.shared}}
+			if got := isSharedAccess(vol, tt.accessModes); got != tt.want {
+				t.Errorf("isSharedAccess() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_exclusivePublication(t *testing.T) {
+	writer := []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER.String()}
+	singleWriter := []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER.String()}
+	reader := []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY.String()}
+	tests := map[string]struct {
+		shared    string
+		pubs      publications
+		published []string
+		want      string
+	}{
+		"readers": {
+			pubs:      publications{"/a": reader, "/b": reader},
+			published: []string{"/a", "/b"},
+		},
+		"single writer, shared": {
+			shared:    "yes",
+			pubs:      publications{"/a": reader, "/b": singleWriter},
+			published: []string{"/a", "/b"},
+			want:      "/b",
+		},
+		"writer, shared": {
+			shared:    "yes",
+			pubs:      publications{"/a": writer},
+			published: []string{"/a"},
+		},
+		"writer, not shared": {
+			pubs:      publications{"/a": writer},
+			published: []string{"/a"},
+			want:      "/a",
+		},
+		"unrecorded, shared": {
+			shared:    "yes",
+			published: []string{"/a"},
+		},
+		"unrecorded, not shared": {
+			published: []string{"/a"},
+			want:      "/a",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := &apis.DeviceVolume{Spec: apis.VolumeInfo{Shared: tt.shared}}
+			got, ok := exclusivePublication(vol, tt.pubs, tt.published)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("exclusivePublication() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getBindMountOptions(t *testing.T) {
+	options := []string{"ro", `context="system_u:object_r:container_file_t:s0:c1,c2"`, "noatime", "rootcontext=system_u:object_r:container_file_t:s0"}
+	want := []string{"bind", "ro", "noatime"}
+	if got := getBindMountOptions(options); !reflect.DeepEqual(got, want) {
+		t.Errorf("getBindMountOptions() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"k8s.io/klog/v2"
+)
+
+// selinuxMountOptions are the mount options setting the SELinux labels
+// of a filesystem. They apply to the superblock, i.e. to the first mount
+// of the device, and can't be changed by a bind mount.
+var selinuxMountOptions = []string{"context=", "fscontext=", "defcontext=", "rootcontext="}
+
+// isSELinuxMountOption checks if the mount option sets a SELinux label
+func isSELinuxMountOption(opt string) bool {
+	for _, prefix := range selinuxMountOptions {
+		if strings.HasPrefix(opt, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// getBindMountOptions drops the SELinux options from the options of a bind
+// mount, the bind mount gets the labels the volume was staged with.
+func getBindMountOptions(options []string) []string {
+	result := []string{"bind"}
+	for _, opt := range options {
+		if isSELinuxMountOption(opt) {
+			klog.V(4).Infof("device: dropping %s from the bind mount options", opt)
+			continue
+		}
+		result = append(result, opt)
+	}
+	return result
+}
+
+// applyVolumeMountGroup gives the group of the pods access to the volume
+// mounted at the mount path. None of the supported filesystems has a gid
+// mount option, so the group is set on the root directory of the volume
+// along with the setgid bit, so that the files created later inherit the
+// group. Unlike the recursive chown done by kubelet, this only touches the
+// root directory and is a no-op once the root directory is set up.
+func applyVolumeMountGroup(mountPath string, group string) error {
+	gid, err := strconv.Atoi(group)
+	if err != nil || gid < 0 {
+		return fmt.Errorf("invalid volume mount group %q", group)
+	}
+
+	info, err := os.Stat(mountPath)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not get the owner of %s", mountPath)
+	}
+
+	mode := info.Mode() | os.ModeSetgid | 0070
+	if int(stat.Gid) == gid && info.Mode() == mode {
+		return nil
+	}
+	klog.Infof("device: setting group %d on the volume mounted at %s", gid, mountPath)
+	if err = os.Chown(mountPath, -1, gid); err != nil {
+		return err
+	}
+	return os.Chmod(mountPath, mode)
+}
@@ -0,0 +1,77 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+
+	mnt "github.com/openebs/lib-csi/pkg/mount"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+// HasPartition checks if the volume is backed by a dedicated partition,
+// listed by ListPartUsed, as opposed to the thin and whole disk volumes.
+func HasPartition(vol *apis.DeviceVolume) bool {
+	return vol.Spec.Allocation == "" || vol.Spec.Allocation == AllocationPartition
+}
+
+// GetVolumePartitionName returns the name of the partition backing the volume.
+// The volumes created before the name was recorded in their status have a
+// partition named after the volume.
+func GetVolumePartitionName(vol *apis.DeviceVolume) string {
+	if vol.Status.PartitionName != "" {
+		return vol.Status.PartitionName
+	}
+	return getPartitionName(vol.Name)
+}
+
+// DeleteOrphanedPartition wipes and deletes a partition which doesn't belong
+// to any volume. The partition is left alone while it is mounted or held
+// open by any other user, as an exclusive open of the partition fails then.
+func DeleteOrphanedPartition(part PartUsed) error {
+	mounts, err := mnt.GetMounts(part.DevicePath)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("partition %s is mounted at %v", part.DevicePath, mounts)
+	}
+
+	fd, err := unix.Open(part.DevicePath, unix.O_RDONLY|unix.O_EXCL|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("partition %s is in use: %v", part.DevicePath, err)
+	}
+	_ = unix.Close(fd)
+
+	// the disk may have been adopted by another driver instance since
+	// the partition was listed
+	if err = checkDiskOwner(part.DiskPath); err != nil {
+		return err
+	}
+
+	klog.Infof("deleting orphaned partition %s (%s)", part.DevicePath, part.Name)
+	if err = discardPartition(part.Name, part.DevicePath, part.Size); err != nil {
+		klog.Errorf("Discard of partition %s failed: %v", part.DevicePath, err)
+	}
+	if err = wipeFSAndDeletePart(part.DiskPath, part.PartNum); err != nil {
+		return err
+	}
+	return updateJournal(part.DiskPath, func(j *Journal) { j.removePartition(part.Name) })
+}
@@ -0,0 +1,188 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// clusterIDNamespace is the namespace whose uid identifies the cluster
+const clusterIDNamespace = "kube-system"
+
+// foreignDiskError is returned for the disks owned by another driver
+// instance, or whose owner can't be known.
+type foreignDiskError struct {
+	disk  string
+	owner string
+}
+
+func (e *foreignDiskError) Error() string {
+	if e.owner == "" {
+		return fmt.Sprintf("disk %s has an unreadable journal, its owner is unknown", e.disk)
+	}
+	return fmt.Sprintf("disk %s is owned by driver instance %s", e.disk, e.owner)
+}
+
+// IsForeignDiskError checks if the error is about a disk which is not owned
+// by this driver instance.
+func IsForeignDiskError(err error) bool {
+	_, ok := err.(*foreignDiskError)
+	return ok
+}
+
+// SetupInstanceID sets the id of this driver instance, recorded in the
+// journals of the disks it owns, when it is not configured. The id is the
+// driver name qualified with the uid of the kube-system namespace, which
+// identifies the cluster.
+func SetupInstanceID(driverName string) error {
+	if DeviceConfiguration.InstanceID != "" {
+		return nil
+	}
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return err
+	}
+	ns, err := kubeClient.CoreV1().Namespaces().Get(context.TODO(), clusterIDNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get the uid of the cluster: %v", err)
+	}
+	DeviceConfiguration.InstanceID = driverName + "/" + string(ns.UID)
+	klog.Infof("driver instance id: %s", DeviceConfiguration.InstanceID)
+	return nil
+}
+
+// ownsJournal checks if the disk of the journal is owned by this driver
+// instance. The disks without journal, or whose journal was never written,
+// have no owner yet.
+func ownsJournal(j *Journal) bool {
+	return j == nil || j.InstanceID == "" || j.InstanceID == DeviceConfiguration.InstanceID
+}
+
+// ownerCacheTTL bounds how long the owner of a disk is cached, so that the
+// disks replaced or written by another instance are eventually checked again
+const ownerCacheTTL = time.Minute
+
+// ownerCheck is the cached outcome of the owner check of a disk
+type ownerCheck struct {
+	err       error
+	checkedAt time.Time
+}
+
+var (
+	// ownerCache holds the owner checks of the disks, which are done
+	// each time the partitions of a disk are listed
+	ownerCache    = map[string]ownerCheck{}
+	ownerCacheMtx sync.Mutex
+)
+
+// checkDiskOwner returns a foreignDiskError if the disk holding a meta
+// partition is not owned by this driver instance. The outcome is cached
+// until the journal of the disk is written by this instance.
+func checkDiskOwner(disk string) error {
+	ownerCacheMtx.Lock()
+	check, ok := ownerCache[disk]
+	ownerCacheMtx.Unlock()
+	if ok && time.Since(check.checkedAt) < ownerCacheTTL {
+		return check.err
+	}
+
+	checkedAt := time.Now()
+	f, err := openJournal(disk, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		err = checkJournalOwner(f, disk)
+		f.Close()
+	}
+	ownerCacheMtx.Lock()
+	ownerCache[disk] = ownerCheck{err: err, checkedAt: checkedAt}
+	ownerCacheMtx.Unlock()
+	return err
+}
+
+// invalidateDiskOwner drops the cached owner of the disk, once its journal
+// has been written.
+func invalidateDiskOwner(disk string) {
+	ownerCacheMtx.Lock()
+	delete(ownerCache, disk)
+	ownerCacheMtx.Unlock()
+}
+
+// checkJournalOwner returns a foreignDiskError if the journal held by the
+// file is not owned by this driver instance.
+func checkJournalOwner(f *os.File, disk string) error {
+	j, _, err := readJournal(f)
+	if err != nil {
+		klog.V(4).Infof("could not read the journal of disk %s: %v", disk, err)
+		return &foreignDiskError{disk: disk}
+	}
+	if !ownsJournal(j) {
+		return &foreignDiskError{disk: disk, owner: j.InstanceID}
+	}
+	return nil
+}
+
+// ListForeignDisks returns the disks holding a meta partition which are
+// owned by other driver instances, along with their owner. The owner of
+// the disks with an unreadable journal is empty.
+func ListForeignDisks() (map[string]string, error) {
+	diskList, err := getDiskList()
+	if err != nil {
+		return nil, err
+	}
+	foreign := map[string]string{}
+	for _, disk := range diskList {
+		_, err := GetPartitionList(disk.DiskPath, "", false)
+		if e, ok := err.(*foreignDiskError); ok {
+			foreign[disk.DiskPath] = e.owner
+		}
+	}
+	return foreign, nil
+}
+
+// AdoptDisk makes this driver instance the owner of the disk, e.g. once
+// moved from another cluster. The records of the journal are kept, an
+// unreadable journal is started over.
+func AdoptDisk(disk string) error {
+	journalMtx.Lock()
+	defer journalMtx.Unlock()
+
+	f, err := openJournal(disk, os.O_RDWR|os.O_SYNC)
+	if err != nil || f == nil {
+		return err
+	}
+	defer f.Close()
+
+	j, seq, err := readJournal(f)
+	if err != nil {
+		klog.Warningf("journal of disk %s is corrupted, starting over: %v", disk, err)
+		j = &Journal{}
+	}
+	klog.Infof("adopting disk %s owned by %q", disk, j.InstanceID)
+	j.InstanceID = DeviceConfiguration.InstanceID
+	defer invalidateDiskOwner(disk)
+	return writeJournal(f, j, seq+1)
+}
@@ -0,0 +1,128 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ownsJournal(t *testing.T) {
+	DeviceConfiguration.InstanceID = "device.csi.openebs.io/3f1c5b8e-0a54-4c1e-9e43-7d1f5a8e2b60"
+	defer func() { DeviceConfiguration.InstanceID = "" }()
+
+	tests := []struct {
+		name    string
+		journal *Journal
+		want    bool
+	}{
+		{name: "bare driver name", journal: &Journal{InstanceID: "device.csi.openebs.io"}, want: false},
+		{name: "no journal", journal: nil, want: true},
+		{name: "unclaimed disk", journal: &Journal{}, want: true},
+		{name: "owned disk", journal: &Journal{InstanceID: DeviceConfiguration.InstanceID}, want: true},
+		{
+			name:    "other cluster",
+			journal: &Journal{InstanceID: "device.csi.openebs.io/9b7e2d41-6c3a-4f8e-b1d2-5a9c8e7f6d30"},
+			want:    false,
+		},
+		{
+			name:    "other driver instance",
+			journal: &Journal{InstanceID: "fast.device.csi.openebs.io/3f1c5b8e-0a54-4c1e-9e43-7d1f5a8e2b60"},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ownsJournal(tt.journal); got != tt.want {
+				t.Errorf("ownsJournal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsForeignDiskError(t *testing.T) {
+	if !IsForeignDiskError(&foreignDiskError{disk: "sdc", owner: "other"}) {
+		t.Errorf("IsForeignDiskError() = false for a foreign disk error")
+	}
+	if IsForeignDiskError(errors.New("Wrong DiskMetaName")) {
+		t.Errorf("IsForeignDiskError() = true for another error")
+	}
+}
+
+func Test_updateJournalFile_claim(t *testing.T) {
+	DeviceConfiguration.InstanceID = "device.csi.openebs.io/3f1c5b8e-0a54-4c1e-9e43-7d1f5a8e2b60"
+	defer func() { DeviceConfiguration.InstanceID = "" }()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Truncate(journalSlots * journalSlotSize); err != nil {
+		t.Fatal(err)
+	}
+	// journal of a disk without owner
+	unclaimed := &Journal{
+		Partitions: []PartitionRecord{{Name: "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75", PVName: "pvc-1"}},
+	}
+	if err = writeJournal(f, unclaimed, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = updateJournalFile(f, "sdc", func(j *Journal) {}); err != nil {
+		t.Fatalf("updateJournalFile() error = %v", err)
+	}
+	j, _, err := readJournal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.InstanceID != DeviceConfiguration.InstanceID || j.getPartition("5d8d56cb-e291-4dfd-81ac-fb664dd5ec75") == nil {
+		t.Errorf("journal after update = %+v, want the records claimed by %s", j, DeviceConfiguration.InstanceID)
+	}
+
+	// journal recording another instance, e.g. the bare driver name
+	j.InstanceID = "device.csi.openebs.io"
+	if err = writeJournal(f, j, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err = updateJournalFile(f, "sdc", func(j *Journal) {}); !IsForeignDiskError(err) {
+		t.Errorf("updateJournalFile() error = %v, want a foreign disk error", err)
+	}
+}
+
+func Test_checkDiskOwner_cache(t *testing.T) {
+	disk := "device-localpv-test-missing"
+	foreign := &foreignDiskError{disk: disk, owner: "other"}
+	ownerCache[disk] = ownerCheck{err: foreign, checkedAt: time.Now()}
+	defer invalidateDiskOwner(disk)
+
+	if err := checkDiskOwner(disk); err != foreign {
+		t.Errorf("checkDiskOwner() = %v, want the cached %v", err, foreign)
+	}
+	// the journal of the disk is read again once invalidated or expired
+	ownerCache[disk] = ownerCheck{err: foreign, checkedAt: time.Now().Add(-ownerCacheTTL)}
+	if err := checkDiskOwner(disk); err == foreign || !os.IsNotExist(err) {
+		t.Errorf("checkDiskOwner() of an expired check = %v, want the error opening the missing disk", err)
+	}
+	ownerCache[disk] = ownerCheck{err: foreign, checkedAt: time.Now()}
+	invalidateDiskOwner(disk)
+	if err := checkDiskOwner(disk); err == foreign || !os.IsNotExist(err) {
+		t.Errorf("checkDiskOwner() of an invalidated check = %v, want the error opening the missing disk", err)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// publicationMtx serializes the updates of the publication records
+var publicationMtx sync.Mutex
+
+var (
+	// volumeLocks are the locks of the volumes being published or
+	// unpublished on the node
+	volumeLocks    = map[string]*volumeLock{}
+	volumeLocksMtx sync.Mutex
+)
+
+// volumeLock serializes the publish operations of a volume, it is
+// dropped once no operation holds or waits for it
+type volumeLock struct {
+	sync.Mutex
+	refs int
+}
+
+// LockVolume locks the volume until the returned function is called, so
+// that a publish request is checked against the publications of the volume
+// and recorded as one operation.
+func LockVolume(volName string) func() {
+	volumeLocksMtx.Lock()
+	lock, ok := volumeLocks[volName]
+	if !ok {
+		lock = &volumeLock{}
+		volumeLocks[volName] = lock
+	}
+	lock.refs++
+	volumeLocksMtx.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		volumeLocksMtx.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(volumeLocks, volName)
+		}
+		volumeLocksMtx.Unlock()
+	}
+}
+
+// publications maps the publish targets of a volume on the node to the
+// access modes they were published with
+type publications map[string][]string
+
+// publicationPath returns the file holding the publication records of the
+// volume, in the publish directory of the node agent
+func publicationPath(volName string) string {
+	return filepath.Join(DeviceConfiguration.PublishDir, volName+".json")
+}
+
+// getPublications returns the publication records of the volume, which
+// are empty when the volume is not published or the records are not kept.
+func getPublications(volName string) (publications, error) {
+	pubs := publications{}
+	if DeviceConfiguration.PublishDir == "" {
+		return pubs, nil
+	}
+	data, err := os.ReadFile(publicationPath(volName))
+	if errors.Is(err, os.ErrNotExist) {
+		return pubs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &pubs); err != nil {
+		return nil, err
+	}
+	return pubs, nil
+}
+
+// writePublications stores the publication records of the volume, the file
+// is removed once the volume has no publish target left
+func writePublications(volName string, pubs publications) error {
+	path := publicationPath(volName)
+	if len(pubs) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(pubs)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(DeviceConfiguration.PublishDir, 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RecordPublication records the access modes the volume is published with
+// at the mount path, so that the later publish requests of the volume are
+// checked against them.
+func RecordPublication(vol *apis.DeviceVolume, mountInfo *MountInfo) error {
+	if DeviceConfiguration.PublishDir == "" {
+		return nil
+	}
+	publicationMtx.Lock()
+	defer publicationMtx.Unlock()
+
+	pubs, err := getPublications(vol.Name)
+	if err != nil {
+		return err
+	}
+	pubs[mountInfo.MountPath] = mountInfo.AccessModes
+	return writePublications(vol.Name, pubs)
+}
+
+// RemovePublication removes the record of the volume published at the
+// target path, once it has been unpublished.
+func RemovePublication(volName string, targetPath string) error {
+	if DeviceConfiguration.PublishDir == "" {
+		return nil
+	}
+	publicationMtx.Lock()
+	defer publicationMtx.Unlock()
+
+	pubs, err := getPublications(volName)
+	if err != nil {
+		return err
+	}
+	if _, ok := pubs[targetPath]; !ok {
+		return nil
+	}
+	delete(pubs, targetPath)
+	klog.V(4).Infof("removing the publication of volume %s at %s", volName, targetPath)
+	return writePublications(volName, pubs)
+}
+
+// RecordPublished records in the status of the volume whether it is
+// published on the node, for the controller to report its published nodes.
+// The publication records take precedence over published, the outcome of
+// the last publish request, when they are kept.
+func RecordPublished(volName string, published bool) {
+	if DeviceConfiguration.PublishDir != "" {
+		publicationMtx.Lock()
+		pubs, err := getPublications(volName)
+		publicationMtx.Unlock()
+		if err != nil {
+			klog.Errorf("device: could not read the publications of %s: %v", volName, err)
+			return
+		}
+		published = len(pubs) > 0
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vol, err := GetDeviceVolume(volName)
+		if err != nil {
+			return err
+		}
+		if vol.Status.Published == published {
+			return nil
+		}
+		vol.Status.Published = published
+		_, err = volbuilder.NewKubeclient().WithNamespace(DeviceNamespace).Update(vol)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("device: could not record the publication status of %s: %v", volName, err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func TestPublications(t *testing.T) {
+	dir := t.TempDir()
+	oldDir := DeviceConfiguration.PublishDir
+	DeviceConfiguration.PublishDir = dir
+	defer func() { DeviceConfiguration.PublishDir = oldDir }()
+
+	vol := &apis.DeviceVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"}}
+	reader := []string{csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY.String()}
+	for _, path := range []string{"/a", "/b"} {
+		if err := RecordPublication(vol, &MountInfo{MountPath: path, AccessModes: reader}); err != nil {
+			t.Fatalf("RecordPublication() error = %v", err)
+		}
+	}
+	if err := RemovePublication(vol.Name, "/a"); err != nil {
+		t.Fatalf("RemovePublication() error = %v", err)
+	}
+	pubs, err := getPublications(vol.Name)
+	if err != nil {
+		t.Fatalf("getPublications() error = %v", err)
+	}
+	if want := (publications{"/b": reader}); !reflect.DeepEqual(pubs, want) {
+		t.Errorf("getPublications() = %v, want %v", pubs, want)
+	}
+
+	if err = RemovePublication(vol.Name, "/b"); err != nil {
+		t.Fatalf("RemovePublication() error = %v", err)
+	}
+	if _, err = os.Stat(publicationPath(vol.Name)); !os.IsNotExist(err) {
+		t.Errorf("publications of %s not removed: %v", vol.Name, err)
+	}
+}
+
+func TestLockVolume(t *testing.T) {
+	unlock := LockVolume("pvc-1")
+	locked := make(chan struct{})
+	go func() {
+		LockVolume("pvc-1")()
+		close(locked)
+	}()
+	// another volume is not held by the lock
+	LockVolume("pvc-2")()
+
+	select {
+	case <-locked:
+		t.Fatalf("volume locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+
+	volumeLocksMtx.Lock()
+	defer volumeLocksMtx.Unlock()
+	if len(volumeLocks) != 0 {
+		t.Errorf("volume locks left after unlocking: %v", volumeLocks)
+	}
+}
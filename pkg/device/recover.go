@@ -0,0 +1,119 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// RecoverVolumes rebuilds the DeviceVolumes of the partitions found on the
+// disks of this node, e.g. after the loss of the cluster state. The volumes
+// are Ready and described by the journals of the disks. The partitions
+// missing from the journals are returned apart, nothing names their volume:
+// the partitions are named after the uid of their DeviceVolume, not after
+// the volume. The thin and whole disk volumes can't be recovered either.
+func RecoverVolumes() ([]*apis.DeviceVolume, []PartUsed, error) {
+	parts, err := ListPartUsed()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	journals := map[string]*Journal{}
+	var vols []*apis.DeviceVolume
+	var unrecorded []PartUsed
+	for _, part := range parts {
+		j, ok := journals[part.DiskPath]
+		if !ok {
+			if j, err = ReadJournal(part.DiskPath); err != nil {
+				klog.Errorf("could not read the journal of disk %s: %v", part.DiskPath, err)
+			}
+			journals[part.DiskPath] = j
+		}
+		var rec *PartitionRecord
+		if j != nil {
+			rec = j.getPartition(part.Name)
+		}
+		if rec == nil {
+			klog.Warningf("partition %s (%s) has no journal record, skipped", part.DevicePath, part.Name)
+			unrecorded = append(unrecorded, part)
+			continue
+		}
+
+		vol, err := buildRecoveredVolume(part, rec)
+		if err != nil {
+			return nil, nil, err
+		}
+		vol.Status.Partition = getPartitionLocation(part)
+		vols = append(vols, vol)
+	}
+	return vols, unrecorded, nil
+}
+
+// buildRecoveredVolume builds the DeviceVolume owning the partition from its
+// journal record. The partitions in the recycle bin are recovered as
+// Released volumes.
+func buildRecoveredVolume(part PartUsed, rec *PartitionRecord) (*apis.DeviceVolume, error) {
+	annotations := map[string]string{}
+	if rec.PVCName != "" {
+		annotations[PVCNameKey] = rec.PVCName
+		annotations[PVCNamespaceKey] = rec.PVCNamespace
+	}
+	vol, err := volbuilder.NewBuilder().
+		WithName(rec.PVName).
+		WithNamespace(DeviceNamespace).
+		WithCapacity(strconv.FormatUint(part.Size, 10)).
+		WithOwnerNode(NodeID).
+		WithDeviceName(part.DevName).
+		WithAllocation(AllocationPartition).
+		WithVolumeMode(rec.VolumeMode).
+		WithFsType(rec.FsType).
+		WithMkfsOptions(rec.MkfsOptions).
+		WithFsckPolicy(rec.FsckPolicy).
+		WithFsckFallback(rec.FsckFallback).
+		WithDiscard(rec.Discard).
+		WithShared(rec.Shared).
+		WithRetention(rec.Retention).
+		WithVolumeStatus(DeviceStatusReady).
+		WithFinalizer([]string{DeviceFinalizer}).
+		WithLabels(map[string]string{DeviceNodeKey: NodeID}).
+		WithAnnotations(annotations).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	if rec.IOLimits != nil {
+		limits := *rec.IOLimits
+		vol.Spec.IOLimits = &limits
+	}
+	vol.Status.PartitionName = part.Name
+	// the partitions in the recycle bin stay there until their purge
+	// time, if it was recorded, or until the volume is deleted
+	if isTrashPartition(part.Name) {
+		vol.Status.State = DeviceStatusReleased
+		if rec.PurgeTime != nil {
+			purgeTime := metav1.NewTime(*rec.PurgeTime)
+			vol.Status.PurgeTime = &purgeTime
+		}
+	}
+	return vol, nil
+}
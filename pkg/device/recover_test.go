@@ -0,0 +1,133 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func Test_buildRecoveredVolume(t *testing.T) {
+	NodeID, DeviceNamespace = "node-1", "openebs"
+	defer func() { NodeID, DeviceNamespace = "", "" }()
+	part := PartUsed{
+		DiskPath:   "sdc",
+		PartNum:    2,
+		Name:       "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75",
+		DevicePath: "/dev/sdc2",
+		Size:       10737418240,
+		DevName:    "test-device",
+	}
+
+	rec := &PartitionRecord{
+		Name:         "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75",
+		PVName:       "pvc-5d8d56cb-e291-4dfd-81ac-fb664dd5ec75-journal",
+		PVCNamespace: "default",
+		PVCName:      "data",
+		FsType:       "ext4",
+	}
+
+	tests := []struct {
+		name      string
+		rec       *PartitionRecord
+		wantClaim string
+	}{
+		{name: "claim", rec: rec, wantClaim: "data"},
+		{name: "no claim", rec: &PartitionRecord{Name: rec.Name, PVName: rec.PVName, FsType: "ext4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vol, err := buildRecoveredVolume(part, tt.rec)
+			if err != nil {
+				t.Fatalf("buildRecoveredVolume() error = %v", err)
+			}
+			if vol.Name != rec.PVName {
+				t.Errorf("buildRecoveredVolume() name = %v, want %v", vol.Name, rec.PVName)
+			}
+			if vol.Status.PartitionName != part.Name {
+				t.Errorf("buildRecoveredVolume() partition = %v, want %v", vol.Status.PartitionName, part.Name)
+			}
+			if vol.Annotations[PVCNameKey] != tt.wantClaim {
+				t.Errorf("buildRecoveredVolume() claim = %q, want %q", vol.Annotations[PVCNameKey], tt.wantClaim)
+			}
+			if vol.Spec.Capacity != "10737418240" || vol.Spec.DevName != "test-device" ||
+				vol.Spec.OwnerNodeID != "node-1" || vol.Spec.Allocation != AllocationPartition {
+				t.Errorf("buildRecoveredVolume() spec = %+v", vol.Spec)
+			}
+			if vol.Spec.FsType != "ext4" {
+				t.Errorf("buildRecoveredVolume() fsType = %q, want ext4", vol.Spec.FsType)
+			}
+			if vol.Status.State != DeviceStatusReady || len(vol.Finalizers) != 1 ||
+				vol.Labels[DeviceNodeKey] != "node-1" {
+				t.Errorf("buildRecoveredVolume() = %+v, want a Ready volume of node-1", vol)
+			}
+		})
+	}
+}
+
+func Test_buildRecoveredVolume_spec(t *testing.T) {
+	NodeID, DeviceNamespace = "node-1", "openebs"
+	defer func() { NodeID, DeviceNamespace = "", "" }()
+	part := PartUsed{Name: "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75", Size: 10737418240, DevName: "test-device"}
+	spec := apis.VolumeInfo{
+		VolumeMode:   string(corev1.PersistentVolumeBlock),
+		MkfsOptions:  "-E lazy_itable_init=1",
+		FsckPolicy:   "onError",
+		FsckFallback: "readOnly",
+		Discard:      DiscardPeriodic,
+		Shared:       "yes",
+		Retention:    "72h",
+		IOLimits:     &apis.IOLimits{ReadBps: 1048576},
+	}
+	vol := &apis.DeviceVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"}, Spec: spec}
+
+	rec := newPartitionRecord(vol, part.Name, part.Size)
+	recovered, err := buildRecoveredVolume(part, &rec)
+	if err != nil {
+		t.Fatalf("buildRecoveredVolume() error = %v", err)
+	}
+	got := recovered.Spec
+	got.Capacity, got.DevName, got.OwnerNodeID, got.Allocation = "", "", "", ""
+	if !reflect.DeepEqual(got, spec) {
+		t.Errorf("buildRecoveredVolume() spec = %+v, want %+v", got, spec)
+	}
+	if mode := GetVolumeMode(recovered); mode != corev1.PersistentVolumeBlock {
+		t.Errorf("GetVolumeMode() = %v, want Block", mode)
+	}
+
+	// the records written without the volume mode are filesystem volumes
+	recovered, err = buildRecoveredVolume(part, &PartitionRecord{Name: part.Name, PVName: "pvc-1"})
+	if err != nil {
+		t.Fatalf("buildRecoveredVolume() error = %v", err)
+	}
+	if mode := GetVolumeMode(recovered); mode != corev1.PersistentVolumeFilesystem {
+		t.Errorf("GetVolumeMode() of a record without mode = %v, want Filesystem", mode)
+	}
+
+	if rec.setVolumeSpec(&vol.Spec) {
+		t.Errorf("setVolumeSpec() changed the record of an unmodified volume")
+	}
+	vol.Spec.IOLimits = nil
+	if !rec.setVolumeSpec(&vol.Spec) || rec.IOLimits != nil {
+		t.Errorf("setVolumeSpec() did not record the removed IO limits: %+v", rec)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// trashPrefix prefixes the names of the partitions in the recycle bin
+const trashPrefix = "trash-"
+
+// isTrashPartition checks if the partition is in the recycle bin
+func isTrashPartition(partName string) bool {
+	return strings.HasPrefix(partName, trashPrefix)
+}
+
+// IsTrashed checks if the partition of the volume is in the recycle bin
+func IsTrashed(vol *apis.DeviceVolume) bool {
+	return HasPartition(vol) && isTrashPartition(GetVolumePartitionName(vol))
+}
+
+// trashPartitionName returns the name of the partition of the volume once
+// in the recycle bin. It is derived from the uid of the volume, capped to
+// the maximum length of a GPT partition name.
+func trashPartitionName(vol *apis.DeviceVolume) string {
+	name := trashPrefix + string(vol.UID)
+	if len(name) > maxPartitionNameLen {
+		name = name[:maxPartitionNameLen]
+	}
+	return name
+}
+
+// getRecycledCapacity returns the size in bytes of the partitions in the
+// recycle bin, from the parted rows of a disk.
+func getRecycledCapacity(rows []partedOutput) uint64 {
+	var recycled uint64
+	for _, row := range rows {
+		if row.fsType != freeSlotFSType && isTrashPartition(row.partName) {
+			recycled += row.size
+		}
+	}
+	return recycled
+}
+
+// ParseUndeleteClaim returns the namespace and the name of the claim of
+// the undelete annotation of a volume.
+func ParseUndeleteClaim(value string) (string, string, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid claim %q, must be namespace/name", value)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ReleaseVolume moves a deleted volume to the recycle bin when it has a
+// retention, the volume becomes Released until its PurgeTime. It returns
+// false for the volumes which are deleted right away.
+func ReleaseVolume(vol *apis.DeviceVolume) (bool, error) {
+	if vol.Status.State == DeviceStatusReleased {
+		return true, nil
+	}
+	if vol.Spec.Retention == "" || !HasPartition(vol) || vol.Status.State != DeviceStatusReady {
+		return false, nil
+	}
+	retention, err := time.ParseDuration(vol.Spec.Retention)
+	if err != nil {
+		klog.Warningf("invalid retention %q of volume %s, deleting it", vol.Spec.Retention, vol.Name)
+		return false, nil
+	}
+	purgeTime := metav1.NewTime(time.Now().Add(retention))
+	vol.Status.State = DeviceStatusReleased
+	vol.Status.PurgeTime = &purgeTime
+	if _, err = volbuilder.NewKubeclient().WithNamespace(DeviceNamespace).Update(vol); err != nil {
+		return false, err
+	}
+	klog.Infof("released volume %s, it will be purged at %s", vol.Name, purgeTime)
+	return true, nil
+}
+
+// TrashVolume moves the partition of a Released volume to the recycle bin.
+// The partition is renamed, so that it is no longer found as the partition
+// of a volume, and its blocks are kept until the volume is purged.
+func TrashVolume(vol *apis.DeviceVolume) error {
+	if IsTrashed(vol) {
+		return nil
+	}
+	from, to := GetVolumePartitionName(vol), trashPartitionName(vol)
+	var purgeTime *time.Time
+	if vol.Status.PurgeTime != nil {
+		t := vol.Status.PurgeTime.UTC()
+		purgeTime = &t
+	}
+	err := renameVolumePartition(vol, from, to, func(rec *PartitionRecord) {
+		rec.PurgeTime = purgeTime
+	})
+	if err != nil {
+		return err
+	}
+	vol.Status.PartitionName = to
+	_, err = volbuilder.NewKubeclient().WithNamespace(DeviceNamespace).Update(vol)
+	return err
+}
+
+// RestoreVolume takes the partition of a Released volume out of the recycle
+// bin. The volume becomes Ready again, provisioned for the given claim. The
+// undelete annotation is kept until the PersistentVolume of the volume is
+// created, see ClearUndelete.
+func RestoreVolume(vol *apis.DeviceVolume, namespace string, claim string) error {
+	to := newPartitionName(vol)
+	err := renameVolumePartition(vol, GetVolumePartitionName(vol), to, func(rec *PartitionRecord) {
+		rec.PVCNamespace, rec.PVCName = namespace, claim
+		rec.PurgeTime = nil
+	})
+	if err != nil {
+		return err
+	}
+	if vol.Annotations == nil {
+		vol.Annotations = map[string]string{}
+	}
+	vol.Annotations[PVCNamespaceKey] = namespace
+	vol.Annotations[PVCNameKey] = claim
+	vol.Status.State = DeviceStatusReady
+	vol.Status.PartitionName = to
+	vol.Status.PurgeTime = nil
+	if _, err = volbuilder.NewKubeclient().WithNamespace(DeviceNamespace).Update(vol); err != nil {
+		return err
+	}
+	klog.Infof("restored volume %s for claim %s/%s", vol.Name, namespace, claim)
+	return nil
+}
+
+// ClearUndelete removes the undelete annotation of a restored volume, once
+// its PersistentVolume has been created.
+func ClearUndelete(vol *apis.DeviceVolume) error {
+	if _, ok := vol.Annotations[UndeleteKey]; !ok {
+		return nil
+	}
+	delete(vol.Annotations, UndeleteKey)
+	_, err := volbuilder.NewKubeclient().WithNamespace(DeviceNamespace).Update(vol)
+	return err
+}
+
+// renameVolumePartition renames the partition of the volume and its record
+// in the journal of the disk. The partition may have been renamed already,
+// by an attempt which failed to update the volume.
+func renameVolumePartition(vol *apis.DeviceVolume, from string, to string, update func(rec *PartitionRecord)) error {
+	pList, err := getAllPartsUsed(vol.Spec.DevName, from)
+	if err != nil {
+		return err
+	}
+	if len(pList) == 0 {
+		if pList, err = getAllPartsUsed(vol.Spec.DevName, to); err != nil {
+			return err
+		}
+	}
+	if len(pList) != 1 {
+		return fmt.Errorf("found %d partitions of volume %s", len(pList), vol.Name)
+	}
+	part := pList[0]
+	if part.Name != to {
+		klog.Infof("renaming partition %s of volume %s from %q to %q", part.DevicePath, vol.Name, from, to)
+		_, err = RunCommand(strings.Split(fmt.Sprintf(PartitionRename, part.DiskPath, part.PartNum, to), " "))
+		if err != nil {
+			return err
+		}
+	}
+	return updateJournal(part.DiskPath, func(j *Journal) {
+		rec := newPartitionRecord(vol, to, part.Size)
+		if old := j.getPartition(from); old != nil {
+			rec = *old
+			rec.Name = to
+		} else if old = j.getPartition(to); old != nil {
+			rec = *old
+		}
+		update(&rec)
+		j.removePartition(from)
+		j.putPartition(rec)
+	})
+}
@@ -0,0 +1,118 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func Test_trashPartitionName(t *testing.T) {
+	vol := &apis.DeviceVolume{}
+	vol.UID = "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75"
+	name := trashPartitionName(vol)
+	if name != "trash-5d8d56cb-e291-4dfd-81ac-fb664d" {
+		t.Errorf("trashPartitionName() = %q", name)
+	}
+	if len(name) > maxPartitionNameLen || !isTrashPartition(name) {
+		t.Errorf("trashPartitionName() = %q, want a trash name of at most %d characters", name, maxPartitionNameLen)
+	}
+	if isTrashPartition(string(vol.UID)) {
+		t.Errorf("isTrashPartition(%q) = true", vol.UID)
+	}
+}
+
+func Test_getRecycledCapacity(t *testing.T) {
+	rows := []partedOutput{
+		{partNum: 1, size: 1048576, partName: "test-device"},
+		{partNum: 2, size: 10737418240, partName: "5d8d56cb-e291-4dfd-81ac-fb664dd5ec75"},
+		{partNum: 3, size: 2147483648, partName: "trash-0a1b2c3d-e291-4dfd-81ac-fb664d"},
+		{partNum: 4, size: 1073741824, partName: "trash-9f8e7d6c-e291-4dfd-81ac-fb664d"},
+		{size: 4294967296, fsType: freeSlotFSType},
+	}
+	if got := getRecycledCapacity(rows); got != 3221225472 {
+		t.Errorf("getRecycledCapacity() = %d, want 3221225472", got)
+	}
+	if got := getRecycledCapacity(rows[:2]); got != 0 {
+		t.Errorf("getRecycledCapacity() of an empty recycle bin = %d", got)
+	}
+}
+
+func TestParseUndeleteClaim(t *testing.T) {
+	tests := []struct {
+		value         string
+		wantNamespace string
+		wantName      string
+		wantErr       bool
+	}{
+		{value: "default/data", wantNamespace: "default", wantName: "data"},
+		{value: "data", wantErr: true},
+		{value: "default/", wantErr: true},
+		{value: "/data", wantErr: true},
+		{value: "default/data/1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			namespace, name, err := ParseUndeleteClaim(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseUndeleteClaim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if namespace != tt.wantNamespace || name != tt.wantName {
+				t.Errorf("ParseUndeleteClaim() = %q, %q, want %q, %q",
+					namespace, name, tt.wantNamespace, tt.wantName)
+			}
+		})
+	}
+}
+
+func Test_buildRecoveredVolume_trash(t *testing.T) {
+	NodeID, DeviceNamespace = "node-1", "openebs"
+	defer func() { NodeID, DeviceNamespace = "", "" }()
+	part := PartUsed{
+		DiskPath: "sdc",
+		PartNum:  3,
+		Name:     "trash-5d8d56cb-e291-4dfd-81ac-fb664d",
+		Size:     10737418240,
+		DevName:  "test-device",
+	}
+	purgeTime := time.Date(2021, 6, 4, 0, 0, 0, 0, time.UTC)
+	rec := &PartitionRecord{
+		Name:      part.Name,
+		PVName:    "pvc-5d8d56cb-e291-4dfd-81ac-fb664dd5ec75",
+		PurgeTime: &purgeTime,
+	}
+
+	vol, err := buildRecoveredVolume(part, rec)
+	if err != nil {
+		t.Fatalf("buildRecoveredVolume() error = %v", err)
+	}
+	if vol.Status.State != DeviceStatusReleased || vol.Status.PurgeTime == nil ||
+		!vol.Status.PurgeTime.Time.Equal(purgeTime) {
+		t.Errorf("buildRecoveredVolume() status = %+v, want Released until %s", vol.Status, purgeTime)
+	}
+
+	rec.PurgeTime = nil
+	vol, err = buildRecoveredVolume(part, rec)
+	if err != nil {
+		t.Fatalf("buildRecoveredVolume() error = %v", err)
+	}
+	if vol.Status.State != DeviceStatusReleased || vol.Status.PurgeTime != nil {
+		t.Errorf("buildRecoveredVolume() status = %+v, want Released without purge time", vol.Status)
+	}
+}
@@ -0,0 +1,809 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openebs/lib-csi/pkg/common/errors"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+// Device mapper commands
+const (
+	DMSetupCreate  = "dmsetup create %s --table"
+	DMSetupStatus  = "dmsetup status %s"
+	DMSetupTable   = "dmsetup table"
+	DMSetupDevNum  = "dmsetup info -c --noheadings -o major,minor %s"
+	DMSetupMessage = "dmsetup message %s 0 %s"
+	DMSetupRemove  = "dmsetup remove %s"
+	DMSetupSuspend = "dmsetup suspend %s"
+	DMSetupReload  = "dmsetup reload %s --table"
+	DMSetupResume  = "dmsetup resume %s"
+
+	PartitionResize = "parted /dev/%s resizepart %d %dMiB --script"
+)
+
+const (
+	// names of the partitions backing the dm-thin pool of a disk
+	thinPoolMetaPartName = "thinpool-meta"
+	thinPoolDataPartName = "thinpool-data"
+
+	// dmNamePrefix is the prefix of all the device mapper devices
+	// created by the plugin
+	dmNamePrefix   = "devlocalpv-"
+	thinPoolSuffix = "-tpool"
+
+	sectorSize = 512
+	// thinPoolChunkSectors is the allocation unit of the pool (512KiB)
+	thinPoolChunkSectors = 1024
+	// thinPoolLowWaterMark is the number of free chunks below which
+	// the pool raises an event
+	thinPoolLowWaterMark = 1024
+	// thinPoolMetaBlockSize is the size of a block of the metadata device
+	thinPoolMetaBlockSize = 4096
+
+	// limits of the metadata partition carved out for the pool
+	thinPoolMinMetaMiB = 16
+	thinPoolMaxMetaMiB = 16 * 1024
+	// thinPoolMetaRatio is the data to metadata size ratio of the pool
+	thinPoolMetaRatio = 4096
+
+	mib = 1024 * 1024
+
+	// thin device ids are 24 bit numbers
+	maxThinDeviceID = 1<<24 - 1
+	// number of ids to try before giving up on creating a thin device
+	thinDeviceIDRetries = 16
+)
+
+// thinPoolMtx serializes the creation and the growth of the pools with the
+// creation of the thin volumes, which checks the room left in the pools
+var thinPoolMtx sync.Mutex
+
+// thinPool represents the dm-thin pool created on a disk
+type thinPool struct {
+	// Name of the pool device mapper device
+	Name string
+
+	DiskPath   string
+	MetaDevice string
+	MetaSize   uint64
+	DataDevice string
+	DataSize   uint64
+}
+
+// ThinPoolUsage represents the usage of a dm-thin pool created by the plugin.
+type ThinPoolUsage struct {
+	// DeviceName is the meta partition name of the disk holding the pool
+	DeviceName string
+	DiskPath   string
+
+	// all the sizes are in bytes
+	DataSize     uint64
+	DataUsed     uint64
+	MetadataSize uint64
+	MetadataUsed uint64
+	Provisioned  uint64
+}
+
+// getThinPoolName returns the device mapper name of the pool
+// created on the disk with the given disk identifier.
+func getThinPoolName(diskID string) string {
+	return dmNamePrefix + strings.ToLower(diskID) + thinPoolSuffix
+}
+
+// getThinVolumeName returns the device mapper name of a thin volume
+func getThinVolumeName(volumeName string) string {
+	return dmNamePrefix + volumeName
+}
+
+// isThinPoolPartition checks if the partition backs a dm-thin pool
+func isThinPoolPartition(partName string) bool {
+	return partName == thinPoolMetaPartName || partName == thinPoolDataPartName
+}
+
+// dmDeviceExists checks if the device mapper device is active
+func dmDeviceExists(name string) bool {
+	cList := strings.Split(fmt.Sprintf(DMSetupStatus, name), " ")
+	return exec.Command(cList[0], cList[1:]...).Run() == nil
+}
+
+// getThinPoolOnDisk returns the dm-thin pool created on the disk, if any.
+func getThinPoolOnDisk(diskPath string, diskMetaName string) (*thinPool, error) {
+	tmpList, err := GetPartitionList(diskPath, diskMetaName, false)
+	if err != nil {
+		return nil, err
+	}
+	pool := &thinPool{DiskPath: diskPath}
+	for _, tmp := range tmpList {
+		switch tmp.partName {
+		case thinPoolMetaPartName:
+			pool.MetaDevice = getPartitionPath(diskPath, tmp.partNum)
+			pool.MetaSize = tmp.size
+		case thinPoolDataPartName:
+			pool.DataDevice = getPartitionPath(diskPath, tmp.partNum)
+			pool.DataSize = tmp.size
+		}
+	}
+	if pool.MetaDevice == "" || pool.DataDevice == "" {
+		return nil, nil
+	}
+	id, err := getDiskIdentifier(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	pool.Name = getThinPoolName(id)
+	return pool, nil
+}
+
+// listThinPools lists the dm-thin pools created on the disks with the given
+// disk meta name.
+func listThinPools(diskMetaName string) ([]thinPool, error) {
+	diskList, err := getDiskList()
+	if err != nil {
+		klog.Errorf("GetDiskList failed %s", err)
+		return nil, err
+	}
+	var pools []thinPool
+	for _, disk := range diskList {
+		pool, err := getThinPoolOnDisk(disk.DiskPath, diskMetaName)
+		if err != nil {
+			klog.V(4).Infof("GetThinPool Error, %s: %v", disk.DiskPath, err)
+			continue
+		}
+		if pool != nil {
+			pools = append(pools, *pool)
+		}
+	}
+	return pools, nil
+}
+
+// createThinPool carves the metadata and data partitions of a new dm-thin
+// pool out of the largest free slot on a disk with the given meta name, which
+// doesn't have a pool yet, and activates the pool. The data partition is of
+// the configured pool size, or of the given size if larger, so the pool only
+// takes what it needs of the slot and grows into the rest later on. The
+// metadata partition is sized for the pool filling the whole slot.
+func createThinPool(diskMetaName string, dataSize uint64) (*thinPool, error) {
+	pList, err := getAllPartsFree(diskMetaName)
+	if err != nil {
+		return nil, err
+	}
+	pools, err := listThinPools(diskMetaName)
+	if err != nil {
+		return nil, err
+	}
+	hasPool := map[string]bool{}
+	for _, pool := range pools {
+		hasPool[pool.DiskPath] = true
+	}
+
+	sort.Slice(pList, func(i, j int) bool {
+		// ">" Descending order
+		return pList[i].SizeMiB > pList[j].SizeMiB
+	})
+	var slot *partFree
+	for i := range pList {
+		if !hasPool[pList[i].DiskName] {
+			slot = &pList[i]
+			break
+		}
+	}
+	if slot == nil {
+		return nil, fmt.Errorf("could not find a disk without thin pool for disk name: %s", diskMetaName)
+	}
+
+	metaMiB := getThinPoolMetaSize(slot.SizeMiB)
+	dataMiB, err := getThinPoolDataSize(slot.SizeMiB, metaMiB, dataSize)
+	if err != nil {
+		return nil, fmt.Errorf("%v on disk name: %s", err, diskMetaName)
+	}
+
+	klog.Infof("Creating thin pool on disk %s of size %dMiB", slot.DiskName, dataMiB)
+	pool, err := buildThinPool(slot, metaMiB, dataMiB, diskMetaName)
+	if err != nil {
+		// the next attempt would find a half built pool
+		klog.Errorf("could not create thin pool on disk %s, removing its partitions: %v", slot.DiskName, err)
+		if rmErr := removeThinPoolPartitions(slot.DiskName, diskMetaName); rmErr != nil {
+			klog.Errorf("could not remove the thin pool partitions of disk %s: %v", slot.DiskName, rmErr)
+		}
+		return nil, err
+	}
+	return pool, nil
+}
+
+// getThinPoolDataSize returns the size in MiB of the data partition of a new
+// pool holding at least dataSize bytes, in a free slot of the given size.
+func getThinPoolDataSize(slotMiB uint64, metaMiB uint64, dataSize uint64) (uint64, error) {
+	if slotMiB <= 2*metaMiB {
+		return 0, fmt.Errorf("free space of %dMiB is too small for a thin pool", slotMiB)
+	}
+	availableMiB := slotMiB - metaMiB
+	dataMiB := (dataSize + mib - 1) / mib
+	if dataMiB > availableMiB {
+		return 0, fmt.Errorf("free space of %dMiB is too small for a thin pool of %dMiB", slotMiB, dataMiB)
+	}
+	configuredMiB := (DeviceConfiguration.ThinPoolSize + mib - 1) / mib
+	if configuredMiB > dataMiB {
+		dataMiB = configuredMiB
+	}
+	if dataMiB == 0 || dataMiB > availableMiB {
+		dataMiB = availableMiB
+	}
+	return dataMiB, nil
+}
+
+// buildThinPool creates the metadata and data partitions of a pool in the
+// free slot and activates the pool.
+func buildThinPool(slot *partFree, metaMiB uint64, dataMiB uint64, diskMetaName string) (*thinPool, error) {
+	err := createNamedPartition(slot.DiskName, thinPoolMetaPartName, slot.StartMiB, metaMiB)
+	if err != nil {
+		return nil, err
+	}
+	if err = createNamedPartition(slot.DiskName, thinPoolDataPartName,
+		slot.StartMiB+metaMiB, dataMiB); err != nil {
+		return nil, err
+	}
+
+	pool, err := getThinPoolOnDisk(slot.DiskName, diskMetaName)
+	if err != nil {
+		return nil, err
+	}
+	if pool == nil {
+		return nil, fmt.Errorf("could not find created thin pool on disk %s", slot.DiskName)
+	}
+
+	// a new pool needs a zeroed superblock on the metadata device
+	if err = zeroDeviceHeader(pool.MetaDevice, thinPoolMetaBlockSize); err != nil {
+		return nil, err
+	}
+	return pool, activateThinPool(pool)
+}
+
+// growThinPool extends the data partition of the pool into the free space
+// following it, so that it holds at least dataSize bytes, and reloads the
+// pool with the new data size.
+func growThinPool(pool *thinPool, diskMetaName string, dataSize uint64) error {
+	rows, err := GetPartitionList(pool.DiskPath, diskMetaName, true)
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if row.fsType == freeSlotFSType || row.partName != thinPoolDataPartName {
+			continue
+		}
+		startMiB := row.beginBytes / mib
+		endMiB := startMiB + (dataSize+mib-1)/mib
+		var maxEndMiB uint64
+		if i+1 < len(rows) && rows[i+1].fsType == freeSlotFSType {
+			maxEndMiB = parsePartFree(rows[i+1]).EndMiB
+		}
+		if endMiB > maxEndMiB {
+			return fmt.Errorf("thin pool %s can't grow to %dMiB, the free space following it is too small",
+				pool.Name, endMiB-startMiB)
+		}
+
+		klog.Infof("Growing thin pool %s to %dMiB", pool.Name, endMiB-startMiB)
+		if _, err = RunCommand(strings.Split(fmt.Sprintf(PartitionResize,
+			pool.DiskPath, row.partNum, endMiB), " ")); err != nil {
+			return err
+		}
+		grown, err := getThinPoolOnDisk(pool.DiskPath, diskMetaName)
+		if err != nil {
+			return err
+		}
+		if grown == nil {
+			return fmt.Errorf("could not find grown thin pool on disk %s", pool.DiskPath)
+		}
+		*pool = *grown
+		return reloadThinPool(pool)
+	}
+	return fmt.Errorf("could not find the data partition of thin pool %s", pool.Name)
+}
+
+// removeThinPoolPartitions deletes the partitions of a pool which could not
+// be activated, so that it doesn't hold any volume.
+func removeThinPoolPartitions(disk string, diskMetaName string) error {
+	rows, err := GetPartitionList(disk, diskMetaName, false)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if !isThinPoolPartition(row.partName) {
+			continue
+		}
+		if err = deletePartition(disk, row.partNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getThinPoolMetaSize returns the size of the metadata partition in MiB
+// for a pool created on a free slot of the given size.
+func getThinPoolMetaSize(slotMiB uint64) uint64 {
+	metaMiB := slotMiB / thinPoolMetaRatio
+	if metaMiB < thinPoolMinMetaMiB {
+		return thinPoolMinMetaMiB
+	}
+	if metaMiB > thinPoolMaxMetaMiB {
+		return thinPoolMaxMetaMiB
+	}
+	return metaMiB
+}
+
+// createNamedPartition creates a partition with the given name at the
+// provided start address on the disk.
+func createNamedPartition(disk string, partitionName string, start uint64, size uint64) error {
+	_, err := RunCommand(strings.Split(fmt.Sprintf(PartitionCreate, disk, partitionName, start, start+size), " "))
+	if err != nil {
+		klog.Errorf("Create Partition %s failed on disk %s: %s", partitionName, disk, err)
+	}
+	return err
+}
+
+// zeroDeviceHeader overwrites the first bytes of the device with zeros
+func zeroDeviceHeader(devicePath string, size int) error {
+	f, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(make([]byte, size)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// getThinPoolTable returns the device mapper table of the pool
+func getThinPoolTable(pool *thinPool) string {
+	dataSectors := pool.DataSize / sectorSize / thinPoolChunkSectors * thinPoolChunkSectors
+	return fmt.Sprintf("0 %d thin-pool %s %s %d %d", dataSectors,
+		pool.MetaDevice, pool.DataDevice, thinPoolChunkSectors, thinPoolLowWaterMark)
+}
+
+// activateThinPool activates the device mapper device of the pool
+func activateThinPool(pool *thinPool) error {
+	if dmDeviceExists(pool.Name) {
+		return nil
+	}
+	table := getThinPoolTable(pool)
+	klog.Infof("Activating thin pool %s: %s", pool.Name, table)
+	_, err := RunCommand(append(strings.Split(fmt.Sprintf(DMSetupCreate, pool.Name), " "), table))
+	return err
+}
+
+// reloadThinPool loads the table of the active pool again, after its data
+// partition has grown. The IOs of the thin volumes are held while the pool
+// is suspended.
+func reloadThinPool(pool *thinPool) error {
+	table := getThinPoolTable(pool)
+	klog.Infof("Reloading thin pool %s: %s", pool.Name, table)
+	if _, err := RunCommand(append(strings.Split(fmt.Sprintf(DMSetupReload, pool.Name), " "), table)); err != nil {
+		return err
+	}
+	if _, err := RunCommand(strings.Split(fmt.Sprintf(DMSetupSuspend, pool.Name), " ")); err != nil {
+		return err
+	}
+	_, err := RunCommand(strings.Split(fmt.Sprintf(DMSetupResume, pool.Name), " "))
+	return err
+}
+
+// getThinPoolUsage returns the usage of an active pool
+func getThinPoolUsage(pool *thinPool) (ThinPoolUsage, error) {
+	usage := ThinPoolUsage{DiskPath: pool.DiskPath}
+	out, err := RunCommand(strings.Split(fmt.Sprintf(DMSetupStatus, pool.Name), " "))
+	if err != nil {
+		return usage, err
+	}
+	metaUsed, metaTotal, dataUsed, dataTotal, err := parseThinPoolStatus(out)
+	if err != nil {
+		return usage, err
+	}
+	usage.MetadataUsed = metaUsed * thinPoolMetaBlockSize
+	usage.MetadataSize = metaTotal * thinPoolMetaBlockSize
+	usage.DataUsed = dataUsed * thinPoolChunkSectors * sectorSize
+	usage.DataSize = dataTotal * thinPoolChunkSectors * sectorSize
+
+	devNum, err := RunCommand(strings.Split(fmt.Sprintf(DMSetupDevNum, pool.Name), " "))
+	if err != nil {
+		return usage, err
+	}
+	table, err := RunCommand(strings.Split(DMSetupTable, " "))
+	if err != nil {
+		return usage, err
+	}
+	usage.Provisioned = parseThinProvisioned(table, strings.TrimSpace(devNum))
+	return usage, nil
+}
+
+// parseThinPoolStatus parses the status of a thin pool and returns the used
+// and total metadata blocks followed by the used and total data blocks.
+// A sample status of the pool looks like
+// 0 2097152 thin-pool 1 178/4096 2038/16384 - rw no_discard_passdown queue_if_no_space - 1024
+func parseThinPoolStatus(out string) (uint64, uint64, uint64, uint64, error) {
+	fields := strings.Fields(out)
+	if len(fields) < 6 || fields[2] != "thin-pool" {
+		return 0, 0, 0, 0, fmt.Errorf("invalid thin pool status: %s", out)
+	}
+	metaUsed, metaTotal, err := parseUsedTotal(fields[4])
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	dataUsed, dataTotal, err := parseUsedTotal(fields[5])
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return metaUsed, metaTotal, dataUsed, dataTotal, nil
+}
+
+// parseUsedTotal parses a "used/total" pair
+func parseUsedTotal(value string) (uint64, uint64, error) {
+	pair := strings.Split(value, "/")
+	if len(pair) != 2 {
+		return 0, 0, fmt.Errorf("invalid used/total value: %s", value)
+	}
+	used, err := strconv.ParseUint(pair[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	total, err := strconv.ParseUint(pair[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return used, total, nil
+}
+
+// parseThinProvisioned sums up the size in bytes of all the thin devices
+// of the pool with the given major:minor number in the output of dmsetup table.
+// A sample line for a thin device looks like
+// devlocalpv-pvc-2f5d1b4e-1d7e-4c2b-9f1a-5f8e1a2b3c4d: 0 2097152 thin 253:0 42
+func parseThinProvisioned(table string, poolDevNum string) uint64 {
+	var provisioned uint64
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[3] != "thin" || fields[4] != poolDevNum {
+			continue
+		}
+		sectors, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		provisioned += sectors * sectorSize
+	}
+	return provisioned
+}
+
+// findThinPool returns the active pool where a thin volume of the given
+// capacity should be created. The pools with least provisioned space relative
+// to their size are tried first, a pool is grown if the volume would exceed
+// its over provisioning ratio. A new pool is created if the device doesn't
+// have any.
+func findThinPool(diskMetaName string, capacity uint64, ratio float64) (*thinPool, error) {
+	pools, err := listThinPools(diskMetaName)
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) == 0 {
+		return createThinPool(diskMetaName, getThinPoolRequiredSize(0, capacity, ratio))
+	}
+
+	var usages []ThinPoolUsage
+	var active []*thinPool
+	for i := range pools {
+		if err = activateThinPool(&pools[i]); err != nil {
+			klog.Errorf("could not activate thin pool %s: %v", pools[i].Name, err)
+			continue
+		}
+		usage, err := getThinPoolUsage(&pools[i])
+		if err != nil || usage.DataSize == 0 {
+			klog.Errorf("could not get usage of thin pool %s: %v", pools[i].Name, err)
+			continue
+		}
+		usages = append(usages, usage)
+		active = append(active, &pools[i])
+	}
+	if len(active) == 0 {
+		return nil, fmt.Errorf("could not find an active thin pool on disk name: %s", diskMetaName)
+	}
+	order := make([]int, len(active))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return float64(usages[order[i]].Provisioned)/float64(usages[order[i]].DataSize) <
+			float64(usages[order[j]].Provisioned)/float64(usages[order[j]].DataSize)
+	})
+
+	for _, i := range order {
+		required := getThinPoolRequiredSize(usages[i].Provisioned, capacity, ratio)
+		if required <= usages[i].DataSize {
+			return active[i], nil
+		}
+		if err = growThinPool(active[i], diskMetaName, required); err != nil {
+			klog.Warningf("thin pool %s has no room for %d bytes: %v", active[i].Name, capacity, err)
+			continue
+		}
+		return active[i], nil
+	}
+	return nil, fmt.Errorf("no thin pool on disk name %s has room for %d bytes with the over provisioning ratio %v",
+		diskMetaName, capacity, ratio)
+}
+
+// getThinPoolRequiredSize returns the data size a pool needs for the thin
+// volumes of the provisioned size and a new one of the given capacity,
+// as per the over provisioning ratio.
+func getThinPoolRequiredSize(provisioned uint64, capacity uint64, ratio float64) uint64 {
+	return uint64(math.Ceil(float64(provisioned+capacity) / ratio))
+}
+
+// getOverProvisioningRatio returns the over provisioning ratio of the pool
+// of the thin volume, the pools of the volumes without ratio can't be
+// over-provisioned.
+func getOverProvisioningRatio(vol *apis.DeviceVolume) (float64, error) {
+	if vol.Spec.OverProvisioningRatio == "" {
+		return 1, nil
+	}
+	ratio, err := strconv.ParseFloat(vol.Spec.OverProvisioningRatio, 64)
+	if err != nil || ratio < 1 {
+		return 0, fmt.Errorf("invalid over provisioning ratio %q", vol.Spec.OverProvisioningRatio)
+	}
+	return ratio, nil
+}
+
+// getThinPoolByName returns the pool with the given device mapper name
+func getThinPoolByName(diskMetaName string, poolName string) (*thinPool, error) {
+	pools, err := listThinPools(diskMetaName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pools {
+		if pools[i].Name == poolName {
+			return &pools[i], nil
+		}
+	}
+	return nil, fmt.Errorf("thin pool %s not found", poolName)
+}
+
+// createThinDevice creates a new thin device in the pool and returns its id.
+// The first id tried is derived from the volume name, so the ids are spread
+// over the whole id space.
+func createThinDevice(poolName string, volumeName string) (uint32, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(volumeName))
+	start := h.Sum32() % maxThinDeviceID
+	for i := uint32(0); i < thinDeviceIDRetries; i++ {
+		id := (start+i)%maxThinDeviceID + 1
+		_, err := RunCommand(strings.Split(fmt.Sprintf(DMSetupMessage, poolName,
+			fmt.Sprintf("create_thin %d", id)), " "))
+		if err == nil {
+			return id, nil
+		}
+		klog.Warningf("could not create thin device %d in pool %s, trying next id", id, poolName)
+	}
+	return 0, fmt.Errorf("could not create thin device in pool %s", poolName)
+}
+
+// activateThinDevice activates the device mapper device of a thin volume
+func activateThinDevice(name string, poolName string, id uint32, sizeBytes uint64) error {
+	table := fmt.Sprintf("0 %d thin /dev/mapper/%s %d", sizeBytes/sectorSize, poolName, id)
+	klog.Infof("Activating thin volume %s: %s", name, table)
+	_, err := RunCommand(append(strings.Split(fmt.Sprintf(DMSetupCreate, name), " "), table))
+	return err
+}
+
+// createThinVolume creates a thin volume in the dm-thin pool of the device
+// and records the pool name and thin device id in the volume status.
+func createThinVolume(vol *apis.DeviceVolume) error {
+	capacityBytes, err := strconv.ParseUint(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		klog.Warning("error parsing vol.Spec.Capacity. Skipping CreateVolume", err)
+		return err
+	}
+
+	name := getThinVolumeName(vol.Name)
+	if dmDeviceExists(name) {
+		if vol.Status.ThinDeviceID != 0 {
+			klog.Infof("Thin volume %s already exist, Skipping creation", name)
+			return nil
+		}
+		// the volume status could not be recorded after creating the thin
+		// volume, recover the pool and the thin device id from its table.
+		return recoverThinVolume(vol, name)
+	}
+
+	ratio, err := getOverProvisioningRatio(vol)
+	if err != nil {
+		return err
+	}
+
+	thinPoolMtx.Lock()
+	defer thinPoolMtx.Unlock()
+	pool, err := findThinPool(vol.Spec.DevName, capacityBytes, ratio)
+	if err != nil {
+		klog.Errorf("findThinPool Failed: %v", err)
+		return &apis.VolumeError{
+			Code:    apis.InsufficientCapacity,
+			Message: err.Error(),
+		}
+	}
+
+	id, err := createThinDevice(pool.Name, vol.Name)
+	if err != nil {
+		return err
+	}
+	if err = activateThinDevice(name, pool.Name, id, capacityBytes); err != nil {
+		_, err1 := RunCommand(strings.Split(fmt.Sprintf(DMSetupMessage, pool.Name,
+			fmt.Sprintf("delete %d", id)), " "))
+		if err1 != nil {
+			klog.Errorf("could not delete thin device %d in pool %s, created during CreateVolume(). Error: %s",
+				id, pool.Name, err1)
+		}
+		return err
+	}
+
+	vol.Status.ThinPool = pool.Name
+	vol.Status.ThinDeviceID = id
+	return nil
+}
+
+// recoverThinVolume records the pool name and the thin device id of an
+// active thin volume in the volume status.
+func recoverThinVolume(vol *apis.DeviceVolume, name string) error {
+	out, err := RunCommand([]string{"dmsetup", "table", name})
+	if err != nil {
+		return err
+	}
+	// the table of a thin device looks like "0 2097152 thin 253:0 42"
+	fields := strings.Fields(out)
+	if len(fields) < 5 || fields[2] != "thin" {
+		return fmt.Errorf("invalid table of thin volume %s: %s", name, out)
+	}
+	id, err := strconv.ParseUint(fields[4], 10, 32)
+	if err != nil {
+		return err
+	}
+	pools, err := listThinPools(vol.Spec.DevName)
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		devNum, err := RunCommand(strings.Split(fmt.Sprintf(DMSetupDevNum, pool.Name), " "))
+		if err == nil && strings.TrimSpace(devNum) == fields[3] {
+			klog.Infof("Recovered thin volume %s, pool: %s, id: %d", name, pool.Name, id)
+			vol.Status.ThinPool = pool.Name
+			vol.Status.ThinDeviceID = uint32(id)
+			return nil
+		}
+	}
+	return fmt.Errorf("could not find the pool of thin volume %s", name)
+}
+
+// activateThinVolume activates the pool and the thin volume, in case they are
+// not active, e.g. after a node reboot.
+func activateThinVolume(vol *apis.DeviceVolume) error {
+	name := getThinVolumeName(vol.Name)
+	if dmDeviceExists(name) {
+		return nil
+	}
+	if vol.Status.ThinDeviceID == 0 {
+		return errors.New("Thin volume not found")
+	}
+	capacityBytes, err := strconv.ParseUint(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return err
+	}
+	pool, err := getThinPoolByName(vol.Spec.DevName, vol.Status.ThinPool)
+	if err != nil {
+		return err
+	}
+	if err = activateThinPool(pool); err != nil {
+		return err
+	}
+	return activateThinDevice(name, pool.Name, vol.Status.ThinDeviceID, capacityBytes)
+}
+
+// destroyThinVolume deactivates the thin volume and deletes the thin device
+// from the pool, which releases all the blocks allocated by the volume.
+func destroyThinVolume(vol *apis.DeviceVolume) error {
+	name := getThinVolumeName(vol.Name)
+	if dmDeviceExists(name) {
+		if _, err := RunCommand(strings.Split(fmt.Sprintf(DMSetupRemove, name), " ")); err != nil {
+			return err
+		}
+	}
+	if vol.Status.ThinDeviceID == 0 {
+		klog.Infof("%s Thin volume not found, Skipping Deletion", name)
+		return nil
+	}
+
+	pool, err := getThinPoolByName(vol.Spec.DevName, vol.Status.ThinPool)
+	if err != nil {
+		klog.Warningf("Skipping deletion of thin device %d: %v", vol.Status.ThinDeviceID, err)
+		return nil
+	}
+	if err = activateThinPool(pool); err != nil {
+		return err
+	}
+	_, err = RunCommand(strings.Split(fmt.Sprintf(DMSetupMessage, pool.Name,
+		fmt.Sprintf("delete %d", vol.Status.ThinDeviceID)), " "))
+	return err
+}
+
+// getThinVolumeDevPath returns the device mapper path of the thin volume
+func getThinVolumeDevPath(vol *apis.DeviceVolume) (string, error) {
+	if err := activateThinVolume(vol); err != nil {
+		klog.Errorf("could not activate thin volume %s: %v", vol.Name, err)
+		return "", err
+	}
+	return "/dev/mapper/" + getThinVolumeName(vol.Name), nil
+}
+
+// ListThinPools lists the usage of all the dm-thin pools created by plugin.
+func ListThinPools() ([]ThinPoolUsage, error) {
+	diskList, err := getDiskList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disk: %v", err)
+	}
+	var result []ThinPoolUsage
+	for _, disk := range diskList {
+		usage, ok := getDiskThinPoolUsage(disk.DiskPath)
+		if ok {
+			result = append(result, usage)
+		}
+	}
+	return result, nil
+}
+
+// getDiskThinPoolUsage returns the usage of the pool on the disk, activating
+// it if required. The boolean is false if the disk doesn't hold a usable pool.
+func getDiskThinPoolUsage(diskPath string) (ThinPoolUsage, bool) {
+	metaName, err := getDiskMetaName(diskPath)
+	if err != nil {
+		return ThinPoolUsage{}, false
+	}
+	pool, err := getThinPoolOnDisk(diskPath, metaName)
+	if err != nil || pool == nil {
+		return ThinPoolUsage{}, false
+	}
+	if err = activateThinPool(pool); err != nil {
+		klog.Errorf("could not activate thin pool %s: %v", pool.Name, err)
+		return ThinPoolUsage{}, false
+	}
+	usage, err := getThinPoolUsage(pool)
+	if err != nil {
+		klog.Errorf("could not get usage of thin pool %s: %v", pool.Name, err)
+		return ThinPoolUsage{}, false
+	}
+	usage.DeviceName = metaName
+	return usage, true
+}
@@ -0,0 +1,121 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"testing"
+)
+
+func Test_parseThinPoolStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    [4]uint64
+		wantErr bool
+	}{
+		{
+			name: "active pool",
+			args: "0 2097152 thin-pool 1 178/4096 2038/16384 - rw no_discard_passdown queue_if_no_space - 1024\n",
+			want: [4]uint64{178, 4096, 2038, 16384},
+		},
+		{
+			name:    "not a thin pool",
+			args:    "0 2097152 linear \n",
+			wantErr: true,
+		},
+		{
+			name:    "failed pool",
+			args:    "0 2097152 thin-pool Fail\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metaUsed, metaTotal, dataUsed, dataTotal, err := parseThinPoolStatus(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseThinPoolStatus() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := [4]uint64{metaUsed, metaTotal, dataUsed, dataTotal}; !tt.wantErr && got != tt.want {
+				t.Errorf("parseThinPoolStatus() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseThinProvisioned(t *testing.T) {
+	table := `devlocalpv-3a1b7c0e1f2d4e5f-tpool: 0 20969472 thin-pool 8:18 8:19 1024 1024 1 skip_block_zeroing
+devlocalpv-pvc-1: 0 2097152 thin 253:0 1
+devlocalpv-pvc-2: 0 4194304 thin 253:0 2
+devlocalpv-pvc-3: 0 4194304 thin 253:5 1
+`
+	if got := parseThinProvisioned(table, "253:0"); got != 6291456*512 {
+		t.Errorf("parseThinProvisioned() = %v, want %v", got, 6291456*512)
+	}
+}
+
+func Test_getThinPoolDataSize(t *testing.T) {
+	defer func() { DeviceConfiguration.ThinPoolSize = 0 }()
+
+	tests := []struct {
+		name       string
+		configured uint64
+		dataSize   uint64
+		want       uint64
+		wantErr    bool
+	}{
+		{name: "sized for the first volume", dataSize: 10*mib + 1, want: 11},
+		{name: "configured size", configured: 100 * mib, dataSize: 10 * mib, want: 100},
+		{name: "volume larger than the configured size", configured: 100 * mib, dataSize: 200 * mib, want: 200},
+		{name: "configured size capped to the slot", configured: 2000 * mib, dataSize: 10 * mib, want: 1008},
+		{name: "volume larger than the slot", dataSize: 2000 * mib, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DeviceConfiguration.ThinPoolSize = tt.configured
+			got, err := getThinPoolDataSize(1024, 16, tt.dataSize)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getThinPoolDataSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getThinPoolDataSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getThinPoolRequiredSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		provisioned uint64
+		capacity    uint64
+		ratio       float64
+		want        uint64
+	}{
+		{name: "first volume", capacity: 10 * mib, ratio: 1, want: 10 * mib},
+		{name: "over provisioned pool", provisioned: 30 * mib, capacity: 10 * mib, ratio: 2.5, want: 16 * mib},
+		{name: "rounded up", capacity: 3, ratio: 2, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getThinPoolRequiredSize(tt.provisioned, tt.capacity, tt.ratio); got != tt.want {
+				t.Errorf("getThinPoolRequiredSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -22,6 +22,7 @@ import (
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
@@ -33,6 +34,51 @@ import (
 type DeviceConfig struct {
 	// Compiled Regex to Ignore the Block devices
 	IgnoreBlockDevicesRegex *regexp.Regexp
+
+	// FilePoolDir is the host directory holding the backing files of the file pools
+	FilePoolDir string
+
+	// FilePools are the sparse file backed loop devices managed by the agent
+	FilePools []FilePool
+
+	// ImageDir is the host directory holding the node local disk images
+	ImageDir string
+
+	// PublishDir is the host directory where the access modes of the
+	// publish targets of the volumes are recorded
+	PublishDir string
+
+	// Compiled Regex of the unpartitioned disks which can be claimed
+	// entirely by a volume
+	WholeDiskRegex *regexp.Regexp
+
+	// DiscardInterval is the interval between the trims of the volumes
+	// with periodic discard, zero disables the periodic trims
+	DiscardInterval time.Duration
+
+	// DiscardRateLimit is the number of bytes trimmed per second
+	DiscardRateLimit uint64
+
+	// CgroupRoot is where the cgroup v2 hierarchy of the host is mounted,
+	// the IO limits of the volumes are set in the cgroups of the pods
+	CgroupRoot string
+
+	// OrphanDeleteAfter is the duration after which the partitions
+	// belonging to no volume are deleted, zero disables the deletion
+	OrphanDeleteAfter time.Duration
+
+	// InstanceID identifies the driver instance, it is recorded in
+	// the journals of the disks
+	InstanceID string
+
+	// ThinPoolSize is the size in bytes of the data partition of the new
+	// dm-thin pools, zero sizes them for their first volume. The pools
+	// grow into the free space following them when needed.
+	ThinPoolSize uint64
+
+	// DriverName is the name of the CSI driver, the PersistentVolumes
+	// of the undeleted volumes are provisioned by it
+	DriverName string
 }
 
 const (
@@ -56,10 +102,34 @@ const (
 	DeviceStatusFailed string = "Failed"
 	// DeviceStatusReady shows object has been processed
 	DeviceStatusReady string = "Ready"
+	// DeviceStatusReleased shows the volume was deleted and its
+	// partition is kept in the recycle bin
+	DeviceStatusReleased string = "Released"
 	// OpenEBSCasTypeKey for the cas-type label
 	OpenEBSCasTypeKey string = "openebs.io/cas-type"
 	// LocalDeviceCasTypeName for the name of the cas-type
 	LocalDeviceCasTypeName string = "localpv-device"
+	// EphemeralVolumeKey is the label set on the DeviceVolume CRs
+	// created for CSI ephemeral inline volumes
+	EphemeralVolumeKey string = "openebs.io/ephemeral"
+	// ImageSourceKey is the PVC annotation holding the disk image
+	// the volume is populated with
+	ImageSourceKey string = "openebs.io/image-source"
+	// PVCNameKey is the DeviceVolume annotation holding the name
+	// of the claim the volume was provisioned for
+	PVCNameKey string = "openebs.io/pvc-name"
+	// PVCNamespaceKey is the DeviceVolume annotation holding the
+	// namespace of the claim the volume was provisioned for
+	PVCNamespaceKey string = "openebs.io/pvc-namespace"
+	// UndeleteKey is the DeviceVolume annotation restoring a Released
+	// volume, its value is the namespace/name of the new claim
+	UndeleteKey string = "openebs.io/undelete"
+	// AllocationPartition allocates a dedicated partition for the volume
+	AllocationPartition string = "partition"
+	// AllocationThin allocates a thin volume from the dm-thin pool of the device
+	AllocationThin string = "thin"
+	// AllocationWholeDisk dedicates an entire unpartitioned disk to the volume
+	AllocationWholeDisk string = "wholeDisk"
 )
 
 var (
@@ -124,6 +194,15 @@ func GetDeviceVolume(volumeID string) (*apis.DeviceVolume, error) {
 	return vol, err
 }
 
+// GetVolumeMode returns the mode of the volume, the volumes provisioned
+// without mode are filesystem volumes
+func GetVolumeMode(vol *apis.DeviceVolume) corev1.PersistentVolumeMode {
+	if vol.Spec.VolumeMode == string(corev1.PersistentVolumeBlock) {
+		return corev1.PersistentVolumeBlock
+	}
+	return corev1.PersistentVolumeFilesystem
+}
+
 // GetDeviceVolumeState returns DeviceVolume OwnerNode and State for
 // the given volume. CreateVolume request may call it again and
 // again until volume is "Ready".
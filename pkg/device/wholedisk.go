@@ -0,0 +1,266 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openebs/lib-csi/pkg/common/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+)
+
+// DiskSignatureList lists the filesystem and partition table signatures of a disk
+const DiskSignatureList = "wipefs --noheadings %s"
+
+// diskByIDDir holds the stable names of the disks
+const diskByIDDir = "/dev/disk/by-id"
+
+// wholeDisk represents an unpartitioned disk which can be dedicated to a volume
+type wholeDisk struct {
+	// Name is the stable name of the disk, see getDiskStableName
+	Name string
+	// DiskPath is the kernel name of the disk (e.g. sdc)
+	DiskPath string
+	// Size of the disk in bytes
+	Size uint64
+	// ClaimedBy is the name of the volume owning the disk
+	ClaimedBy string
+}
+
+// listWholeDisks lists the disks matching the whole disk regex, which are either
+// claimed by a volume or blank. Disks carrying any signature, which are not
+// claimed, may hold somebody's data and are left alone.
+func listWholeDisks() ([]wholeDisk, error) {
+	if DeviceConfiguration.WholeDiskRegex == nil {
+		return nil, nil
+	}
+	diskList, err := getDiskList()
+	if err != nil {
+		return nil, err
+	}
+	claims, err := getWholeDiskClaims()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []wholeDisk
+	for _, disk := range diskList {
+		if !DeviceConfiguration.WholeDiskRegex.MatchString(disk.DiskPath) {
+			continue
+		}
+		name := getDiskStableName(disk.DiskPath)
+		wd := wholeDisk{
+			Name:      name,
+			DiskPath:  disk.DiskPath,
+			Size:      disk.Size,
+			ClaimedBy: claims[name],
+		}
+		if wd.ClaimedBy == "" {
+			blank, err := isBlankDisk(disk.DiskPath)
+			if err != nil {
+				klog.Errorf("Device LocalPV: could not list signatures of disk %s: %v", disk.DiskPath, err)
+				continue
+			}
+			if !blank {
+				klog.V(4).Infof("Device LocalPV: skipping whole disk %s, it is not blank", disk.DiskPath)
+				continue
+			}
+		}
+		result = append(result, wd)
+	}
+	return result, nil
+}
+
+// getWholeDiskClaims returns the disks claimed by the volumes of this node
+// mapped to the volume names.
+func getWholeDiskClaims() (map[string]string, error) {
+	vols, err := volbuilder.NewKubeclient().
+		WithNamespace(DeviceNamespace).
+		List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]string{}
+	for _, vol := range vols.Items {
+		if vol.Spec.OwnerNodeID != NodeID ||
+			vol.Spec.Allocation != AllocationWholeDisk ||
+			vol.Status.ClaimedDisk == "" {
+			continue
+		}
+		claims[vol.Status.ClaimedDisk] = vol.Name
+	}
+	return claims, nil
+}
+
+// isBlankDisk checks that the disk doesn't have any filesystem, raid or
+// partition table signature on it.
+func isBlankDisk(diskPath string) (bool, error) {
+	out, err := RunCommand(strings.Split(fmt.Sprintf(DiskSignatureList, "/dev/"+diskPath), " "))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) == "", nil
+}
+
+// getDiskStableName returns the /dev/disk/by-id name of the disk, which
+// doesn't change across reboots unlike the kernel name. The kernel name
+// is returned for the disks without any by-id link, e.g. loop devices.
+func getDiskStableName(diskPath string) string {
+	entries, err := os.ReadDir(diskByIDDir)
+	if err != nil {
+		return diskPath
+	}
+	var names []string
+	for _, entry := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join(diskByIDDir, entry.Name()))
+		if err == nil && target == "/dev/"+diskPath {
+			names = append(names, entry.Name())
+		}
+	}
+	if name := pickStableName(names); name != "" {
+		return name
+	}
+	return diskPath
+}
+
+// pickStableName picks the most descriptive one out of the by-id names of a
+// disk. The names containing the model and the serial (e.g. ata-*, nvme-*)
+// are preferred over the plain wwn and eui ones.
+func pickStableName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(name, "wwn-") && !strings.HasPrefix(name, "nvme-eui.") {
+			return name
+		}
+	}
+	return names[0]
+}
+
+// resolveWholeDisk returns the device path of the disk with the given stable name
+func resolveWholeDisk(name string) (string, error) {
+	if path, err := filepath.EvalSymlinks(filepath.Join(diskByIDDir, name)); err == nil {
+		return path, nil
+	}
+	path := "/dev/" + name
+	if _, err := os.Stat(path); err != nil {
+		return "", errors.Errorf("disk %s not found", name)
+	}
+	return path, nil
+}
+
+// createWholeDiskVolume claims the smallest blank disk matching the device
+// name which can hold the volume. The claim is recorded in the volume status.
+func createWholeDiskVolume(vol *apis.DeviceVolume) error {
+	if vol.Status.ClaimedDisk != "" {
+		klog.Infof("Disk %s already claimed by %s, Skipping creation", vol.Status.ClaimedDisk, vol.Name)
+		return nil
+	}
+	capacityBytes, err := strconv.ParseUint(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		klog.Warning("error parsing vol.Spec.Capacity. Skipping CreateVolume", err)
+		return err
+	}
+	devRegex, err := regexp.Compile(vol.Spec.DevName)
+	if err != nil {
+		return err
+	}
+	disks, err := listWholeDisks()
+	if err != nil {
+		klog.Errorf("listWholeDisks failed %s", err)
+		return err
+	}
+
+	var best *wholeDisk
+	for i, disk := range disks {
+		if disk.ClaimedBy != "" || disk.Size < capacityBytes || !devRegex.MatchString(disk.Name) {
+			continue
+		}
+		if best == nil || disk.Size < best.Size {
+			best = &disks[i]
+		}
+	}
+	if best == nil {
+		return &apis.VolumeError{
+			Code:    apis.InsufficientCapacity,
+			Message: fmt.Sprintf("could not find a blank disk of %d bytes for disk name: %s", capacityBytes, vol.Spec.DevName),
+		}
+	}
+	klog.Infof("Claiming disk %s (/dev/%s) for volume %s", best.Name, best.DiskPath, vol.Name)
+	vol.Status.ClaimedDisk = best.Name
+	return nil
+}
+
+// destroyWholeDiskVolume wipes the signatures of the claimed disk, so that
+// it becomes blank and claimable again.
+func destroyWholeDiskVolume(vol *apis.DeviceVolume) error {
+	if vol.Status.ClaimedDisk == "" {
+		klog.Infof("%s Whole disk not claimed, Skipping Deletion", vol.Name)
+		return nil
+	}
+	path, err := resolveWholeDisk(vol.Status.ClaimedDisk)
+	if err != nil {
+		klog.Warningf("Skipping wipe of the disk claimed by %s: %v", vol.Name, err)
+		return nil
+	}
+	klog.Infof("Running WipeFS for disk: %s", path)
+	_, err = RunCommand(strings.Split(fmt.Sprintf(PartitionWipeFS, path), " "))
+	return err
+}
+
+// getWholeDiskDevPath returns the path of the disk claimed by the volume
+func getWholeDiskDevPath(vol *apis.DeviceVolume) (string, error) {
+	if vol.Status.ClaimedDisk == "" {
+		return "", errors.New("Whole disk not claimed")
+	}
+	return resolveWholeDisk(vol.Status.ClaimedDisk)
+}
+
+// getWholeDiskDetails returns the whole disks as devices, the claimed ones
+// don't have any free capacity as the disk is an indivisible unit.
+func getWholeDiskDetails(disks []wholeDisk) []apis.Device {
+	var result []apis.Device
+	for _, disk := range disks {
+		size := *resource.NewQuantity(int64(disk.Size), resource.BinarySI)
+		free := size
+		if disk.ClaimedBy != "" {
+			free = *resource.NewQuantity(0, resource.BinarySI)
+		}
+		result = append(result, apis.Device{
+			Name:      disk.Name,
+			UUID:      disk.Name,
+			Size:      size,
+			Free:      free,
+			WholeDisk: true,
+			ClaimedBy: disk.ClaimedBy,
+		})
+	}
+	return result
+}
@@ -0,0 +1,57 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"testing"
+)
+
+func Test_pickStableName(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{
+			name:  "no by-id link",
+			names: nil,
+			want:  "",
+		},
+		{
+			name:  "descriptive name preferred over wwn",
+			names: []string{"wwn-0x5000c500a1b2c3d4", "scsi-35000c500a1b2c3d4", "ata-ST4000NM0035_ZC11ABCD"},
+			want:  "ata-ST4000NM0035_ZC11ABCD",
+		},
+		{
+			name:  "descriptive name preferred over eui",
+			names: []string{"nvme-eui.0025388b71b2c3d4", "nvme-Samsung_SSD_970_EVO_S4EWNX0N123456"},
+			want:  "nvme-Samsung_SSD_970_EVO_S4EWNX0N123456",
+		},
+		{
+			name:  "only wwn",
+			names: []string{"wwn-0x5000c500a1b2c3d4"},
+			want:  "wwn-0x5000c500a1b2c3d4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickStableName(tt.names); got != tt.want {
+				t.Errorf("pickStableName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
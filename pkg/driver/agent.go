@@ -17,9 +17,11 @@ limitations under the License.
 package driver
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
@@ -31,6 +33,7 @@ import (
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
@@ -42,12 +45,15 @@ import (
 	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
 	"github.com/openebs/device-localpv/pkg/device"
 	"github.com/openebs/device-localpv/pkg/mgmt/devicenode"
+	"github.com/openebs/device-localpv/pkg/mgmt/orphan"
 	"github.com/openebs/device-localpv/pkg/mgmt/volume"
 )
 
 // node is the server implementation
 // for CSI NodeServer
 type node struct {
+	csi.UnimplementedNodeServer
+
 	driver *CSIDriver
 }
 
@@ -59,6 +65,29 @@ func NewNode(d *CSIDriver) csi.NodeServer {
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
+	// attach the file pools before the devices on the node are listed
+	if err := device.SetupFilePools(); err != nil {
+		klog.Fatalf("Failed to setup file pools: %s", err.Error())
+	}
+
+	if err := device.SetupEventRecorder(); err != nil {
+		klog.Fatalf("Failed to setup event recorder: %s", err.Error())
+	}
+
+	if err := device.SetupVolumeLister(stopCh); err != nil {
+		klog.Fatalf("Failed to setup the device volume lister: %s", err.Error())
+	}
+
+	if err := device.SetupInstanceID(d.config.DriverName); err != nil {
+		klog.Fatalf("Failed to setup the instance id: %s", err.Error())
+	}
+
+	// complete the partition operations interrupted by a restart
+	// before the volumes are reconciled
+	if err := device.ReplayJournals(); err != nil {
+		klog.Errorf("Failed to replay the journals of the disks: %s", err.Error())
+	}
+
 	// start the device node resource watcher
 	go func() {
 		err := devicenode.Start(&ControllerMutex, stopCh)
@@ -75,8 +104,23 @@ func NewNode(d *CSIDriver) csi.NodeServer {
 		}
 	}()
 
+	// trim the volumes with periodic discard
+	go device.RunPeriodicTrim(stopCh)
+
+	// keep the IO limits of the pods consuming throttled volumes
+	go device.RunIOThrottle(stopCh)
+
+	// report the partitions and the volumes which don't match
+	go func() {
+		err := orphan.Start(stopCh)
+		if err != nil {
+			klog.Fatalf("Failed to start orphan reconciler: %s", err.Error())
+		}
+	}()
+
 	if d.config.ListenAddress != "" {
-		exposeMetrics(d.config, stopCh)
+		exposeMetrics(d.config, append(collector.NodeCollectors(),
+			collector.NewDeviceCollector(stopCh))...)
 	}
 
 	return &node{
@@ -90,10 +134,14 @@ func (p *promErrorLog) Println(v ...interface{}) {
 	klog.Errorln(v...)
 }
 
-func exposeMetrics(c *config.Config, stopCh <-chan struct{}) {
+// exposeMetrics serves the metrics of the given collectors on the listen
+// address, it is used by both the node agent and the controller.
+func exposeMetrics(c *config.Config, cs ...prometheus.Collector) {
 	registry := prometheus.NewRegistry()
-	if err := registry.Register(collector.NewDeviceCollector(stopCh)); err != nil {
-		klog.Fatalf("failed to register device metrics collector: %v", err)
+	for _, col := range cs {
+		if err := registry.Register(col); err != nil {
+			klog.Fatalf("failed to register device metrics collector: %v", err)
+		}
 	}
 	if !c.DisableExporterMetrics {
 		if err := registry.Register(collectors.NewProcessCollector(
@@ -124,19 +172,31 @@ func exposeMetrics(c *config.Config, stopCh <-chan struct{}) {
 	}()
 }
 
-// GetVolAndMountInfo get volume and mount info from node csi volume request
-func GetVolAndMountInfo(
-	req *csi.NodePublishVolumeRequest,
-) (*apis.DeviceVolume, *device.MountInfo, error) {
+// newMountInfo returns the mount info of the volume capability at the
+// given path. The volume is mounted read only if requested or if its
+// access mode is read only.
+func newMountInfo(capability *csi.VolumeCapability, path string, readonly bool) *device.MountInfo {
 	var mountinfo device.MountInfo
 
-	mountinfo.FSType = req.GetVolumeCapability().GetMount().GetFsType()
-	mountinfo.MountPath = req.GetTargetPath()
-	mountinfo.MountOptions = append(mountinfo.MountOptions, req.GetVolumeCapability().GetMount().GetMountFlags()...)
+	mountinfo.FSType = capability.GetMount().GetFsType()
+	mountinfo.MountPath = path
+	mountinfo.MountOptions = append(mountinfo.MountOptions, capability.GetMount().GetMountFlags()...)
+	mountinfo.VolumeMountGroup = capability.GetMount().GetVolumeMountGroup()
 
-	if req.GetReadonly() {
+	accessMode := capability.GetAccessMode().GetMode()
+	mountinfo.AccessModes = []string{accessMode.String()}
+
+	if readonly || accessMode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY {
 		mountinfo.MountOptions = append(mountinfo.MountOptions, "ro")
 	}
+	return &mountinfo
+}
+
+// GetVolAndMountInfo get volume and mount info from node csi volume request
+func GetVolAndMountInfo(
+	req *csi.NodePublishVolumeRequest,
+) (*apis.DeviceVolume, *device.MountInfo, error) {
+	mountinfo := newMountInfo(req.GetVolumeCapability(), req.GetTargetPath(), req.GetReadonly())
 
 	volName := strings.ToLower(req.GetVolumeId())
 
@@ -149,7 +209,7 @@ func GetVolAndMountInfo(
 		return nil, nil, err
 	}
 
-	return vol, &mountinfo, nil
+	return vol, mountinfo, nil
 }
 
 // NodePublishVolume publishes (mounts) the volume
@@ -169,22 +229,50 @@ func (ns *node) NodePublishVolume(
 		return nil, err
 	}
 
+	if isEphemeralVolume(req) {
+		if err = ns.createEphemeralVolume(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
 	vol, mountInfo, err := GetVolAndMountInfo(req)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// the publications of the volume are checked and recorded at once
+	unlock := device.LockVolume(vol.Name)
+	defer unlock()
+
+	start := time.Now()
 	switch req.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Mount:
-		err = device.MountFilesystem(vol, mountInfo)
+		// ephemeral inline volumes are not staged by kubelet
+		if stagingPath := req.GetStagingTargetPath(); stagingPath != "" {
+			err = device.BindMountVolume(vol, stagingPath, mountInfo)
+		} else {
+			err = device.MountFilesystem(vol, mountInfo)
+		}
 	case *csi.VolumeCapability_Block:
 		err = device.MountBlock(vol, mountInfo)
 	}
+	collector.ObserveOperation(collector.OperationMount, start, err)
 
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if err = device.RecordPublication(vol, mountInfo); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not record the publication of volume %s: %v", vol.Name, err)
+	}
+	device.RecordPublished(vol.Name, true)
+
+	if err = device.ThrottleVolume(vol, mountInfo.MountPath); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not set the IO limits of volume %s: %v", vol.Name, err)
+	}
+
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
@@ -209,13 +297,33 @@ func (ns *node) NodeUnpublishVolume(
 	targetPath := req.GetTargetPath()
 	volumeID := req.GetVolumeId()
 
+	unlock := device.LockVolume(strings.ToLower(volumeID))
+	defer unlock()
+
 	if vol, err = device.GetDeviceVolume(volumeID); err != nil {
+		// an ephemeral volume is gone once it has been unpublished, and
+		// the DeviceVolume of any volume may have been force deleted
+		if k8serror.IsNotFound(err) {
+			klog.Infof("volume %s not found, cleaning up %s", volumeID, targetPath)
+			if err = device.CleanupMountPoint(targetPath); err != nil {
+				return nil, status.Errorf(codes.Internal,
+					"unable to clean up the mount point %s of volume %s err : %s",
+					targetPath, volumeID, err.Error())
+			}
+			if err = device.RemovePublication(strings.ToLower(volumeID), targetPath); err != nil {
+				return nil, status.Errorf(codes.Internal,
+					"could not remove the publication of volume %s: %v", volumeID, err)
+			}
+			return &csi.NodeUnpublishVolumeResponse{}, nil
+		}
 		return nil, status.Errorf(codes.Internal,
 			"not able to get the DeviceVolume %s err : %s",
 			volumeID, err.Error())
 	}
 
+	start := time.Now()
 	err = device.UmountVolume(vol, targetPath)
+	collector.ObserveOperation(collector.OperationUnmount, start, err)
 
 	if err != nil {
 		return nil, status.Errorf(codes.Internal,
@@ -225,6 +333,18 @@ func (ns *node) NodeUnpublishVolume(
 	klog.Infof("hostpath: volume %s path: %s has been unmounted.",
 		volumeID, targetPath)
 
+	if err = device.RemovePublication(vol.Name, targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not remove the publication of volume %s: %v", volumeID, err)
+	}
+	device.RecordPublished(vol.Name, false)
+
+	if isEphemeralDeviceVolume(vol) {
+		if err = deleteEphemeralVolume(ctx, vol); err != nil {
+			return nil, err
+		}
+	}
+
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 
 }
@@ -298,15 +418,42 @@ func (ns *node) NodeGetCapabilities(
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+					},
+				},
+			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+					},
+				},
+			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
-// TODO
-// This needs to be implemented
-//
-// NodeStageVolume mounts the volume on the staging
-// path
+// NodeStageVolume formats the volume, if required, and mounts
+// it on the staging path. The volume is then bind mounted on
+// the target path of every pod using it in NodePublishVolume.
+// Block volumes don't need to be staged.
 //
 // This implements csi.NodeServer
 func (ns *node) NodeStageVolume(
@@ -314,11 +461,37 @@ func (ns *node) NodeStageVolume(
 	req *csi.NodeStageVolumeRequest,
 ) (*csi.NodeStageVolumeResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if err := ns.validateNodeStageReq(req); err != nil {
+		return nil, err
+	}
+
+	if req.GetVolumeCapability().GetMount() == nil {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	volumeID := strings.ToLower(req.GetVolumeId())
+	vol, err := device.GetDeviceVolume(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"not able to get the DeviceVolume %s err : %s",
+			volumeID, err.Error())
+	}
+
+	mountInfo := newMountInfo(req.GetVolumeCapability(), req.GetStagingTargetPath(), false)
+	start := time.Now()
+	err = device.StageVolume(vol, mountInfo)
+	collector.ObserveOperation(collector.OperationMount, start, err)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not stage volume %s at %s: %v", volumeID, mountInfo.MountPath, err)
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
 }
 
 // NodeUnstageVolume unmounts the volume from
-// the staging path
+// the staging path, once it is not published
+// anymore
 //
 // This implements csi.NodeServer
 func (ns *node) NodeUnstageVolume(
@@ -326,7 +499,38 @@ func (ns *node) NodeUnstageVolume(
 	req *csi.NodeUnstageVolumeRequest,
 ) (*csi.NodeUnstageVolumeResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if err := ns.validateNodeUnstageReq(req); err != nil {
+		return nil, err
+	}
+
+	volumeID := strings.ToLower(req.GetVolumeId())
+	stagingPath := req.GetStagingTargetPath()
+
+	vol, err := device.GetDeviceVolume(volumeID)
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			klog.Infof("volume %s not found, cleaning up %s", volumeID, stagingPath)
+			if err = device.CleanupMountPoint(stagingPath); err != nil {
+				return nil, status.Errorf(codes.Internal,
+					"unable to clean up the staging path %s of volume %s err : %s",
+					stagingPath, volumeID, err.Error())
+			}
+			return &csi.NodeUnstageVolumeResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Internal,
+			"not able to get the DeviceVolume %s err : %s",
+			volumeID, err.Error())
+	}
+
+	start := time.Now()
+	err = device.UnstageVolume(vol, stagingPath)
+	collector.ObserveOperation(collector.OperationUnmount, start, err)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("volume %s has been unstaged from %s", volumeID, stagingPath)
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 // TODO
@@ -369,9 +573,28 @@ func (ns *node) NodeGetVolumeStats(
 		return nil, status.Error(codes.NotFound, "path is not a mount path")
 	}
 
+	vol, err := device.GetDeviceVolume(strings.ToLower(volID))
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found", volID)
+		}
+		return nil, status.Errorf(codes.Internal,
+			"not able to get the DeviceVolume %s err : %s", volID, err.Error())
+	}
+	cond := device.GetVolumeCondition(vol, path)
+	condition := &csi.VolumeCondition{Abnormal: cond.Abnormal, Message: cond.Message}
+
 	var sfs unix.Statfs_t
 	if err := unix.Statfs(path, &sfs); err != nil {
-		return nil, status.Errorf(codes.Internal, "statfs on %s failed: %v", path, err)
+		// a filesystem shut down on errors fails the statfs, the
+		// condition is more useful to the health monitor than an error
+		klog.Errorf("statfs on %s failed: %v", path, err)
+		return &csi.NodeGetVolumeStatsResponse{
+			VolumeCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("statfs on %s failed: %v", path, err),
+			},
+		}, nil
 	}
 
 	var usage []*csi.VolumeUsage
@@ -388,7 +611,7 @@ func (ns *node) NodeGetVolumeStats(
 		Available: int64(sfs.Ffree),
 	})
 
-	return &csi.NodeGetVolumeStatsResponse{Usage: usage}, nil
+	return &csi.NodeGetVolumeStatsResponse{Usage: usage, VolumeCondition: condition}, nil
 }
 
 func (ns *node) validateNodePublishReq(
@@ -406,6 +629,41 @@ func (ns *node) validateNodePublishReq(
 	return nil
 }
 
+func (ns *node) validateNodeStageReq(
+	req *csi.NodeStageVolumeRequest,
+) error {
+	if req.GetVolumeCapability() == nil {
+		return status.Error(codes.InvalidArgument,
+			"Volume capability missing in request")
+	}
+
+	if len(req.GetVolumeId()) == 0 {
+		return status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	if len(req.GetStagingTargetPath()) == 0 {
+		return status.Error(codes.InvalidArgument,
+			"Staging target path missing in request")
+	}
+	return nil
+}
+
+func (ns *node) validateNodeUnstageReq(
+	req *csi.NodeUnstageVolumeRequest,
+) error {
+	if req.GetVolumeId() == "" {
+		return status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	if req.GetStagingTargetPath() == "" {
+		return status.Error(codes.InvalidArgument,
+			"Staging target path missing in request")
+	}
+	return nil
+}
+
 func (ns *node) validateNodeUnpublishReq(
 	req *csi.NodeUnpublishVolumeRequest,
 ) error {
@@ -0,0 +1,58 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMountInfo(t *testing.T) {
+	newCapability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{
+				FsType:           "ext4",
+				MountFlags:       []string{"noatime"},
+				VolumeMountGroup: "2000",
+			}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+
+	tests := map[string]struct {
+		mode         csi.VolumeCapability_AccessMode_Mode
+		readonly     bool
+		mountOptions []string
+	}{
+		"single writer":      {mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, mountOptions: []string{"noatime"}},
+		"read only request":  {mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, readonly: true, mountOptions: []string{"noatime", "ro"}},
+		"reader access mode": {mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, mountOptions: []string{"noatime", "ro"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mountInfo := newMountInfo(newCapability(test.mode), "/staging", test.readonly)
+			assert.Equal(t, "ext4", mountInfo.FSType)
+			assert.Equal(t, "/staging", mountInfo.MountPath)
+			assert.Equal(t, "2000", mountInfo.VolumeMountGroup)
+			assert.Equal(t, []string{test.mode.String()}, mountInfo.AccessModes)
+			assert.Equal(t, test.mountOptions, mountInfo.MountOptions)
+		})
+	}
+}
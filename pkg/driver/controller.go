@@ -19,6 +19,7 @@ package driver
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -26,7 +27,6 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
 	"github.com/openebs/lib-csi/pkg/common/errors"
-	"github.com/openebs/lib-csi/pkg/common/helpers"
 	"github.com/openebs/lib-csi/pkg/csipv"
 	schd "github.com/openebs/lib-csi/pkg/scheduler"
 	"golang.org/x/net/context"
@@ -35,15 +35,18 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
 
 	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
 	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/device-localpv/pkg/collector"
 	"github.com/openebs/device-localpv/pkg/device"
 	clientset "github.com/openebs/device-localpv/pkg/generated/clientset/internalclientset"
 	informers "github.com/openebs/device-localpv/pkg/generated/informer/externalversions"
@@ -61,13 +64,16 @@ const (
 // controller is the server implementation
 // for CSI Controller
 type controller struct {
+	csi.UnimplementedControllerServer
+
 	driver       *CSIDriver
 	capabilities []*csi.ControllerServiceCapability
 
 	indexedLabel string
 
-	k8sNodeInformer    cache.SharedIndexInformer
-	deviceNodeInformer cache.SharedIndexInformer
+	k8sNodeInformer      cache.SharedIndexInformer
+	deviceNodeInformer   cache.SharedIndexInformer
+	deviceVolumeInformer cache.SharedIndexInformer
 
 	leakProtection *csipv.LeakProtectionController
 }
@@ -84,6 +90,10 @@ func NewController(d *CSIDriver) csi.ControllerServer {
 		klog.Fatalf("init controller: %v", err)
 	}
 
+	if d.config.ListenAddress != "" {
+		exposeMetrics(d.config, collector.ControllerCollectors()...)
+	}
+
 	return ctrl
 }
 
@@ -93,6 +103,15 @@ var SupportedVolumeCapabilityAccessModes = []*csi.VolumeCapability_AccessMode{
 	&csi.VolumeCapability_AccessMode{
 		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+	},
 }
 
 // getRoundedCapacity rounds the capacity on 1024 base
@@ -132,6 +151,7 @@ func waitForDeviceVolume(ctx context.Context,
 	}
 
 	if reschedule {
+		collector.RecordReschedule(vol.Status.Error)
 		// if rescheduling is required, we can deleted the existing device volume object,
 		// so that it can be recreated.
 		if err = device.DeleteVolume(vol.GetName()); err != nil {
@@ -173,6 +193,7 @@ func (cs *controller) init() error {
 
 	cs.k8sNodeInformer = kubeInformerFactory.Core().V1().Nodes().Informer()
 	cs.deviceNodeInformer = openebsInformerfactory.Local().V1alpha1().DeviceNodes().Informer()
+	cs.deviceVolumeInformer = openebsInformerfactory.Local().V1alpha1().DeviceVolumes().Informer()
 
 	if err = cs.deviceNodeInformer.AddIndexers(map[string]cache.IndexFunc{
 		LabelIndexName(cs.indexedLabel): LabelIndexFunc(cs.indexedLabel),
@@ -182,13 +203,15 @@ func (cs *controller) init() error {
 
 	go cs.k8sNodeInformer.Run(stopCh)
 	go cs.deviceNodeInformer.Run(stopCh)
+	go cs.deviceVolumeInformer.Run(stopCh)
 
 	// wait for all the caches to be populated.
-	klog.Info("waiting for k8s & device node informer caches to be synced")
+	klog.Info("waiting for k8s, device node & device volume informer caches to be synced")
 	cache.WaitForCacheSync(stopCh,
 		cs.k8sNodeInformer.HasSynced,
-		cs.deviceNodeInformer.HasSynced)
-	klog.Info("synced k8s & device node informer caches")
+		cs.deviceNodeInformer.HasSynced,
+		cs.deviceVolumeInformer.HasSynced)
+	klog.Info("synced k8s, device node & device volume informer caches")
 
 	klog.Infof("initializing csi provisioning leak protection controller")
 	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
@@ -199,7 +222,9 @@ func (cs *controller) init() error {
 			// use default timeout of 10s for deletion.
 			ctx, cancelCtx := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancelCtx()
-			return cs.deleteVolume(ctx, volumeName)
+			// the leaked volumes never held any data,
+			// they skip the recycle bin
+			return cs.deleteVolume(ctx, volumeName, false)
 		},
 	); err != nil {
 		return errors.Wrap(err, "failed to init leak protection controller")
@@ -209,7 +234,7 @@ func (cs *controller) init() error {
 }
 
 // CreateDeviceVolume create new device volume for csi volume request
-func CreateDeviceVolume(ctx context.Context, req *csi.CreateVolumeRequest,
+func (cs *controller) CreateDeviceVolume(ctx context.Context, req *csi.CreateVolumeRequest,
 	params *VolumeParams) (*apis.DeviceVolume, error) {
 	volName := strings.ToLower(req.GetName())
 	capacity := strconv.FormatInt(getRoundedCapacity(
@@ -244,6 +269,11 @@ func CreateDeviceVolume(ctx context.Context, req *csi.CreateVolumeRequest,
 		}
 	}
 
+	volObj, err := newDeviceVolume(req, params)
+	if err != nil {
+		return nil, err
+	}
+
 	nmap, err := getNodeMap(params.Scheduler, params.DeviceName)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "get node map failed : %s", err.Error())
@@ -251,31 +281,91 @@ func CreateDeviceVolume(ctx context.Context, req *csi.CreateVolumeRequest,
 
 	// run the scheduler
 	selected := schd.Scheduler(req, nmap)
+	if params.Allocation != device.AllocationPartition {
+		selected = cs.filterNodesByCapacity(selected, params,
+			getRoundedCapacity(req.GetCapacityRange().RequiredBytes))
+	}
 
 	if len(selected) == 0 {
+		collector.RecordSchedulerDecision(params.Scheduler, "")
 		return nil, status.Error(codes.Internal, "scheduler failed, not able to select a node to create the PV")
 	}
 
 	owner := selected[0]
+	collector.RecordSchedulerDecision(params.Scheduler, owner)
 	klog.Infof("scheduling the volume %s/%s on node %s", params.DeviceName, volName, owner)
 
-	volObj, err := volbuilder.NewBuilder().
-		WithName(volName).
-		WithCapacity(capacity).
+	volObj.Spec.OwnerNodeID = owner
+
+	vol, err = device.ProvisionVolume(volObj)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "not able to provision the volume %s", err.Error())
+	}
+	vol, _, err = waitForDeviceVolume(ctx, vol)
+	return vol, err
+}
+
+// newDeviceVolume returns the device volume to provision for the csi
+// volume request, without owner node
+func newDeviceVolume(req *csi.CreateVolumeRequest, params *VolumeParams) (*apis.DeviceVolume, error) {
+	fsType, mkfsOptions := params.GetFilesystem(req.GetVolumeCapabilities())
+	if fsType != "" && !device.IsSupportedFsType(fsType) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"unsupported fsType %q, must be one of %v", fsType, device.SupportedFsTypes)
+	}
+	volumeMode := corev1.PersistentVolumeFilesystem
+	for _, c := range req.GetVolumeCapabilities() {
+		if c.GetBlock() != nil {
+			volumeMode = corev1.PersistentVolumeBlock
+		}
+	}
+
+	// the claim is recorded in the journal of the disk along with the
+	// partition, to rebind the volume if the cluster state is lost
+	annotations := map[string]string{}
+	if params.PVCName != "" {
+		annotations[device.PVCNameKey] = params.PVCName
+		annotations[device.PVCNamespaceKey] = params.PVCNamespace
+	}
+
+	// the node checks the room left in the pool before
+	// creating a thin volume
+	var ratio float64
+	if params.Allocation == device.AllocationThin {
+		ratio = params.OverProvisioningRatio
+	}
+
+	vol, err := volbuilder.NewBuilder().
+		WithName(strings.ToLower(req.GetName())).
+		WithCapacity(strconv.FormatInt(getRoundedCapacity(
+			req.GetCapacityRange().RequiredBytes), 10)).
 		WithDeviceName(params.DeviceName).
-		WithOwnerNode(owner).
+		WithAllocation(params.Allocation).
+		WithOverProvisioningRatio(ratio).
+		WithImageSource(params.ImageSource).
+		WithShared(params.Shared).
+		WithVolumeMode(string(volumeMode)).
+		WithFsType(fsType).
+		WithMkfsOptions(mkfsOptions).
+		WithFsckPolicy(params.FsckPolicy).
+		WithFsckFallback(params.FsckFallback).
+		WithDiscard(params.Discard).
+		WithRetention(params.Retention).
+		WithIOLimits(params.IOLimits).
+		WithAnnotations(annotations).
 		WithVolumeStatus(device.DeviceStatusPending).Build()
 
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	vol, err = device.ProvisionVolume(volObj)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "not able to provision the volume %s", err.Error())
+	// the mutable parameters, e.g. of a VolumeAttributesClass, take
+	// precedence over the parameters of the storage class
+	if err = applyMutableParams(&vol.Spec, req.GetMutableParameters()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"invalid mutable parameters: %v", err)
 	}
-	vol, _, err = waitForDeviceVolume(ctx, vol)
-	return vol, err
+	return vol, nil
 }
 
 // CreateVolume provisions a volume
@@ -296,6 +386,10 @@ func (cs *controller) CreateVolume(
 			"failed to parse csi volume params: %v", err)
 	}
 
+	if err = setPVCImageSource(ctx, params); err != nil {
+		return nil, err
+	}
+
 	volName := strings.ToLower(req.GetName())
 	size := getRoundedCapacity(req.GetCapacityRange().GetRequiredBytes())
 	contentSource := req.GetVolumeContentSource()
@@ -313,7 +407,7 @@ func (cs *controller) CreateVolume(
 		return nil, err
 	}
 	defer finishCreateVolume()
-	vol, err = CreateDeviceVolume(ctx, req, params)
+	vol, err = cs.CreateDeviceVolume(ctx, req, params)
 
 	if err != nil {
 		return nil, err
@@ -341,13 +435,15 @@ func (cs *controller) DeleteVolume(
 		return nil, err
 	}
 	volumeID := strings.ToLower(req.GetVolumeId())
-	if err = cs.deleteVolume(ctx, volumeID); err != nil {
+	if err = cs.deleteVolume(ctx, volumeID, true); err != nil {
 		return nil, err
 	}
 	return csipayload.NewDeleteVolumeResponseBuilder().Build(), nil
 }
 
-func (cs *controller) deleteVolume(ctx context.Context, volumeID string) error {
+// deleteVolume deletes the volume, or moves it to the recycle bin when
+// release is set and the volume has a retention.
+func (cs *controller) deleteVolume(ctx context.Context, volumeID string, release bool) error {
 	klog.Infof("received request to delete volume %q", volumeID)
 	vol, err := device.GetDeviceVolume(volumeID)
 	if err != nil {
@@ -361,6 +457,18 @@ func (cs *controller) deleteVolume(ctx context.Context, volumeID string) error {
 	// if volume is not already triggered for deletion, delete the volume.
 	// otherwise, just wait for the existing deletion operation to complete.
 	if vol.GetDeletionTimestamp() == nil {
+		// the node agent purges the released volumes
+		// once their retention is over
+		if release {
+			var released bool
+			if released, err = device.ReleaseVolume(vol); err != nil {
+				return errors.Wrapf(err,
+					"failed to release volume {%s}", volumeID)
+			}
+			if released {
+				return nil
+			}
+		}
 		if err = device.DeleteVolume(volumeID); err != nil {
 			return errors.Wrapf(err,
 				"failed to handle delete volume request for {%s}", volumeID)
@@ -525,8 +633,12 @@ func (cs *controller) GetCapacity(
 	}
 
 	deviceNodeCache := cs.deviceNodeInformer.GetIndexer()
-	params := req.GetParameters()
-	deviceParam := helpers.GetInsensitiveParameter(&params, "devname")
+	params, err := NewVolumeParams(req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"failed to parse csi volume params: %v", err)
+	}
+	deviceParam := params.DeviceName
 
 	var availableCapacity int64
 	for _, nodeName := range nodeNames {
@@ -552,7 +664,7 @@ func (cs *controller) GetCapacity(
 			if !devRegex.MatchString(device.Name) {
 				continue
 			}
-			freeCapacity := device.Free.Value()
+			freeCapacity := getAllocatableCapacity(device, params)
 			if availableCapacity < freeCapacity {
 				availableCapacity = freeCapacity
 			}
@@ -566,6 +678,88 @@ func (cs *controller) GetCapacity(
 	}, nil
 }
 
+// setPVCImageSource overrides the image source of the storage class with the
+// one from the PVC annotation, if any. The image is fetched by the node agent,
+// so the override has to be allowed by the storage class. The PVC is only
+// known when the provisioner passes the extra create metadata.
+func setPVCImageSource(ctx context.Context, params *VolumeParams) error {
+	if params.PVCName == "" || params.PVCNamespace == "" {
+		return nil
+	}
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get k8s clientset: %v", err)
+	}
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(params.PVCNamespace).
+		Get(ctx, params.PVCName, metav1.GetOptions{})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get pvc %s/%s: %v",
+			params.PVCNamespace, params.PVCName, err)
+	}
+	source, ok := pvc.Annotations[device.ImageSourceKey]
+	if !ok {
+		return nil
+	}
+	if params.ImageSourceOverride != "yes" {
+		return status.Errorf(codes.InvalidArgument,
+			"pvc %s/%s sets the image source, which is not allowed by its storage class",
+			params.PVCNamespace, params.PVCName)
+	}
+	params.ImageSource = source
+	return nil
+}
+
+// getAllocatableCapacity returns the size of the largest volume which can be
+// provisioned on the device. For thin volumes it is the room left in the pool
+// as per the over provisioning ratio, a device without pool gets one created
+// out of its largest free slot. A whole disk is either entirely free or not.
+func getAllocatableCapacity(dev apis.Device, params *VolumeParams) int64 {
+	// whole disks can only hold a single whole disk volume
+	if dev.WholeDisk != (params.Allocation == device.AllocationWholeDisk) {
+		return 0
+	}
+	if params.Allocation != device.AllocationThin {
+		return dev.Free.Value()
+	}
+	if dev.ThinPool == nil {
+		return int64(float64(dev.Free.Value()) * params.OverProvisioningRatio)
+	}
+	// the pool grows into the free space following it when needed, which
+	// is at most the largest free slot of the device
+	limit := int64(float64(dev.ThinPool.Size.Value()+dev.Free.Value()) * params.OverProvisioningRatio)
+	if available := limit - dev.ThinPool.Provisioned.Value(); available > 0 {
+		return available
+	}
+	return 0
+}
+
+// filterNodesByCapacity filters out the nodes which don't have any device
+// matching the device name with enough allocatable capacity for the volume.
+// The order of the scheduled nodes is preserved.
+func (cs *controller) filterNodesByCapacity(nodes []string,
+	params *VolumeParams, capacity int64) []string {
+	devRegex, err := regexp.Compile(params.DeviceName)
+	if err != nil {
+		klog.Infof("Disk: Regex compile failure %s, %+v", params.DeviceName, err)
+		return nil
+	}
+	deviceNodeCache := cs.deviceNodeInformer.GetIndexer()
+	var result []string
+	for _, nodeName := range nodes {
+		v, exists, err := deviceNodeCache.GetByKey(device.DeviceNamespace + "/" + nodeName)
+		if err != nil || !exists {
+			continue
+		}
+		for _, dev := range v.(*apis.DeviceNode).Devices {
+			if devRegex.MatchString(dev.Name) && getAllocatableCapacity(dev, params) >= capacity {
+				result = append(result, nodeName)
+				break
+			}
+		}
+	}
+	return result
+}
+
 func (cs *controller) filterNodesByTopology(segments map[string]string) ([]string, error) {
 	nodesCache := cs.k8sNodeInformer.GetIndexer()
 	if len(segments) == 0 {
@@ -615,7 +809,205 @@ func (cs *controller) ListVolumes(
 	req *csi.ListVolumesRequest,
 ) (*csi.ListVolumesResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"invalid max entries %d", req.GetMaxEntries())
+	}
+
+	var vols []*apis.DeviceVolume
+	for _, obj := range cs.deviceVolumeInformer.GetStore().List() {
+		vol, ok := obj.(*apis.DeviceVolume)
+		if !ok {
+			klog.Warningf("unrecognized object type %T in device volume cache", obj)
+			continue
+		}
+		// the volumes in the recycle bin are deleted for the CO
+		if vol.Status.State == device.DeviceStatusReleased {
+			continue
+		}
+		vols = append(vols, vol)
+	}
+
+	entries, nextToken, err := paginateVolumes(vols,
+		req.GetStartingToken(), int(req.GetMaxEntries()))
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	return &csi.ListVolumesResponse{Entries: entries, NextToken: nextToken}, nil
+}
+
+// paginateVolumes returns a page of at most maxEntries volumes, zero meaning
+// no limit, sorted by name. The token is the name of the first volume of the
+// page, so that the pages stay consistent when volumes come and go between
+// the calls. It returns the token of the next page, empty on the last page,
+// and fails when no volume matches the starting token.
+func paginateVolumes(vols []*apis.DeviceVolume, startingToken string,
+	maxEntries int) ([]*csi.ListVolumesResponse_Entry, string, error) {
+	sort.Slice(vols, func(i, j int) bool {
+		return vols[i].Name < vols[j].Name
+	})
+
+	start := sort.Search(len(vols), func(i int) bool {
+		return vols[i].Name >= startingToken
+	})
+	if startingToken != "" &&
+		(start == len(vols) || vols[start].Name != startingToken) {
+		return nil, "", fmt.Errorf("invalid starting token %q", startingToken)
+	}
+	end := len(vols)
+	if maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	var nextToken string
+	if end < len(vols) {
+		nextToken = vols[end].Name
+	}
+
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, end-start)
+	for _, vol := range vols[start:end] {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: getCSIVolume(vol),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: getPublishedNodes(vol),
+				VolumeCondition:  getVolumeCondition(vol),
+			},
+		})
+	}
+	return entries, nextToken, nil
+}
+
+// ControllerGetVolume returns the volume along with the node it is
+// published on, which is the owner node of a ready local volume, and
+// its condition as recorded in the DeviceVolume.
+//
+// This implements csi.ControllerServer
+func (cs *controller) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+
+	volumeID := strings.ToLower(req.GetVolumeId())
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is not provided")
+	}
+
+	vol, err := device.GetDeviceVolume(volumeID)
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+		}
+		return nil, status.Errorf(codes.Internal,
+			"failed to get device volume %s: %v", volumeID, err)
+	}
+	if vol.Status.State == device.DeviceStatusReleased {
+		return nil, status.Errorf(codes.NotFound, "volume %s is released", volumeID)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: getCSIVolume(vol),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: getPublishedNodes(vol),
+			VolumeCondition:  getVolumeCondition(vol),
+		},
+	}, nil
+}
+
+// ControllerModifyVolume modifies the mutable parameters of a volume, e.g.
+// on a change of the VolumeAttributesClass of its PVC. The node agent
+// applies the modifications to the mounts of the volume.
+//
+// This implements csi.ControllerServer
+func (cs *controller) ControllerModifyVolume(
+	ctx context.Context,
+	req *csi.ControllerModifyVolumeRequest,
+) (*csi.ControllerModifyVolumeResponse, error) {
+
+	if err := cs.validateRequest(csi.ControllerServiceCapability_RPC_MODIFY_VOLUME); err != nil {
+		return nil, err
+	}
+
+	volumeID := strings.ToLower(req.GetVolumeId())
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is not provided")
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vol, err := device.GetDeviceVolume(volumeID)
+		if err != nil {
+			return err
+		}
+		if err = applyMutableParams(&vol.Spec, req.GetMutableParameters()); err != nil {
+			return status.Errorf(codes.InvalidArgument,
+				"failed to modify volume %s: %v", volumeID, err)
+		}
+		_, err = volbuilder.NewKubeclient().WithNamespace(device.DeviceNamespace).Update(vol)
+		return err
+	})
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+		}
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Internal,
+			"failed to modify volume %s: %v", volumeID, err)
+	}
+	klog.Infof("modified volume %s with %v", volumeID, req.GetMutableParameters())
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// getCSIVolume returns the csi volume of the device volume
+func getCSIVolume(vol *apis.DeviceVolume) *csi.Volume {
+	capacity, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		klog.Errorf("invalid capacity %q of volume %s", vol.Spec.Capacity, vol.Name)
+	}
+	return &csi.Volume{
+		VolumeId:      vol.Name,
+		CapacityBytes: capacity,
+		VolumeContext: map[string]string{
+			device.DeviceNameKey:     vol.Spec.DevName,
+			device.OpenEBSCasTypeKey: device.LocalDeviceCasTypeName,
+		},
+		AccessibleTopology: []*csi.Topology{{
+			Segments: map[string]string{device.DeviceTopologyKey: vol.Spec.OwnerNodeID},
+		}},
+	}
+}
+
+// getPublishedNodes returns the nodes the volume is published on, i.e.
+// its owner node while the node agent reports it published
+func getPublishedNodes(vol *apis.DeviceVolume) []string {
+	if vol.Status.State != device.DeviceStatusReady || !vol.Status.Published {
+		return []string{}
+	}
+	return []string{vol.Spec.OwnerNodeID}
+}
+
+// getVolumeCondition returns the condition of the volume as seen
+// from the controller, i.e. from the DeviceVolume status
+func getVolumeCondition(vol *apis.DeviceVolume) *csi.VolumeCondition {
+	switch vol.Status.State {
+	case device.DeviceStatusFailed:
+		msg := "volume provisioning failed"
+		if vol.Status.Error != nil {
+			msg = fmt.Sprintf("%s: %s", msg, vol.Status.Error.Message)
+		}
+		return &csi.VolumeCondition{Abnormal: true, Message: msg}
+	case device.DeviceStatusPending:
+		return &csi.VolumeCondition{Abnormal: true, Message: "volume is not provisioned yet"}
+	}
+	cond := apimeta.FindStatusCondition(vol.Status.Conditions, device.FsckConditionType)
+	if cond != nil && cond.Status == metav1.ConditionFalse {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("filesystem check: %s: %s", cond.Reason, cond.Message),
+		}
+	}
+	return &csi.VolumeCondition{Message: "volume is ready"}
 }
 
 // validateCapabilities validates if provided capabilities
@@ -684,6 +1076,12 @@ func newControllerCapabilities() []*csi.ControllerServiceCapability {
 	for _, cap := range []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
 	} {
 		capabilities = append(capabilities, fromType(cap))
 	}
@@ -19,7 +19,15 @@ package driver
 import (
 	"testing"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/device"
 )
 
 func TestRoundOff(t *testing.T) {
@@ -41,3 +49,116 @@ func TestRoundOff(t *testing.T) {
 		})
 	}
 }
+
+func TestPaginateVolumes(t *testing.T) {
+	newVol := func(name string, state string) *apis.DeviceVolume {
+		return &apis.DeviceVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: apis.VolumeInfo{
+				OwnerNodeID: "node-1",
+				Capacity:    "1048576",
+				DevName:     "test-device",
+			},
+			Status: apis.VolStatus{State: state},
+		}
+	}
+	vols := []*apis.DeviceVolume{
+		newVol("pvc-c", device.DeviceStatusReady),
+		newVol("pvc-a", device.DeviceStatusReady),
+		newVol("pvc-d", device.DeviceStatusFailed),
+		newVol("pvc-b", device.DeviceStatusPending),
+	}
+	vols[0].Status.Published = true
+
+	tests := map[string]struct {
+		token      string
+		maxEntries int
+		expected   []string
+		nextToken  string
+		isErr      bool
+	}{
+		"all volumes":            {expected: []string{"pvc-a", "pvc-b", "pvc-c", "pvc-d"}},
+		"first page":             {maxEntries: 3, expected: []string{"pvc-a", "pvc-b", "pvc-c"}, nextToken: "pvc-d"},
+		"last page":              {token: "pvc-d", maxEntries: 3, expected: []string{"pvc-d"}},
+		"middle page":            {token: "pvc-b", maxEntries: 2, expected: []string{"pvc-b", "pvc-c"}, nextToken: "pvc-d"},
+		"deleted token volume":   {token: "pvc-bb", maxEntries: 1, isErr: true},
+		"token past the volumes": {token: "pvc-e", isErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			entries, nextToken, err := paginateVolumes(vols, test.token, test.maxEntries)
+			if test.isErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.GetVolume().GetVolumeId())
+			}
+			assert.Equal(t, test.expected, names)
+			assert.Equal(t, test.nextToken, nextToken)
+		})
+	}
+
+	entries, _, err := paginateVolumes(vols, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1048576), entries[0].GetVolume().GetCapacityBytes())
+	assert.Empty(t, entries[0].GetStatus().GetPublishedNodeIds())
+	assert.Equal(t, []string{"node-1"}, entries[2].GetStatus().GetPublishedNodeIds())
+	assert.False(t, entries[0].GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Empty(t, entries[1].GetStatus().GetPublishedNodeIds())
+	assert.True(t, entries[3].GetStatus().GetVolumeCondition().GetAbnormal())
+}
+
+func TestNewDeviceVolumeMutableParams(t *testing.T) {
+	newReq := func(mutable map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          "PVC-1",
+			CapacityRange: &csi.CapacityRange{RequiredBytes: Gi},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			}},
+			MutableParameters: mutable,
+		}
+	}
+
+	tests := map[string]struct {
+		mutable  map[string]string
+		isErr    bool
+		discard  string
+		ioLimits *apis.IOLimits
+	}{
+		"no mutable parameters": {discard: "mount"},
+		"mutable parameters override the storage class": {
+			mutable:  map[string]string{"discard": "periodic", "readBps": "1048576"},
+			discard:  "periodic",
+			ioLimits: &apis.IOLimits{ReadBps: 1048576, WriteIOPS: 100},
+		},
+		"immutable parameter":     {mutable: map[string]string{"devname": "other"}, isErr: true},
+		"invalid parameter value": {mutable: map[string]string{"readiops": "fast"}, isErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			params, err := NewVolumeParams(map[string]string{
+				"devname":   "test-device",
+				"discard":   "mount",
+				"writeiops": "100",
+			})
+			require.NoError(t, err)
+			vol, err := newDeviceVolume(newReq(test.mutable), params)
+			if test.isErr {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "pvc-1", vol.Name)
+			assert.Equal(t, test.discard, vol.Spec.Discard)
+			if test.ioLimits != nil {
+				assert.Equal(t, test.ioLimits, vol.Spec.IOLimits)
+			}
+		})
+	}
+}
@@ -49,6 +49,9 @@ type CSIDriver struct {
 func GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
 	supported := []csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 	}
 
 	var vcams []*csi.VolumeCapability_AccessMode
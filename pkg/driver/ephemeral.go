@@ -0,0 +1,174 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/lib-csi/pkg/common/helpers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+const (
+	// EphemeralContextKey is set by kubelet in the volume context
+	// of the CSI ephemeral inline volumes
+	EphemeralContextKey = "csi.storage.k8s.io/ephemeral"
+
+	// EphemeralSizeKey is the volume attribute holding the size
+	// of an ephemeral inline volume
+	EphemeralSizeKey = "size"
+)
+
+// isEphemeralVolume checks if the publish request is for a CSI
+// ephemeral inline volume
+func isEphemeralVolume(req *csi.NodePublishVolumeRequest) bool {
+	return req.GetVolumeContext()[EphemeralContextKey] == "true"
+}
+
+// isEphemeralDeviceVolume checks if the device volume was created
+// for a CSI ephemeral inline volume
+func isEphemeralDeviceVolume(vol *apis.DeviceVolume) bool {
+	return vol.Labels[device.EphemeralVolumeKey] == "true"
+}
+
+// isEphemeralDeviceAllowed checks if the device is in the comma separated
+// list of the devices allowed for the ephemeral volumes
+func isEphemeralDeviceAllowed(allowed string, devName string) bool {
+	for _, name := range strings.Split(allowed, ",") {
+		if name = strings.TrimSpace(name); name != "" && name == devName {
+			return true
+		}
+	}
+	return false
+}
+
+// createEphemeralVolume creates the device volume for a CSI ephemeral inline
+// volume on this node, using the size and the devname from the volume
+// attributes, and waits for the partition to be created. The volume is
+// tracked as a DeviceVolume like any other volume, so that the capacity
+// accounting of the node stays correct.
+func (ns *node) createEphemeralVolume(ctx context.Context,
+	req *csi.NodePublishVolumeRequest) error {
+	volName := strings.ToLower(req.GetVolumeId())
+	attrs := req.GetVolumeContext()
+
+	params, err := NewVolumeParams(attrs)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument,
+			"failed to parse ephemeral volume attributes: %v", err)
+	}
+	if params.DeviceName == "" {
+		return status.Error(codes.InvalidArgument,
+			"devname missing in ephemeral volume attributes")
+	}
+	// the pods can request inline volumes without going through a storage
+	// class, they are limited to the devices allowed by the administrator
+	if !isEphemeralDeviceAllowed(ns.driver.config.EphemeralDevices, params.DeviceName) {
+		return status.Errorf(codes.InvalidArgument,
+			"ephemeral volumes are not allowed on device %s", params.DeviceName)
+	}
+	if params.Allocation != device.AllocationPartition {
+		return status.Errorf(codes.InvalidArgument,
+			"ephemeral volumes only support %q allocation", device.AllocationPartition)
+	}
+	size, err := resource.ParseQuantity(helpers.GetInsensitiveParameter(&attrs, EphemeralSizeKey))
+	if err != nil || size.Value() <= 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"invalid size in ephemeral volume attributes: %v", err)
+	}
+	capacity := strconv.FormatInt(getRoundedCapacity(size.Value()), 10)
+
+	fsType, mkfsOptions := params.GetFilesystem([]*csi.VolumeCapability{req.GetVolumeCapability()})
+
+	vol, err := device.GetDeviceVolume(volName)
+	if err != nil {
+		if !k8serror.IsNotFound(err) {
+			return status.Errorf(codes.Aborted,
+				"failed get device volume %v: %v", volName, err.Error())
+		}
+		vol = nil
+	}
+
+	if vol == nil {
+		volObj, err := volbuilder.NewBuilder().
+			WithName(volName).
+			WithCapacity(capacity).
+			WithDeviceName(params.DeviceName).
+			WithAllocation(params.Allocation).
+			WithFsType(fsType).
+			WithMkfsOptions(mkfsOptions).
+			WithFsckPolicy(params.FsckPolicy).
+			WithFsckFallback(params.FsckFallback).
+			WithDiscard(params.Discard).
+			WithIOLimits(params.IOLimits).
+			WithOwnerNode(ns.driver.config.NodeID).
+			WithLabels(map[string]string{device.EphemeralVolumeKey: "true"}).
+			WithVolumeStatus(device.DeviceStatusPending).Build()
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		klog.Infof("creating ephemeral volume %s/%s of size %s", params.DeviceName, volName, capacity)
+		if vol, err = device.ProvisionVolume(volObj); err != nil {
+			return status.Errorf(codes.Internal, "not able to provision the volume %s", err.Error())
+		}
+	} else if vol.Spec.Capacity != capacity || vol.Spec.OwnerNodeID != ns.driver.config.NodeID {
+		return status.Errorf(codes.AlreadyExists,
+			"volume %s already present", volName)
+	}
+
+	if vol, err = device.WaitForDeviceVolumeProcessed(ctx, volName); err != nil {
+		return err
+	}
+	if vol.Status.State == device.DeviceStatusReady {
+		return nil
+	}
+
+	// the volume failed, delete it so that the next attempt starts afresh
+	var errMsg string
+	if vol.Status.Error != nil {
+		errMsg = vol.Status.Error.Message
+	}
+	if err = device.DeleteVolume(volName); err != nil {
+		klog.Errorf("failed to delete failed ephemeral volume %s: %v", volName, err)
+	}
+	return status.Errorf(codes.ResourceExhausted,
+		"failed to create ephemeral volume %s: %s", volName, errMsg)
+}
+
+// deleteEphemeralVolume deletes the device volume of a CSI ephemeral inline
+// volume, which destroys the partition, and waits for the deletion.
+func deleteEphemeralVolume(ctx context.Context, vol *apis.DeviceVolume) error {
+	klog.Infof("deleting ephemeral volume %s", vol.Name)
+	if vol.GetDeletionTimestamp() == nil {
+		if err := device.DeleteVolume(vol.Name); err != nil {
+			return status.Errorf(codes.Internal,
+				"failed to delete ephemeral volume %s: %v", vol.Name, err)
+		}
+	}
+	return device.WaitForDeviceVolumeDestroy(ctx, vol.Name)
+}
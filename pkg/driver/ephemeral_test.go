@@ -0,0 +1,89 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/openebs/device-localpv/pkg/config"
+)
+
+func TestIsEphemeralDeviceAllowed(t *testing.T) {
+	tests := map[string]struct {
+		allowed  string
+		devName  string
+		expected bool
+	}{
+		"no allowed device":  {allowed: "", devName: "test-device", expected: false},
+		"allowed device":     {allowed: "scratch, test-device", devName: "test-device", expected: true},
+		"not allowed device": {allowed: "scratch", devName: "test-device", expected: false},
+		"prefix of a device": {allowed: "test-device", devName: "test", expected: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isEphemeralDeviceAllowed(test.allowed, test.devName))
+		})
+	}
+}
+
+func TestCreateEphemeralVolumeRestrictions(t *testing.T) {
+	ns := &node{driver: &CSIDriver{config: &config.Config{
+		NodeID:           "node-1",
+		EphemeralDevices: "scratch",
+	}}}
+
+	tests := map[string]struct {
+		attrs map[string]string
+		msg   string
+	}{
+		"device not allowed": {
+			attrs: map[string]string{"devname": "test-device", "size": "1Gi"},
+			msg:   "not allowed on device test-device",
+		},
+		"thin allocation": {
+			attrs: map[string]string{"devname": "scratch", "size": "1Gi", "allocation": "thin"},
+			msg:   "only support \"partition\" allocation",
+		},
+		"whole disk allocation": {
+			attrs: map[string]string{"devname": "scratch", "size": "1Gi", "allocation": "wholeDisk"},
+			msg:   "only support \"partition\" allocation",
+		},
+		"missing device": {
+			attrs: map[string]string{"size": "1Gi"},
+			msg:   "devname missing",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.attrs[EphemeralContextKey] = "true"
+			err := ns.createEphemeralVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:      "csi-1",
+				VolumeContext: test.attrs,
+			})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Contains(t, status.Convert(err).Message(), test.msg)
+		})
+	}
+}
@@ -27,6 +27,8 @@ import (
 // identity is the server implementation
 // for CSI IdentityServer
 type identity struct {
+	csi.UnimplementedIdentityServer
+
 	driver *CSIDriver
 }
 
@@ -17,7 +17,17 @@
 package driver
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/openebs/lib-csi/pkg/common/helpers"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/device"
 )
 
 // VolumeParams holds collection of supported settings that can
@@ -28,7 +38,54 @@ type VolumeParams struct {
 	DeviceName string
 
 	Scheduler string
-	Shared    string
+
+	// Shared specifies whether the volume can be published to
+	// several pods on the owner node at the same time ("yes"/"no").
+	Shared string
+
+	// Allocation specifies how the volume is carved out of the device,
+	// either as a dedicated partition, as a thin volume or as a whole disk.
+	Allocation string
+
+	// OverProvisioningRatio caps the total size of the thin volumes
+	// provisioned on a device to the given multiple of its pool size.
+	OverProvisioningRatio float64
+
+	// FsType is the filesystem the volume is formatted with, it takes
+	// precedence over the fsType of the volume capability.
+	FsType string
+
+	// MkfsOptions holds the extra mkfs arguments keyed by the filesystem,
+	// the options under the empty key apply to any filesystem.
+	MkfsOptions map[string]string
+
+	// FsckPolicy specifies when the filesystem of the volume is checked,
+	// never, onError or always.
+	FsckPolicy string
+
+	// FsckFallback specifies how a volume with uncorrected filesystem
+	// errors is mounted, fail or readOnly.
+	FsckFallback string
+
+	// Discard specifies how the freed blocks of the filesystem are
+	// discarded, mount or periodic.
+	Discard string
+
+	// Retention specifies how long the partition of a deleted volume
+	// is kept in the recycle bin before it is purged.
+	Retention string
+
+	// IOLimits are the bandwidth and IOPS limits of the pods
+	// consuming the volume.
+	IOLimits apis.IOLimits
+
+	// ImageSource is the node local path or the http(s) URL of the
+	// disk image the volume is populated with.
+	ImageSource string
+
+	// ImageSourceOverride specifies whether the image source can be
+	// overridden by the annotation of the PVC ("yes"/"no").
+	ImageSourceOverride string
 
 	// extra optional metadata passed by external provisioner
 	// if enabled. See --extra-create-metadata flag for more details.
@@ -41,7 +98,9 @@ type VolumeParams struct {
 // NewVolumeParams parses the input params and instantiates new VolumeParams.
 func NewVolumeParams(m map[string]string) (*VolumeParams, error) {
 	params := &VolumeParams{ // set up defaults, if any.
-		Scheduler: CapacityWeighted,
+		Scheduler:             CapacityWeighted,
+		Allocation:            device.AllocationPartition,
+		OverProvisioningRatio: 1,
 	}
 	// parameter keys may be mistyped from the CRD specification when declaring
 	// the storageclass, which kubectl validation will not catch. Because
@@ -52,7 +111,16 @@ func NewVolumeParams(m map[string]string) (*VolumeParams, error) {
 
 	// parse string params
 	stringParams := map[string]*string{
-		"scheduler": &params.Scheduler,
+		"scheduler":           &params.Scheduler,
+		"allocation":          &params.Allocation,
+		"imagesource":         &params.ImageSource,
+		"imagesourceoverride": &params.ImageSourceOverride,
+		"shared":              &params.Shared,
+		"fstype":              &params.FsType,
+		"fsckpolicy":          &params.FsckPolicy,
+		"fsckfallback":        &params.FsckFallback,
+		"discard":             &params.Discard,
+		"retention":           &params.Retention,
 	}
 	for key, param := range stringParams {
 		value, ok := m[key]
@@ -62,9 +130,189 @@ func NewVolumeParams(m map[string]string) (*VolumeParams, error) {
 		*param = value
 	}
 
+	if params.Allocation != device.AllocationPartition &&
+		params.Allocation != device.AllocationThin &&
+		params.Allocation != device.AllocationWholeDisk {
+		return nil, fmt.Errorf("invalid allocation %q", params.Allocation)
+	}
+
+	if params.Shared != "" && params.Shared != "yes" && params.Shared != "no" {
+		return nil, fmt.Errorf("invalid shared %q, must be yes or no", params.Shared)
+	}
+
+	if params.ImageSourceOverride != "" && params.ImageSourceOverride != "yes" &&
+		params.ImageSourceOverride != "no" {
+		return nil, fmt.Errorf("invalid imageSourceOverride %q, must be yes or no",
+			params.ImageSourceOverride)
+	}
+
+	if params.FsType != "" && !device.IsSupportedFsType(params.FsType) {
+		return nil, fmt.Errorf("invalid fsType %q, must be one of %v",
+			params.FsType, device.SupportedFsTypes)
+	}
+
+	if params.FsckPolicy != "" && params.FsckPolicy != device.FsckPolicyNever &&
+		params.FsckPolicy != device.FsckPolicyOnError &&
+		params.FsckPolicy != device.FsckPolicyAlways {
+		return nil, fmt.Errorf("invalid fsckPolicy %q", params.FsckPolicy)
+	}
+
+	if params.FsckFallback != "" && params.FsckFallback != device.FsckFallbackFail &&
+		params.FsckFallback != device.FsckFallbackReadOnly {
+		return nil, fmt.Errorf("invalid fsckFallback %q", params.FsckFallback)
+	}
+
+	if params.Discard != "" && params.Discard != device.DiscardMount &&
+		params.Discard != device.DiscardPeriodic {
+		return nil, fmt.Errorf("invalid discard %q", params.Discard)
+	}
+
+	if params.Retention != "" {
+		retention, err := time.ParseDuration(params.Retention)
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf("invalid retention %q, must be a positive duration", params.Retention)
+		}
+		if params.Allocation != device.AllocationPartition {
+			return nil, fmt.Errorf("retention is only supported with %q allocation", device.AllocationPartition)
+		}
+	}
+
+	// mkfsOptions apply to any filesystem, mkfsOptions.<fsType> only
+	// to the given one and take precedence
+	params.MkfsOptions = map[string]string{}
+	for key, value := range m {
+		if key == "mkfsoptions" || strings.HasPrefix(key, "mkfsoptions.") {
+			if _, err := device.SplitMkfsOptions(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+		}
+		if key == "mkfsoptions" {
+			params.MkfsOptions[""] = value
+			continue
+		}
+		if fsType := strings.TrimPrefix(key, "mkfsoptions."); fsType != key {
+			if !device.IsSupportedFsType(fsType) {
+				return nil, fmt.Errorf("invalid filesystem %q in %s", fsType, key)
+			}
+			params.MkfsOptions[fsType] = value
+		}
+	}
+
+	if value, ok := m["overprovisioningratio"]; ok {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 1 {
+			return nil, fmt.Errorf("invalid overProvisioningRatio %q, must be a number >= 1", value)
+		}
+		params.OverProvisioningRatio = ratio
+	}
+
+	// the bandwidths are quantities, e.g. 100Mi, the IOPS plain numbers
+	ioLimitParams := map[string]*int64{
+		"readBps":   &params.IOLimits.ReadBps,
+		"writeBps":  &params.IOLimits.WriteBps,
+		"readIOPS":  &params.IOLimits.ReadIOPS,
+		"writeIOPS": &params.IOLimits.WriteIOPS,
+	}
+	for name, param := range ioLimitParams {
+		value, ok := m[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() < 0 {
+			return nil, fmt.Errorf("invalid %s %q, must be a positive quantity", name, value)
+		}
+		*param = quantity.Value()
+	}
+
 	params.PVCName = m["csi.storage.k8s.io/pvc/name"]
 	params.PVCNamespace = m["csi.storage.k8s.io/pvc/namespace"]
 	params.PVName = m["csi.storage.k8s.io/pv/name"]
 
 	return params, nil
 }
+
+// GetFilesystem returns the filesystem and the mkfs options of a volume
+// created with the given capabilities. Block volumes don't have any, for
+// the others the fsType parameter wins over the one of the capability.
+func (params *VolumeParams) GetFilesystem(caps []*csi.VolumeCapability) (string, string) {
+	fsType := params.FsType
+	for _, c := range caps {
+		if c.GetBlock() != nil {
+			return "", ""
+		}
+		if fsType == "" {
+			fsType = strings.ToLower(c.GetMount().GetFsType())
+		}
+	}
+	if fsType == "" {
+		fsType = device.DefaultFsType
+	}
+
+	mkfsOptions, ok := params.MkfsOptions[fsType]
+	if !ok {
+		mkfsOptions = params.MkfsOptions[""]
+	}
+	return fsType, mkfsOptions
+}
+
+// mutableParams are the parameters which can be modified once the volume
+// has been created, e.g. through a VolumeAttributesClass
+var mutableParams = map[string]bool{
+	"readbps":      true,
+	"writebps":     true,
+	"readiops":     true,
+	"writeiops":    true,
+	"discard":      true,
+	"fsckpolicy":   true,
+	"fsckfallback": true,
+}
+
+// applyMutableParams validates the mutable parameters and sets them in the
+// spec of the volume. The parameters which are not given are left as is,
+// a zero IO limit removes the limit.
+func applyMutableParams(spec *apis.VolumeInfo, m map[string]string) error {
+	m = helpers.GetCaseInsensitiveMap(&m)
+	for key := range m {
+		if !mutableParams[key] {
+			return fmt.Errorf("parameter %s can not be modified", key)
+		}
+	}
+	// the values are validated as for the storage class
+	params, err := NewVolumeParams(m)
+	if err != nil {
+		return err
+	}
+
+	stringParams := map[string]struct{ from, to *string }{
+		"discard":      {&params.Discard, &spec.Discard},
+		"fsckpolicy":   {&params.FsckPolicy, &spec.FsckPolicy},
+		"fsckfallback": {&params.FsckFallback, &spec.FsckFallback},
+	}
+	for key, param := range stringParams {
+		if _, ok := m[key]; ok {
+			*param.to = *param.from
+		}
+	}
+
+	var limits apis.IOLimits
+	if spec.IOLimits != nil {
+		limits = *spec.IOLimits
+	}
+	ioLimitParams := map[string]struct{ from, to *int64 }{
+		"readbps":   {&params.IOLimits.ReadBps, &limits.ReadBps},
+		"writebps":  {&params.IOLimits.WriteBps, &limits.WriteBps},
+		"readiops":  {&params.IOLimits.ReadIOPS, &limits.ReadIOPS},
+		"writeiops": {&params.IOLimits.WriteIOPS, &limits.WriteIOPS},
+	}
+	for key, param := range ioLimitParams {
+		if _, ok := m[key]; ok {
+			*param.to = *param.from
+		}
+	}
+	spec.IOLimits = nil
+	if limits != (apis.IOLimits{}) {
+		spec.IOLimits = &limits
+	}
+	return nil
+}
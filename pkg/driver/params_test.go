@@ -0,0 +1,149 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+)
+
+func TestApplyMutableParams(t *testing.T) {
+	tests := map[string]struct {
+		spec     apis.VolumeInfo
+		params   map[string]string
+		expected apis.VolumeInfo
+		wantErr  bool
+	}{
+		"set io limits and discard": {
+			spec:   apis.VolumeInfo{FsckPolicy: "always"},
+			params: map[string]string{"readBps": "100Mi", "writeIOPS": "500", "discard": "periodic"},
+			expected: apis.VolumeInfo{
+				FsckPolicy: "always",
+				Discard:    "periodic",
+				IOLimits:   &apis.IOLimits{ReadBps: 100 * Mi, WriteIOPS: 500},
+			},
+		},
+		"modify a limit and keep the others": {
+			spec:   apis.VolumeInfo{IOLimits: &apis.IOLimits{ReadBps: Mi, WriteBps: Mi}},
+			params: map[string]string{"writeBps": "2Mi"},
+			expected: apis.VolumeInfo{
+				IOLimits: &apis.IOLimits{ReadBps: Mi, WriteBps: 2 * Mi},
+			},
+		},
+		"remove the limits": {
+			spec:     apis.VolumeInfo{Discard: "mount", IOLimits: &apis.IOLimits{ReadBps: Mi}},
+			params:   map[string]string{"readBps": "0", "discard": ""},
+			expected: apis.VolumeInfo{},
+		},
+		"invalid value": {
+			params:  map[string]string{"fsckPolicy": "sometimes"},
+			wantErr: true,
+		},
+		"immutable parameter": {
+			params:  map[string]string{"fsType": "xfs"},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			spec := test.spec
+			err := applyMutableParams(&spec, test.params)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, spec)
+		})
+	}
+}
+
+func TestNewVolumeParamsRetention(t *testing.T) {
+	tests := map[string]struct {
+		params   map[string]string
+		expected string
+		wantErr  bool
+	}{
+		"no retention": {
+			params: map[string]string{},
+		},
+		"retention": {
+			params:   map[string]string{"retention": "72h"},
+			expected: "72h",
+		},
+		"invalid duration": {
+			params:  map[string]string{"retention": "3d"},
+			wantErr: true,
+		},
+		"negative duration": {
+			params:  map[string]string{"retention": "-1h"},
+			wantErr: true,
+		},
+		"thin allocation": {
+			params:  map[string]string{"retention": "72h", "allocation": "thin"},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			params, err := NewVolumeParams(test.params)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, params.Retention)
+		})
+	}
+}
+
+func TestNewVolumeParamsImageSourceOverride(t *testing.T) {
+	tests := map[string]struct {
+		params   map[string]string
+		expected string
+		wantErr  bool
+	}{
+		"no override": {
+			params: map[string]string{"imageSource": "http://images.example.com/disk.img"},
+		},
+		"override allowed": {
+			params:   map[string]string{"imageSourceOverride": "yes"},
+			expected: "yes",
+		},
+		"invalid override": {
+			params:  map[string]string{"imageSourceOverride": "true"},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			params, err := NewVolumeParams(test.params)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, params.ImageSourceOverride)
+		})
+	}
+}
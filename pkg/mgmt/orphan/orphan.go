@@ -0,0 +1,328 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package orphan
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/nodebuilder"
+	"github.com/openebs/device-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/device-localpv/pkg/collector"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+const (
+	// reconcileInterval is the interval between the comparisons of
+	// the partitions of the node with the DeviceVolumes
+	reconcileInterval = 5 * time.Minute
+
+	// deleteAfterPolicy is the prefix of the cleanup policy deleting
+	// the partitions orphaned for longer than the given duration
+	deleteAfterPolicy = "delete-after="
+)
+
+// Reasons of the events emitted by the reconciler
+const (
+	ReasonOrphanedPartition        = "OrphanedPartition"
+	ReasonOrphanedPartitionDeleted = "OrphanedPartitionDeleted"
+	ReasonOrphanCleanupFailed      = "OrphanCleanupFailed"
+	ReasonPartitionMissing         = "PartitionMissing"
+)
+
+// ParseCleanupPolicy parses the cleanup policy of the orphaned partitions,
+// either empty, the orphans are only reported, or delete-after=<duration>.
+// It returns the duration after which an orphan is deleted, zero when the
+// orphans are never deleted.
+func ParseCleanupPolicy(policy string) (time.Duration, error) {
+	if policy == "" {
+		return 0, nil
+	}
+	if !strings.HasPrefix(policy, deleteAfterPolicy) {
+		return 0, fmt.Errorf("invalid orphan cleanup policy %q, expected %s<duration>",
+			policy, deleteAfterPolicy)
+	}
+	after, err := time.ParseDuration(strings.TrimPrefix(policy, deleteAfterPolicy))
+	if err != nil || after <= 0 {
+		return 0, fmt.Errorf("invalid orphan cleanup policy %q, "+
+			"the duration must be positive (e.g: %s24h)", policy, deleteAfterPolicy)
+	}
+	return after, nil
+}
+
+// findOrphans returns the partitions which back none of the volumes of the node
+func findOrphans(parts []device.PartUsed, vols []apis.DeviceVolume, nodeID string) []device.PartUsed {
+	names := make(map[string]bool)
+	for i := range vols {
+		if vols[i].Spec.OwnerNodeID == nodeID && device.HasPartition(&vols[i]) {
+			names[device.GetVolumePartitionName(&vols[i])] = true
+			// the partition of a volume being created is named
+			// after its uid before its status records the name
+			if vols[i].Status.PartitionName == "" && vols[i].UID != "" {
+				names[string(vols[i].UID)] = true
+			}
+		}
+	}
+	var orphans []device.PartUsed
+	for _, part := range parts {
+		if !names[part.Name] {
+			orphans = append(orphans, part)
+		}
+	}
+	return orphans
+}
+
+// findMissing returns the volumes of the node which were Ready before the
+// partitions were listed and still are after, but whose partition was not
+// listed. The volumes being deleted are skipped, as their partition may
+// be gone already.
+func findMissing(before []apis.DeviceVolume, parts []device.PartUsed, after []apis.DeviceVolume, nodeID string) []*apis.DeviceVolume {
+	names := make(map[string]bool)
+	for _, part := range parts {
+		names[part.Name] = true
+	}
+	ready := make(map[string]bool)
+	for i := range before {
+		if before[i].Status.State == device.DeviceStatusReady {
+			ready[before[i].Name] = true
+		}
+	}
+	var missing []*apis.DeviceVolume
+	for i := range after {
+		vol := &after[i]
+		if vol.Spec.OwnerNodeID != nodeID ||
+			!device.HasPartition(vol) ||
+			!ready[vol.Name] ||
+			vol.Status.State != device.DeviceStatusReady ||
+			vol.DeletionTimestamp != nil {
+			continue
+		}
+		if !names[device.GetVolumePartitionName(vol)] {
+			missing = append(missing, vol)
+		}
+	}
+	return missing
+}
+
+// orphanKey identifies an orphaned partition across the reconciliations
+func orphanKey(disk string, partNum uint32, name string) string {
+	return fmt.Sprintf("%s/%d/%s", disk, partNum, name)
+}
+
+// getStatus builds the status of the node from the current drift, keeping
+// the time the orphans of the previous status were first seen.
+func getStatus(prev apis.DeviceNodeStatus, orphans []device.PartUsed, missing []*apis.DeviceVolume, now metav1.Time) apis.DeviceNodeStatus {
+	firstSeen := make(map[string]metav1.Time)
+	for _, o := range prev.OrphanedPartitions {
+		firstSeen[orphanKey(o.Disk, o.PartNum, o.Name)] = o.FirstSeen
+	}
+	var status apis.DeviceNodeStatus
+	for _, part := range orphans {
+		seen, ok := firstSeen[orphanKey(part.DiskPath, part.PartNum, part.Name)]
+		if !ok {
+			seen = now
+		}
+		status.OrphanedPartitions = append(status.OrphanedPartitions, apis.OrphanedPartition{
+			Name:      part.Name,
+			Disk:      part.DiskPath,
+			PartNum:   part.PartNum,
+			Size:      *resource.NewQuantity(int64(part.Size), resource.BinarySI),
+			FirstSeen: seen,
+		})
+	}
+	for _, vol := range missing {
+		status.MissingPartitions = append(status.MissingPartitions, vol.Name)
+	}
+	return status
+}
+
+// Reconciler reports the drift between the partitions of the node and the
+// DeviceVolumes, and deletes the orphaned partitions if configured to.
+type Reconciler struct {
+	recorder record.EventRecorder
+
+	// deleteAfter is the duration after which an orphaned partition
+	// is deleted, zero disables the deletion
+	deleteAfter time.Duration
+}
+
+// Start runs the reconciler until the stop channel is closed.
+func Start(stopCh <-chan struct{}) error {
+	recorder := device.EventRecorder()
+	if recorder == nil {
+		return fmt.Errorf("the event recorder of the node agent is not set up")
+	}
+	r := &Reconciler{
+		recorder:    recorder,
+		deleteAfter: device.DeviceConfiguration.OrphanDeleteAfter,
+	}
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reconcile(); err != nil {
+				klog.Errorf("orphan: reconcile failed: %v", err)
+			}
+		case <-stopCh:
+			klog.Info("shutting down orphan reconciler")
+			return nil
+		}
+	}
+}
+
+func listVolumes() ([]apis.DeviceVolume, error) {
+	vols, err := volbuilder.NewKubeclient().
+		WithNamespace(device.DeviceNamespace).
+		List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return vols.Items, nil
+}
+
+// reconcile compares the partitions of the node with the DeviceVolumes. The
+// volumes are listed before and after the partitions, so that the volumes
+// created or deleted meanwhile are reported neither orphaned nor missing.
+func (r *Reconciler) reconcile() error {
+	before, err := listVolumes()
+	if err != nil {
+		return fmt.Errorf("could not list the volumes: %v", err)
+	}
+	parts, err := device.ListPartUsed()
+	if err != nil {
+		return fmt.Errorf("could not list the partitions: %v", err)
+	}
+	after, err := listVolumes()
+	if err != nil {
+		return fmt.Errorf("could not list the volumes: %v", err)
+	}
+
+	nodeClient := nodebuilder.NewKubeclient().WithNamespace(device.DeviceNamespace)
+	node, err := nodeClient.Get(device.NodeID, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get the devicenode: %v", err)
+	}
+
+	orphans := findOrphans(parts, after, device.NodeID)
+	missing := findMissing(before, parts, after, device.NodeID)
+	now := metav1.Now()
+	status := getStatus(node.Status, orphans, missing, now)
+	if r.deleteAfter > 0 {
+		status, orphans = r.cleanup(node, status, orphans, now)
+	}
+	collector.SetPartitionDrift(orphans, status.MissingPartitions)
+	r.recordEvents(node, status, missing)
+
+	if equality.Semantic.DeepEqual(node.Status, status) {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := nodeClient.Get(device.NodeID, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		node.Status = status
+		_, err = nodeClient.Update(node)
+		return err
+	})
+}
+
+// recordEvents emits the events of the orphans and of the missing
+// partitions which were not in the previous status of the node.
+func (r *Reconciler) recordEvents(node *apis.DeviceNode, status apis.DeviceNodeStatus, missing []*apis.DeviceVolume) {
+	seen := make(map[string]bool)
+	for _, o := range node.Status.OrphanedPartitions {
+		seen[orphanKey(o.Disk, o.PartNum, o.Name)] = true
+	}
+	for _, name := range node.Status.MissingPartitions {
+		seen[name] = true
+	}
+	for _, o := range status.OrphanedPartitions {
+		if seen[orphanKey(o.Disk, o.PartNum, o.Name)] {
+			continue
+		}
+		r.recorder.Eventf(node, corev1.EventTypeWarning, ReasonOrphanedPartition,
+			"partition %d (%s) of %s, %s, belongs to no volume",
+			o.PartNum, o.Name, o.Disk, o.Size.String())
+	}
+	for _, vol := range missing {
+		if seen[vol.Name] {
+			continue
+		}
+		r.recorder.Eventf(vol, corev1.EventTypeWarning, ReasonPartitionMissing,
+			"partition %s of the volume is not found on node %s",
+			device.GetVolumePartitionName(vol), device.NodeID)
+	}
+}
+
+// cleanup deletes the orphans first seen longer than deleteAfter ago, and
+// returns the status and the orphans without the deleted partitions. The
+// volumes are listed again before, in case one was created meanwhile.
+func (r *Reconciler) cleanup(node *apis.DeviceNode, status apis.DeviceNodeStatus, orphans []device.PartUsed, now metav1.Time) (apis.DeviceNodeStatus, []device.PartUsed) {
+	var expired []device.PartUsed
+	for i, o := range status.OrphanedPartitions {
+		if now.Sub(o.FirstSeen.Time) >= r.deleteAfter {
+			expired = append(expired, orphans[i])
+		}
+	}
+	if len(expired) == 0 {
+		return status, orphans
+	}
+	vols, err := listVolumes()
+	if err != nil {
+		klog.Errorf("orphan: could not list the volumes, skipping the cleanup: %v", err)
+		return status, orphans
+	}
+
+	deleted := make(map[string]bool)
+	for _, part := range findOrphans(expired, vols, device.NodeID) {
+		if err := device.DeleteOrphanedPartition(part); err != nil {
+			r.recorder.Eventf(node, corev1.EventTypeWarning, ReasonOrphanCleanupFailed,
+				"could not delete orphaned partition %d (%s) of %s: %v",
+				part.PartNum, part.Name, part.DiskPath, err)
+			continue
+		}
+		r.recorder.Eventf(node, corev1.EventTypeNormal, ReasonOrphanedPartitionDeleted,
+			"deleted partition %d (%s) of %s, orphaned for more than %s",
+			part.PartNum, part.Name, part.DiskPath, r.deleteAfter)
+		deleted[orphanKey(part.DiskPath, part.PartNum, part.Name)] = true
+	}
+
+	var remaining []device.PartUsed
+	var kept []apis.OrphanedPartition
+	for i, o := range status.OrphanedPartitions {
+		if deleted[orphanKey(o.Disk, o.PartNum, o.Name)] {
+			continue
+		}
+		remaining = append(remaining, orphans[i])
+		kept = append(kept, o)
+	}
+	status.OrphanedPartitions = kept
+	return status, remaining
+}
@@ -0,0 +1,146 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package orphan
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+func testVolume(name string, node string, allocation string, state string) apis.DeviceVolume {
+	vol := apis.DeviceVolume{}
+	vol.Name = name
+	vol.Spec.OwnerNodeID = node
+	vol.Spec.Allocation = allocation
+	vol.Status.State = state
+	return vol
+}
+
+func testPart(name string, partNum uint32) device.PartUsed {
+	return device.PartUsed{DiskPath: "sdb", PartNum: partNum, Name: name, Size: 1 << 30}
+}
+
+func TestParseCleanupPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{policy: "", want: 0},
+		{policy: "delete-after=24h", want: 24 * time.Hour},
+		{policy: "delete-after=90m", want: 90 * time.Minute},
+		{policy: "delete-after=0s", wantErr: true},
+		{policy: "delete-after=-1h", wantErr: true},
+		{policy: "delete-after=", wantErr: true},
+		{policy: "delete", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got, err := ParseCleanupPolicy(tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCleanupPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCleanupPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findOrphans(t *testing.T) {
+	vols := []apis.DeviceVolume{
+		testVolume("pvc-a", "node1", "", device.DeviceStatusReady),
+		testVolume("csi-b", "node1", device.AllocationPartition, device.DeviceStatusReady),
+		// the partition of a volume rescheduled on another node is orphaned
+		testVolume("pvc-c", "node2", "", device.DeviceStatusReady),
+		// pending volumes own their partition, which may be created already
+		testVolume("pvc-d", "node1", "", ""),
+		// the partition names recorded in the status are used
+		testVolume("data", "node1", "", device.DeviceStatusReady),
+		// pending volumes own the partition named after their uid
+		testVolume("f", "node1", "", ""),
+	}
+	vols[4].Status.PartitionName = "g"
+	vols[5].UID = "uid-f"
+	parts := []device.PartUsed{
+		testPart("a", 2), testPart("b", 3), testPart("c", 4), testPart("d", 5), testPart("e", 6),
+		testPart("g", 7), testPart("uid-f", 8),
+	}
+	want := []device.PartUsed{testPart("c", 4), testPart("e", 6)}
+	if got := findOrphans(parts, vols, "node1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("findOrphans() = %v, want %v", got, want)
+	}
+}
+
+func Test_findMissing(t *testing.T) {
+	ready := testVolume("pvc-a", "node1", "", device.DeviceStatusReady)
+	pending := testVolume("pvc-b", "node1", "", "")
+	thin := testVolume("pvc-c", "node1", device.AllocationThin, device.DeviceStatusReady)
+	other := testVolume("pvc-d", "node1", "", device.DeviceStatusReady)
+	deleting := testVolume("pvc-e", "node1", "", device.DeviceStatusReady)
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	remote := testVolume("pvc-f", "node2", "", device.DeviceStatusReady)
+	created := testVolume("pvc-g", "node1", "", device.DeviceStatusReady)
+
+	before := []apis.DeviceVolume{ready, pending, thin, other, deleting, remote}
+	after := []apis.DeviceVolume{ready, pending, thin, other, deleting, remote, created}
+	parts := []device.PartUsed{testPart("d", 2)}
+
+	got := findMissing(before, parts, after, "node1")
+	if len(got) != 1 || got[0].Name != "pvc-a" {
+		t.Errorf("findMissing() = %v, want [pvc-a]", got)
+	}
+}
+
+func Test_getStatus(t *testing.T) {
+	firstSeen := metav1.NewTime(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	now := metav1.NewTime(firstSeen.Add(time.Hour))
+	prev := apis.DeviceNodeStatus{
+		OrphanedPartitions: []apis.OrphanedPartition{
+			{Name: "a", Disk: "sdb", PartNum: 2, FirstSeen: firstSeen},
+			{Name: "gone", Disk: "sdb", PartNum: 3, FirstSeen: firstSeen},
+		},
+		MissingPartitions: []string{"pvc-x"},
+	}
+	missing := testVolume("pvc-y", "node1", "", device.DeviceStatusReady)
+
+	got := getStatus(prev, []device.PartUsed{testPart("a", 2), testPart("b", 4)},
+		[]*apis.DeviceVolume{&missing}, now)
+
+	if len(got.OrphanedPartitions) != 2 {
+		t.Fatalf("getStatus() orphans = %v, want 2 orphans", got.OrphanedPartitions)
+	}
+	if !got.OrphanedPartitions[0].FirstSeen.Equal(&firstSeen) {
+		t.Errorf("getStatus() first seen of a = %v, want %v", got.OrphanedPartitions[0].FirstSeen, firstSeen)
+	}
+	if !got.OrphanedPartitions[1].FirstSeen.Equal(&now) {
+		t.Errorf("getStatus() first seen of b = %v, want %v", got.OrphanedPartitions[1].FirstSeen, now)
+	}
+	if size := got.OrphanedPartitions[1].Size.Value(); size != 1<<30 {
+		t.Errorf("getStatus() size of b = %d, want %d", size, 1<<30)
+	}
+	if !reflect.DeepEqual(got.MissingPartitions, []string{"pvc-y"}) {
+		t.Errorf("getStatus() missing = %v, want [pvc-y]", got.MissingPartitions)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/builder/pvbuilder"
+	"github.com/openebs/device-localpv/pkg/device"
+)
+
+// Reasons of the events emitted for the volumes in the recycle bin
+const (
+	ReasonVolumeUndeleted = "VolumeUndeleted"
+	ReasonUndeleteFailed  = "UndeleteFailed"
+)
+
+// syncReleasedVol keeps the partition of a Released volume in the recycle
+// bin until its purge time, or restores the volume once it is undeleted.
+func (c *VolController) syncReleasedVol(vol *apis.DeviceVolume) error {
+	// the update of the volume requeues it
+	if !device.IsTrashed(vol) {
+		return device.TrashVolume(vol)
+	}
+	if claim, ok := vol.Annotations[device.UndeleteKey]; ok {
+		return c.undeleteVol(vol, claim)
+	}
+	if vol.Status.PurgeTime == nil {
+		return nil
+	}
+	if wait := time.Until(vol.Status.PurgeTime.Time); wait > 0 {
+		c.enqueueVolAfter(vol, wait)
+		return nil
+	}
+	klog.Infof("purging volume %s from the recycle bin", vol.Name)
+	return device.DeleteVolume(vol.Name)
+}
+
+// undeleteVol restores the Released volume for the claim of the undelete
+// annotation, namespace/name, and creates its PersistentVolume bound to
+// the claim.
+func (c *VolController) undeleteVol(vol *apis.DeviceVolume, claim string) error {
+	namespace, name, err := device.ParseUndeleteClaim(claim)
+	if err != nil {
+		c.recorder.Eventf(vol, corev1.EventTypeWarning, ReasonUndeleteFailed, "%v", err)
+		return nil
+	}
+	if err = device.RestoreVolume(vol, namespace, name); err != nil {
+		return err
+	}
+	return c.createUndeletedPV(vol, claim)
+}
+
+// createUndeletedPV creates the PersistentVolume of the restored volume and
+// removes its undelete annotation. A volume whose PersistentVolume couldn't
+// be created is Ready with the annotation, it is retried by syncVol.
+func (c *VolController) createUndeletedPV(vol *apis.DeviceVolume, claim string) error {
+	namespace, name, err := device.ParseUndeleteClaim(claim)
+	if err != nil {
+		c.recorder.Eventf(vol, corev1.EventTypeWarning, ReasonUndeleteFailed, "%v", err)
+		return device.ClearUndelete(vol)
+	}
+	pv, err := c.buildUndeletedPV(vol, namespace, name)
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeclientset.CoreV1().PersistentVolumes().Create(context.TODO(), pv, metav1.CreateOptions{})
+	if err != nil && !k8serror.IsAlreadyExists(err) {
+		c.recorder.Eventf(vol, corev1.EventTypeWarning, ReasonUndeleteFailed,
+			"could not create the persistent volume: %v", err)
+		return err
+	}
+	if err = device.ClearUndelete(vol); err != nil {
+		return err
+	}
+	c.recorder.Eventf(vol, corev1.EventTypeNormal, ReasonVolumeUndeleted,
+		"volume undeleted for claim %s/%s", namespace, name)
+	return nil
+}
+
+// buildUndeletedPV builds the PersistentVolume of the undeleted volume,
+// bound to the claim and deleted with it. The storage class and the access
+// mode are the ones of the claim, if it exists already.
+func (c *VolController) buildUndeletedPV(vol *apis.DeviceVolume, namespace string, name string) (*corev1.PersistentVolume, error) {
+	pvBuilder := pvbuilder.NewBuilder(vol, device.DeviceConfiguration.DriverName).
+		WithVolumeMode(device.GetVolumeMode(vol)).
+		WithReclaimPolicy(corev1.PersistentVolumeReclaimDelete).
+		WithClaimRef(namespace, name)
+
+	pvc, err := c.kubeclientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if pvc.Spec.StorageClassName != nil {
+			pvBuilder.WithStorageClass(*pvc.Spec.StorageClassName)
+		}
+		if len(pvc.Spec.AccessModes) > 0 {
+			pvBuilder.WithAccessMode(pvc.Spec.AccessModes[0])
+		}
+	case !k8serror.IsNotFound(err):
+		return nil, err
+	}
+	return pvBuilder.Build()
+}
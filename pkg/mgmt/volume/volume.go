@@ -18,6 +18,7 @@ package volume
 
 import (
 	"fmt"
+	"reflect"
 	"time"
 
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
@@ -27,6 +28,7 @@ import (
 	"k8s.io/klog/v2"
 
 	apis "github.com/openebs/device-localpv/pkg/apis/openebs.io/device/v1alpha1"
+	"github.com/openebs/device-localpv/pkg/collector"
 	"github.com/openebs/device-localpv/pkg/device"
 )
 
@@ -73,13 +75,26 @@ func (c *VolController) enqueueVol(obj interface{}) {
 
 }
 
+// enqueueVolAfter puts the DeviceVolume back onto the work queue once the
+// duration has passed.
+func (c *VolController) enqueueVolAfter(obj interface{}, duration time.Duration) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.workqueue.AddAfter(key, duration)
+}
+
 // syncVol is the function which tries to converge to a desired state for the
 // DeviceVolume
 func (c *VolController) syncVol(vol *apis.DeviceVolume) error {
 	var err error
 	// Device Volume should be deleted. Check if deletion timestamp is set
 	if c.isDeletionCandidate(vol) {
+		start := time.Now()
 		err = device.DestroyVolume(vol)
+		collector.ObserveOperation(collector.OperationDestroy, start, err)
 		if err == nil {
 			err = device.RemoveVolFinalizer(vol)
 		}
@@ -91,17 +106,41 @@ func (c *VolController) syncVol(vol *apis.DeviceVolume) error {
 	case device.DeviceStatusFailed:
 		klog.Warningf("Skipping retrying device volume provisioning as its already in failed state: %+v", vol.Status.Error)
 		return nil
+	case device.DeviceStatusReleased:
+		return c.syncReleasedVol(vol)
 	case device.DeviceStatusReady:
-		klog.Info("device volume already provisioned")
-		return nil
+		// the persistent volume of an undeleted volume is created
+		// once the volume is restored
+		if claim, ok := vol.Annotations[device.UndeleteKey]; ok {
+			return c.createUndeletedPV(vol, claim)
+		}
+		if err = device.RecordPartitionLocation(vol); err != nil {
+			klog.Errorf("could not record the partition location of volume %s: %v", vol.Name, err)
+		}
+		// the spec of the volume may have been modified
+		return device.ReconcileVolume(vol)
 	}
 
 	// if the status Pending means we will try to create the volume
 	if vol.Status.State == device.DeviceStatusPending {
+		start := time.Now()
 		err = device.CreateVolume(vol)
+		collector.ObserveOperation(collector.OperationCreate, start, err)
+		if err == nil && vol.Spec.ImageSource != "" {
+			err = device.PopulateVolume(vol)
+			// the image can never be written, e.g. it is larger than the volume
+			if custError, ok := err.(*apis.VolumeError); ok {
+				if err = device.DestroyVolume(vol); err != nil {
+					return err
+				}
+				vol.Status.Error = custError
+				return device.UpdateVolInfo(vol, device.DeviceStatusFailed)
+			}
+		}
 		if err == nil {
 			err = device.UpdateVolInfo(vol, device.DeviceStatusReady)
-		} else if custError, ok := err.(*apis.VolumeError); ok && custError.Code == apis.InsufficientCapacity {
+		} else if custError, ok := err.(*apis.VolumeError); ok &&
+			(custError.Code == apis.InsufficientCapacity || custError.Code == apis.ImportFailed) {
 			vol.Status.Error = custError
 			return device.UpdateVolInfo(vol, device.DeviceStatusFailed)
 		}
@@ -140,6 +179,22 @@ func (c *VolController) updateVol(oldObj, newObj interface{}) {
 	if c.isDeletionCandidate(newVol) {
 		klog.Infof("Got update event for deleted Vol %s", newVol.Name)
 		c.enqueueVol(newVol)
+		return
+	}
+
+	oldVol, ok := oldObj.(*apis.DeviceVolume)
+	if ok && !reflect.DeepEqual(oldVol.Spec, newVol.Spec) {
+		klog.Infof("Got update event for modified Vol %s", newVol.Name)
+		c.enqueueVol(newVol)
+		return
+	}
+
+	// the volumes moved to the recycle bin, or undeleted
+	if ok && newVol.Status.State == device.DeviceStatusReleased &&
+		(!reflect.DeepEqual(oldVol.Status, newVol.Status) ||
+			oldVol.Annotations[device.UndeleteKey] != newVol.Annotations[device.UndeleteKey]) {
+		klog.Infof("Got update event for released Vol %s", newVol.Name)
+		c.enqueueVol(newVol)
 	}
 }
 